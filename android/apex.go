@@ -56,6 +56,11 @@ type ApexModule interface {
 	// This is a shortcut for ApexName() == ""
 	IsForPlatform() bool
 
+	// Returns the names of the APEXes that this module is packaged into, sorted. Every variant
+	// of the module, including the platform variant, reports the same list.
+	// Call this after apex.apexMutator is run.
+	InApexes() []string
+
 	// Tests if this module could have APEX variants. APEX variants are
 	// created only for the modules that returns true here. This is useful
 	// for not creating APEX variants for certain types of shared libraries
@@ -79,6 +84,9 @@ type ApexModule interface {
 type ApexProperties struct {
 	// Name of the apex variant that this module is mutated into
 	ApexName string `blueprint:"mutated"`
+
+	// Names of the APEXes that this module is packaged into
+	InApexes []string `blueprint:"mutated"`
 }
 
 // Provides default implementation for the ApexModule interface. APEX-aware
@@ -112,6 +120,10 @@ func (m *ApexModuleBase) IsForPlatform() bool {
 	return m.ApexProperties.ApexName == ""
 }
 
+func (m *ApexModuleBase) InApexes() []string {
+	return m.ApexProperties.InApexes
+}
+
 func (m *ApexModuleBase) setApexName(apexName string) {
 	m.ApexProperties.ApexName = apexName
 }
@@ -128,6 +140,7 @@ func (m *ApexModuleBase) IsInstallableToApex() bool {
 func (m *ApexModuleBase) CreateApexVariations(mctx BottomUpMutatorContext) []blueprint.Module {
 	if len(m.apexVariations) > 0 {
 		sort.Strings(m.apexVariations)
+		m.ApexProperties.InApexes = CopyOf(m.apexVariations)
 		variations := []string{""} // Original variation for platform
 		variations = append(variations, m.apexVariations...)
 
@@ -228,6 +241,33 @@ func GetApexesForModule(moduleName string) []string {
 	return ret
 }
 
+var apexDefinesMutex sync.Mutex
+var apexDefinesKey = NewOnceKey("apexDefines")
+
+// apexDefinesMap()["bar"] is the list of macros that the native modules built for APEX bar
+// are compiled with.
+func apexDefinesMap() map[string][]string {
+	return apexData.Once(apexDefinesKey, func() interface{} {
+		return make(map[string][]string)
+	}).(map[string][]string)
+}
+
+// Set the macros, e.g. "FOO=1", that the native modules built for an APEX named apexName are
+// compiled with.
+func SetApexDefines(apexName string, defines []string) {
+	apexDefinesMutex.Lock()
+	defer apexDefinesMutex.Unlock()
+	apexDefinesMap()[apexName] = defines
+}
+
+// Returns the macros that the native modules built for an APEX named apexName are compiled
+// with.
+func ApexDefines(apexName string) []string {
+	apexDefinesMutex.Lock()
+	defer apexDefinesMutex.Unlock()
+	return apexDefinesMap()[apexName]
+}
+
 func InitApexModule(m ApexModule) {
 	base := m.apexModuleBase()
 	base.canHaveApexVariants = true
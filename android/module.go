@@ -131,6 +131,7 @@ type ModuleContext interface {
 	InstallInData() bool
 	InstallInSanitizerDir() bool
 	InstallInRecovery() bool
+	InstallPartition() string
 
 	RequiredModuleNames() []string
 
@@ -187,6 +188,7 @@ type Module interface {
 	InstallInData() bool
 	InstallInSanitizerDir() bool
 	InstallInRecovery() bool
+	InstallPartition() string
 	SkipInstall()
 	ExportedToMake() bool
 	NoticeFile() OptionalPath
@@ -664,6 +666,13 @@ func (p *ModuleBase) InstallInRecovery() bool {
 	return Bool(p.commonProperties.Recovery)
 }
 
+// Returns one of InstallPartitions to install the module to instead of the partition that
+// follows from whether it is vendor, odm, product or product_services specific, or the empty
+// string to use that partition.
+func (p *ModuleBase) InstallPartition() string {
+	return ""
+}
+
 func (a *ModuleBase) Owner() string {
 	return String(a.commonProperties.Owner)
 }
@@ -1260,6 +1269,10 @@ func (a *androidModuleContext) InstallInRecovery() bool {
 	return a.module.InstallInRecovery()
 }
 
+func (a *androidModuleContext) InstallPartition() string {
+	return a.module.InstallPartition()
+}
+
 func (a *androidModuleContext) skipInstall(fullInstallPath OutputPath) bool {
 	if a.module.base().commonProperties.SkipInstall {
 		return true
@@ -137,12 +137,16 @@ type TopDownMutatorContext interface {
 	// GetWalkPath is supposed to be called in visit function passed in WalkDeps()
 	// and returns a top-down dependency path from a start module to current child module.
 	GetWalkPath() []Module
+	// GetTagPath is supposed to be called in visit function passed in WalkDeps()
+	// and returns the dependency tags of the edges in the path returned by GetWalkPath().
+	GetTagPath() []blueprint.DependencyTag
 }
 
 type androidTopDownMutatorContext struct {
 	blueprint.TopDownMutatorContext
 	androidBaseContextImpl
 	walkPath []Module
+	tagPath  []blueprint.DependencyTag
 }
 
 type AndroidBottomUpMutator func(BottomUpMutatorContext)
@@ -289,15 +293,18 @@ func (a *androidTopDownMutatorContext) VisitDepsDepthFirstIf(pred func(Module) b
 
 func (a *androidTopDownMutatorContext) WalkDeps(visit func(Module, Module) bool) {
 	a.walkPath = []Module{a.Module()}
+	a.tagPath = []blueprint.DependencyTag{}
 	a.TopDownMutatorContext.WalkDeps(func(child, parent blueprint.Module) bool {
 		childAndroidModule, _ := child.(Module)
 		parentAndroidModule, _ := parent.(Module)
 		if childAndroidModule != nil && parentAndroidModule != nil {
-			// record walkPath before visit
+			// record walkPath and tagPath before visit
 			for a.walkPath[len(a.walkPath)-1] != parentAndroidModule {
 				a.walkPath = a.walkPath[0 : len(a.walkPath)-1]
+				a.tagPath = a.tagPath[0 : len(a.tagPath)-1]
 			}
 			a.walkPath = append(a.walkPath, childAndroidModule)
+			a.tagPath = append(a.tagPath, a.OtherModuleDependencyTag(childAndroidModule))
 			return visit(childAndroidModule, parentAndroidModule)
 		} else {
 			return false
@@ -309,6 +316,10 @@ func (a *androidTopDownMutatorContext) GetWalkPath() []Module {
 	return a.walkPath
 }
 
+func (a *androidTopDownMutatorContext) GetTagPath() []blueprint.DependencyTag {
+	return a.tagPath
+}
+
 func (a *androidTopDownMutatorContext) AppendProperties(props ...interface{}) {
 	for _, p := range props {
 		err := proptools.AppendMatchingProperties(a.Module().base().customizableProperties,
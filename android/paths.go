@@ -48,6 +48,7 @@ type ModuleInstallPathContext interface {
 	InstallInData() bool
 	InstallInSanitizerDir() bool
 	InstallInRecovery() bool
+	InstallPartition() string
 }
 
 var _ ModuleInstallPathContext = ModuleContext(nil)
@@ -298,6 +299,42 @@ func PathsAndMissingDepsForModuleSrcExcludes(ctx ModuleContext, paths, excludes
 	return expandedSrcFiles, append(missingDeps, missingExcludeDeps...)
 }
 
+// ModuleSrcFilesExcludes returns the files that paths expands to relative to the module's local
+// source directory, excluding paths listed in the excludes argument.  It expands globs and matches
+// the excludes the same way as PathsForModuleSrcExcludes, but can be used before the path
+// dependencies of the module have been resolved, e.g. from a mutator, so references to
+// SourceFileProducer modules in paths and excludes are skipped and missing files are not reported.
+func ModuleSrcFilesExcludes(ctx BaseModuleContext, paths, excludes []string) []string {
+	prefix := filepath.Join(ctx.Config().srcDir, ctx.ModuleDir())
+
+	var expandedExcludes []string
+	for _, e := range excludes {
+		if SrcIsModule(e) == "" {
+			expandedExcludes = append(expandedExcludes, filepath.Join(prefix, e))
+		}
+	}
+
+	var ret []string
+	for _, s := range paths {
+		if SrcIsModule(s) != "" {
+			continue
+		} else if pathtools.IsGlob(s) {
+			files, err := ctx.GlobWithDeps(filepath.Join(prefix, s), expandedExcludes)
+			if err != nil {
+				ctx.ModuleErrorf("glob: %s", err.Error())
+			}
+			for _, f := range files {
+				if !strings.HasSuffix(f, "/") {
+					ret = append(ret, f)
+				}
+			}
+		} else if p := filepath.Join(prefix, s); findStringInSlice(p, expandedExcludes) < 0 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
 type missingDependencyError struct {
 	missingDeps []string
 }
@@ -1120,6 +1157,8 @@ func modulePartition(ctx ModuleInstallPathContext) string {
 	} else if ctx.InstallInRecovery() {
 		// the layout of recovery partion is the same as that of system partition
 		partition = "recovery/root/system"
+	} else if p := ctx.InstallPartition(); p != "" {
+		partition = installPartitionPath(ctx, p)
 	} else if ctx.SocSpecific() {
 		partition = ctx.DeviceConfig().VendorPath()
 	} else if ctx.DeviceSpecific() {
@@ -1137,6 +1176,26 @@ func modulePartition(ctx ModuleInstallPathContext) string {
 	return partition
 }
 
+// The partitions that a module can explicitly be installed to, regardless of whether it is
+// vendor, odm, product or product_services specific.
+var InstallPartitions = []string{"system", "vendor", "odm", "product", "product_services"}
+
+// Returns the path in the product out directory of one of InstallPartitions.
+func installPartitionPath(ctx ModuleInstallPathContext, partition string) string {
+	switch partition {
+	case "vendor":
+		return ctx.DeviceConfig().VendorPath()
+	case "odm":
+		return ctx.DeviceConfig().OdmPath()
+	case "product":
+		return ctx.DeviceConfig().ProductPath()
+	case "product_services":
+		return ctx.DeviceConfig().ProductServicesPath()
+	default:
+		return partition
+	}
+}
+
 // validateSafePath validates a path that we trust (may contain ninja variables).
 // Ensures that each path component does not attempt to leave its component.
 func validateSafePath(pathComponents ...string) (string, error) {
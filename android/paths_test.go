@@ -202,9 +202,10 @@ func p(in interface{}) string {
 type moduleInstallPathContextImpl struct {
 	androidBaseContextImpl
 
-	inData         bool
-	inSanitizerDir bool
-	inRecovery     bool
+	inData           bool
+	inSanitizerDir   bool
+	inRecovery       bool
+	installPartition string
 }
 
 func (moduleInstallPathContextImpl) Fs() pathtools.FileSystem {
@@ -229,6 +230,10 @@ func (m moduleInstallPathContextImpl) InstallInRecovery() bool {
 	return m.inRecovery
 }
 
+func (m moduleInstallPathContextImpl) InstallPartition() string {
+	return m.installPartition
+}
+
 func TestPathForModuleInstall(t *testing.T) {
 	testConfig := TestConfig("", nil)
 
@@ -306,6 +311,18 @@ func TestPathForModuleInstall(t *testing.T) {
 			in:  []string{"bin", "my_test"},
 			out: "target/product/test_device/product_services/bin/my_test",
 		},
+		{
+			name: "vendor binary installed to product_services",
+			ctx: &moduleInstallPathContextImpl{
+				androidBaseContextImpl: androidBaseContextImpl{
+					target: deviceTarget,
+					kind:   socSpecificModule,
+				},
+				installPartition: "product_services",
+			},
+			in:  []string{"bin", "my_test"},
+			out: "target/product/test_device/product_services/bin/my_test",
+		},
 
 		{
 			name: "system native test binary",
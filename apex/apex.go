@@ -15,9 +15,11 @@
 package apex
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strings"
@@ -90,6 +92,49 @@ var (
 		CommandDeps: []string{"${zip2zip}"},
 		Description: "app bundle",
 	}, "abi")
+
+	// Truncates an APEX manifest to half of its size, which cuts off the closing brace of the
+	// object, so that the manifest is never valid JSON whatever fields it has.
+	corruptApexManifestRule = pctx.StaticRule("corruptApexManifestRule", blueprint.RuleParams{
+		Command:     `size=$$(wc -c < ${in}) && head -c $$((size / 2)) ${in} > ${out}`,
+		Description: "corrupt manifest ${out}",
+	})
+
+	// Adds fields, e.g. the post-install hook, to an APEX manifest.
+	apexManifestFieldsRule = pctx.StaticRule("apexManifestFieldsRule", blueprint.RuleParams{
+		Command:     `${jsonmodify} ${fields} ${in} ${out}`,
+		CommandDeps: []string{"${jsonmodify}"},
+		Description: "add fields to manifest ${out}",
+	}, "fields")
+
+	// Extracts the uncompressed payload image from an APEX so that it can be inspected directly.
+	apexPayloadImageRule = pctx.StaticRule("apexPayloadImageRule", blueprint.RuleParams{
+		Command:     `unzip -p ${in} apex_payload.img > ${out}`,
+		Description: "extract payload image ${out}",
+	})
+
+	// Strips a native library or executable of the payload regardless of how its module is
+	// configured to be stripped.
+	apexStripPayloadRule = pctx.StaticRule("apexStripPayloadRule", blueprint.RuleParams{
+		Command:     `${config.ClangBin}/llvm-strip ${stripFlags} ${in} -o ${out}`,
+		CommandDeps: []string{"${config.ClangBin}/llvm-strip"},
+		Description: "strip ${out}",
+	}, "stripFlags")
+
+	// Fails if the variants of a file for the other architectures differ from the one in the APEX.
+	apexArchInvariantCheckRule = pctx.StaticRule("apexArchInvariantCheckRule", blueprint.RuleParams{
+		Command: `rm -f ${out} && for f in ${others}; do ` +
+			`if ! cmp -s ${in} $$f; then ` +
+			`echo "error: ${file} must be identical for all architectures, but ${in} and $$f differ" >&2 && exit 1; ` +
+			`fi; done && touch ${out}`,
+		Description: "check arch invariant ${file}",
+	}, "others", "file")
+
+	apexDependencySbomRule = pctx.StaticRule("apexDependencySbomRule", blueprint.RuleParams{
+		Command:     `${apex_sbom} -o ${out} ${opt_flags} ${entries}`,
+		CommandDeps: []string{"${apex_sbom}"},
+		Description: "dependency SBOM ${out}",
+	}, "opt_flags", "entries")
 )
 
 var imageApexSuffix = ".apex"
@@ -104,16 +149,20 @@ type dependencyTag struct {
 }
 
 var (
-	sharedLibTag   = dependencyTag{name: "sharedLib"}
-	executableTag  = dependencyTag{name: "executable"}
-	javaLibTag     = dependencyTag{name: "javaLib"}
-	prebuiltTag    = dependencyTag{name: "prebuilt"}
-	keyTag         = dependencyTag{name: "key"}
-	certificateTag = dependencyTag{name: "certificate"}
+	sharedLibTag       = dependencyTag{name: "sharedLib"}
+	executableTag      = dependencyTag{name: "executable"}
+	javaLibTag         = dependencyTag{name: "javaLib"}
+	prebuiltTag        = dependencyTag{name: "prebuilt"}
+	keyTag             = dependencyTag{name: "key"}
+	certificateTag     = dependencyTag{name: "certificate"}
+	postInstallHookTag = dependencyTag{name: "postInstallHook"}
+	// the prebuilts for the other architectures than the primary one, see arch_invariant_files
+	archVariantPrebuiltTag = dependencyTag{name: "archVariantPrebuilt"}
 )
 
 func init() {
 	pctx.Import("android/soong/android")
+	pctx.Import("android/soong/cc/config")
 	pctx.Import("android/soong/java")
 	pctx.HostBinToolVariable("apexer", "apexer")
 	// ART minimal builds (using the master-art manifest) do not have the "frameworks/base"
@@ -128,8 +177,10 @@ func init() {
 		})
 	}
 	hostBinToolVariableWithPrebuilt("aapt2", "prebuilts/sdk/tools", "aapt2")
+	pctx.HostBinToolVariable("apex_sbom", "apex_sbom")
 	pctx.HostBinToolVariable("avbtool", "avbtool")
 	pctx.HostBinToolVariable("e2fsdroid", "e2fsdroid")
+	pctx.SourcePathVariable("jsonmodify", "build/soong/scripts/jsonmodify.py")
 	pctx.HostBinToolVariable("merge_zips", "merge_zips")
 	pctx.HostBinToolVariable("mke2fs", "mke2fs")
 	pctx.HostBinToolVariable("resize2fs", "resize2fs")
@@ -154,6 +205,7 @@ func init() {
 func apexDepsMutator(mctx android.TopDownMutatorContext) {
 	if a, ok := mctx.Module().(*apexBundle); ok {
 		apexBundleName := mctx.ModuleName()
+		android.SetApexDefines(apexBundleName, a.properties.Defines)
 		mctx.WalkDeps(func(child, parent android.Module) bool {
 			depName := mctx.OtherModuleName(child)
 			// If the parent is apexBundle, this child is directly depended.
@@ -241,6 +293,11 @@ type apexBundleProperties struct {
 	// List of prebuilt files that are embedded inside this APEX bundle
 	Prebuilts []string
 
+	// Name of a cc_binary or sh_binary module that is embedded inside this APEX bundle and
+	// recorded as the postInstallHook in its manifest, so that apexd runs it after the APEX
+	// is activated.
+	Post_install_hook *string
+
 	// Name of the apex_key module that provides the private key to sign APEX
 	Key *string
 
@@ -262,8 +319,62 @@ type apexBundleProperties struct {
 	// For telling the apex to ignore special handling for system libraries such as bionic. Default is false.
 	Ignore_system_library_special_case *bool
 
+	// List of macros, e.g. "FOO=1", to define with -D when compiling the native modules for
+	// this APEX bundle. The variants of the modules built for the platform are unaffected.
+	Defines []string
+
+	// Names of native libraries in this APEX bundle to install to lib[64]/bionic like the bionic
+	// libraries, so that they are not in the search path of the APEX. Applies even when
+	// ignore_system_library_special_case is set.
+	Bootstrap_libs []string
+
 	Multilib apexMultilibProperties
 
+	// List of symlinks to create in this APEX bundle, each in the form of "<path>:<target>", e.g.
+	// "bin/sh:toybox". <path> is relative to the root of the APEX and <target> is used as is for
+	// the destination of the symlink.
+	Symlinks []string
+
+	// Whether to generate a dependency SBOM listing the files in this APEX bundle and the modules
+	// they come from. Default: false.
+	Dependency_sbom *bool
+
+	// Whether the entries of the dependency SBOM include the build-id of ELF files, so that they
+	// can be matched against symbol servers and vulnerability databases. Default: false.
+	Dependency_sbom_build_ids *bool
+
+	// Whether the dependency SBOM is written as an SPDX 2.2 JSON document, with the build ID as
+	// the version of the APEX and of the files in it, instead of a plain JSON list. Default: false.
+	Dependency_sbom_spdx *bool
+
+	// Whether to replace the manifest of this APEX bundle with a malformed one, to exercise the
+	// activation failure paths on the device. Only allowed for apex_test. Default: false.
+	Test_only_corrupt_manifest *bool
+
+	// Whether to also build <name>-slim.apex, which leaves out the files that come from test
+	// modules, e.g. to measure how much the tests add to the size of the APEX. Only allowed for
+	// apex_test. Default: false.
+	Slim *bool
+
+	// List of paths of files in this APEX bundle, e.g. "etc/foo.conf", that must be identical
+	// for all the architectures that their modules are built for. Only prebuilts are supported.
+	Arch_invariant_files []string
+
+	// Linker configuration of this APEX bundle, e.g. the namespaces of its libraries, in the binary
+	// protobuf format read by linkerconfig on the device. It is installed to etc/linker.config.pb
+	// in the APEX bundle.
+	Linker_config *string `android:"path"`
+
+	// List of "name=value" system properties that this APEX bundle provides. They are recorded
+	// as the providedSysprops in its manifest and installed to etc/build.prop in the APEX bundle.
+	Provided_sysprops []string
+
+	// Overrides how the native libraries and executables of the payload are stripped, regardless
+	// of the strip properties of their modules. Either "all" to strip all symbols and debug info,
+	// "keep_symbols" to strip the debug info but keep the symbol table, or "none" to use the files
+	// as built by their modules. Default: "none".
+	Strip_payload *string
+
 	// List of sanitizer names that this APEX is enabled for
 	SanitizerNames []string `blueprint:"mutated"`
 }
@@ -277,6 +388,10 @@ type apexTargetBundleProperties struct {
 		// Multilib properties only for host.
 		Host struct {
 			Multilib apexMultilibProperties
+
+			// Directory, relative to the host output directory, e.g. "apex_fixtures/foo", to
+			// install the APEX bundle and the list of its files to, for quick local testing.
+			Test_fixture_dir *string
 		}
 		// Multilib properties only for host linux_bionic.
 		Linux_bionic struct {
@@ -286,6 +401,12 @@ type apexTargetBundleProperties struct {
 		Linux_glibc struct {
 			Multilib apexMultilibProperties
 		}
+		// Properties only for the APEX bundle built with the vendor variants, i.e. when
+		// use_vendor is set.
+		Vendor struct {
+			// Overrides file_contexts when the APEX bundle is built for the vendor partition.
+			File_contexts *string
+		}
 	}
 }
 
@@ -375,6 +496,12 @@ type apexFile struct {
 	symlinks   []string
 }
 
+// apexSymlink is a symlink that is created in the APEX payload without being tied to a module.
+type apexSymlink struct {
+	path   string
+	target string
+}
+
 type apexBundle struct {
 	android.ModuleBase
 	android.DefaultableModuleBase
@@ -397,9 +524,42 @@ type apexBundle struct {
 	// list of files to be included in this apex
 	filesInfo []apexFile
 
+	// list of symlinks to be created in this apex
+	symlinks []apexSymlink
+
 	// list of module names that this APEX is depending on
 	externalDeps []string
 
+	dependencySbom android.WritablePath
+
+	// the uncompressed payload image of the image APEX
+	payloadImage android.WritablePath
+
+	// JSON file describing the files in the payload of this apex
+	contentsJson android.WritablePath
+
+	// checks that the files listed in arch_invariant_files are identical for all architectures
+	archInvariantChecks android.Paths
+
+	// report of the files from different variants that are installed to the same path in the APEX
+	duplicatesReport android.WritablePath
+
+	// path in the APEX of the binary set by post_install_hook
+	postInstallHook string
+
+	// names and values of the valid provided_sysprops
+	syspropNames  []string
+	syspropValues []string
+
+	// the manifest with the post-install hook and the provided system properties recorded in it
+	manifestWithFields android.Path
+
+	// the APEX without the files of test modules, see slim
+	slimOutputFile android.WritablePath
+
+	// the NOTICE embedded in the image APEX, shared with the slim APEX
+	noticeOutput android.OptionalPath
+
 	flattened bool
 
 	testApex bool
@@ -484,6 +644,18 @@ func (a *apexBundle) DepsMutator(ctx android.BottomUpMutatorContext) {
 			ctx.AddFarVariationDependencies([]blueprint.Variation{
 				{Mutator: "arch", Variation: target.String()},
 			}, prebuiltTag, a.properties.Prebuilts...)
+
+			if hook := String(a.properties.Post_install_hook); hook != "" {
+				ctx.AddFarVariationDependencies([]blueprint.Variation{
+					{Mutator: "arch", Variation: target.String()},
+					{Mutator: "image", Variation: a.getImageVariation(config)},
+				}, postInstallHookTag, hook)
+			}
+		} else if len(a.properties.Arch_invariant_files) > 0 {
+			// The prebuilts for the other architectures are only compared to the ones in the APEX.
+			ctx.AddFarVariationDependencies([]blueprint.Variation{
+				{Mutator: "arch", Variation: target.String()},
+			}, archVariantPrebuiltTag, a.properties.Prebuilts...)
 		}
 
 		switch target.Arch.ArchType.Multilib {
@@ -570,6 +742,69 @@ func (a *apexBundle) getImageVariation(config android.DeviceConfig) string {
 	}
 }
 
+// fileContextsName returns the name of the file contexts file for the image variation the contents
+// of this APEX bundle are taken from.
+func (a *apexBundle) fileContextsName(ctx android.ModuleContext) string {
+	if a.getImageVariation(ctx.DeviceConfig()) == "vendor" && a.targetProperties.Target.Vendor.File_contexts != nil {
+		return *a.targetProperties.Target.Vendor.File_contexts
+	}
+	return proptools.StringDefault(a.properties.File_contexts, ctx.ModuleName())
+}
+
+type partitionSpecific interface {
+	DeviceSpecific() bool
+	SocSpecific() bool
+	ProductSpecific() bool
+	ProductServicesSpecific() bool
+}
+
+// partitionOf returns the name of the partition that m is installed to when it is not in an APEX.
+func partitionOf(m partitionSpecific) string {
+	switch {
+	case m.SocSpecific():
+		return "vendor"
+	case m.DeviceSpecific():
+		return "odm"
+	case m.ProductSpecific():
+		return "product"
+	case m.ProductServicesSpecific():
+		return "product_services"
+	default:
+		return "system"
+	}
+}
+
+// checkPartitions reports an error for each module in the APEX bundle that is meant for another
+// partition than the APEX bundle itself, e.g. a vendor library in a system APEX. Platform modules
+// can be used in the APEX bundles of any partition.
+func (a *apexBundle) checkPartitions(ctx android.ModuleContext, filesInfo []apexFile) {
+	apexPartition := partitionOf(ctx)
+	for _, f := range filesInfo {
+		m, ok := f.module.(partitionSpecific)
+		if !ok {
+			continue
+		}
+		if partition := partitionOf(m); partition != "system" && partition != apexPartition {
+			ctx.ModuleErrorf("%q is built for the %s partition, but the APEX is installed to the %s partition",
+				ctx.OtherModuleName(f.module), partition, apexPartition)
+		}
+	}
+}
+
+// checkNoStubs reports the native libraries in the payload that are stubs variants. Stubs only
+// provide the ABI of a library for linking, and would fail at runtime if installed in its place.
+func (a *apexBundle) checkNoStubs(ctx android.ModuleContext, filesInfo []apexFile) {
+	for _, f := range filesInfo {
+		if f.class != nativeSharedLib {
+			continue
+		}
+		if c, ok := f.module.(*cc.Module); ok && c.IsStubs() {
+			ctx.ModuleErrorf("%q is a stubs variant, which cannot be installed in the APEX",
+				ctx.OtherModuleName(c))
+		}
+	}
+}
+
 func (a *apexBundle) EnableSanitizer(sanitizerName string) {
 	if !android.InList(sanitizerName, a.properties.SanitizerNames) {
 		a.properties.SanitizerNames = append(a.properties.SanitizerNames, sanitizerName)
@@ -594,7 +829,11 @@ func (a *apexBundle) IsSanitizerEnabled(ctx android.BaseModuleContext, sanitizer
 	return android.InList(sanitizerName, globalSanitizerNames)
 }
 
-func getCopyManifestForNativeLibrary(cc *cc.Module, handleSpecialLibs bool) (fileToCopy android.Path, dirInApex string) {
+// Names of the bionic libraries, which are installed to lib[64]/bionic unless
+// ignore_system_library_special_case is set.
+var bionicBootstrapLibs = []string{"libc", "libm", "libdl"}
+
+func getCopyManifestForNativeLibrary(cc *cc.Module, bootstrapLibs []string) (fileToCopy android.Path, dirInApex string) {
 	// Decide the APEX-local directory by the multilib of the library
 	// In the future, we may query this to the module.
 	switch cc.Arch().ArchType.Multilib {
@@ -607,20 +846,17 @@ func getCopyManifestForNativeLibrary(cc *cc.Module, handleSpecialLibs bool) (fil
 	if !cc.Arch().Native {
 		dirInApex = filepath.Join(dirInApex, cc.Arch().ArchType.String())
 	}
-	if handleSpecialLibs {
-		switch cc.Name() {
-		case "libc", "libm", "libdl":
-			// Special case for bionic libs. This is to prevent the bionic libs
-			// from being included in the search path /apex/com.android.apex/lib.
-			// This exclusion is required because bionic libs in the runtime APEX
-			// are available via the legacy paths /system/lib/libc.so, etc. By the
-			// init process, the bionic libs in the APEX are bind-mounted to the
-			// legacy paths and thus will be loaded into the default linker namespace.
-			// If the bionic libs are directly in /apex/com.android.apex/lib then
-			// the same libs will be again loaded to the runtime linker namespace,
-			// which will result double loading of bionic libs that isn't supported.
-			dirInApex = filepath.Join(dirInApex, "bionic")
-		}
+	if android.InList(cc.Name(), bootstrapLibs) {
+		// Special case for bionic libs. This is to prevent the bionic libs
+		// from being included in the search path /apex/com.android.apex/lib.
+		// This exclusion is required because bionic libs in the runtime APEX
+		// are available via the legacy paths /system/lib/libc.so, etc. By the
+		// init process, the bionic libs in the APEX are bind-mounted to the
+		// legacy paths and thus will be loaded into the default linker namespace.
+		// If the bionic libs are directly in /apex/com.android.apex/lib then
+		// the same libs will be again loaded to the runtime linker namespace,
+		// which will result double loading of bionic libs that isn't supported.
+		dirInApex = filepath.Join(dirInApex, "bionic")
 	}
 
 	fileToCopy = cc.OutputFile().Path()
@@ -667,6 +903,155 @@ func getCopyManifestForPrebuiltEtc(prebuilt *android.PrebuiltEtc) (fileToCopy an
 	return
 }
 
+// checkArchInvariantFiles generates rules that compare each file listed in arch_invariant_files to
+// the outputs of its module for the other architectures, and returns their outputs.
+func (a *apexBundle) checkArchInvariantFiles(ctx android.ModuleContext, filesInfo []apexFile,
+	archVariantPrebuilts map[string]android.Paths) android.Paths {
+
+	var checks android.Paths
+	for _, file := range a.properties.Arch_invariant_files {
+		var fileInfo *apexFile
+		for i, f := range filesInfo {
+			if filepath.Join(f.installDir, f.builtFile.Base()) == file {
+				fileInfo = &filesInfo[i]
+				break
+			}
+		}
+		if fileInfo == nil {
+			ctx.PropertyErrorf("arch_invariant_files", "%q is not in the APEX", file)
+			continue
+		}
+		if fileInfo.class != etc || fileInfo.module == nil {
+			ctx.PropertyErrorf("arch_invariant_files", "%q is not a prebuilt, only prebuilts are supported", file)
+			continue
+		}
+		others := archVariantPrebuilts[fileInfo.moduleName]
+		if len(others) == 0 {
+			continue
+		}
+
+		check := android.PathForModuleOut(ctx, "arch_invariant", file+".check")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:      apexArchInvariantCheckRule,
+			Input:     fileInfo.builtFile,
+			Implicits: others,
+			Output:    check,
+			Args: map[string]string{
+				"others": strings.Join(others.Strings(), " "),
+				"file":   file,
+			},
+		})
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// addLinkerConfig adds the linker_config property to filesInfo. It must be the only file installed
+// to etc/linker.config.pb.
+func (a *apexBundle) addLinkerConfig(ctx android.ModuleContext, filesInfo []apexFile) []apexFile {
+	const linkerConfigPath = "etc/linker.config.pb"
+	for _, f := range filesInfo {
+		if filepath.Join(f.installDir, f.builtFile.Base()) == linkerConfigPath {
+			ctx.PropertyErrorf("linker_config", "%q is also installed to %s, remove it to use linker_config",
+				f.moduleName, linkerConfigPath)
+			return filesInfo
+		}
+	}
+
+	// The file is copied so that it has the name it is installed with in the APEX bundle.
+	linkerConfig := android.PathForModuleOut(ctx, filepath.Base(linkerConfigPath))
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   android.Cp,
+		Input:  android.PathForModuleSrc(ctx, String(a.properties.Linker_config)),
+		Output: linkerConfig,
+	})
+	return append(filesInfo, apexFile{linkerConfig, "linker_config", filepath.Dir(linkerConfigPath), etc, nil, nil})
+}
+
+// Returns the names and the values of the provided_sysprops, reporting the entries that are not
+// of the form "name=value".
+func (a *apexBundle) providedSysprops(ctx android.ModuleContext) (names, values []string) {
+	for _, sysprop := range a.properties.Provided_sysprops {
+		split := strings.SplitN(sysprop, "=", 2)
+		if len(split) != 2 || !syspropNameRegexp.MatchString(split[0]) {
+			ctx.PropertyErrorf("provided_sysprops", "%q must be of the form name=value", sysprop)
+			continue
+		}
+		if strings.ContainsAny(split[1], "'\"\\$\n") {
+			ctx.PropertyErrorf("provided_sysprops", "the value of %q must not contain quotes, backslashes, $ or newlines", split[0])
+			continue
+		}
+		names = append(names, split[0])
+		values = append(values, split[1])
+	}
+	return names, values
+}
+
+var syspropNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+$`)
+
+func (a *apexBundle) addSyspropFile(ctx android.ModuleContext, filesInfo []apexFile) []apexFile {
+	const syspropFilePath = "etc/build.prop"
+	for _, f := range filesInfo {
+		if filepath.Join(f.installDir, f.builtFile.Base()) == syspropFilePath {
+			ctx.PropertyErrorf("provided_sysprops", "%q is also installed to %s, remove it to use provided_sysprops",
+				f.moduleName, syspropFilePath)
+			return filesInfo
+		}
+	}
+
+	var lines []string
+	for i := range a.syspropNames {
+		lines = append(lines, a.syspropNames[i]+"="+a.syspropValues[i])
+	}
+	syspropFile := android.PathForModuleOut(ctx, filepath.Base(syspropFilePath))
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.WriteFile,
+		Description: "apex build.prop",
+		Output:      syspropFile,
+		Args: map[string]string{
+			"content": strings.Join(lines, "\\n"),
+		},
+	})
+	return append(filesInfo, apexFile{syspropFile, "provided_sysprops", filepath.Dir(syspropFilePath), etc, nil, nil})
+}
+
+// stripPayload replaces the native libraries and executables in filesInfo with copies stripped as
+// requested by the strip_payload property. The copies are made from the unstripped outputs of the
+// modules, so that modules that are not stripped by default are stripped too.
+func (a *apexBundle) stripPayload(ctx android.ModuleContext, filesInfo []apexFile) {
+	var stripFlags string
+	switch proptools.StringDefault(a.properties.Strip_payload, "none") {
+	case "all":
+		stripFlags = "--strip-all"
+	case "keep_symbols":
+		stripFlags = "--strip-debug"
+	default:
+		return
+	}
+
+	for i, f := range filesInfo {
+		if f.class != nativeSharedLib && f.class != nativeExecutable {
+			continue
+		}
+		c, ok := f.module.(*cc.Module)
+		if !ok || c.UnstrippedOutputFile() == nil {
+			continue
+		}
+		// Files from different modules or arches can have the same path in the APEX.
+		stripped := android.PathForModuleOut(ctx, "stripped", f.moduleName, c.Target().Arch.ArchType.String(),
+			f.installDir, f.builtFile.Base())
+		ctx.Build(pctx, android.BuildParams{
+			Rule:   apexStripPayloadRule,
+			Input:  c.UnstrippedOutputFile(),
+			Output: stripped,
+			Args: map[string]string{
+				"stripFlags": stripFlags,
+			},
+		})
+		filesInfo[i].builtFile = stripped
+	}
+}
+
 func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	filesInfo := []apexFile{}
 
@@ -681,7 +1066,38 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		return
 	}
 
-	handleSpecialLibs := !android.Bool(a.properties.Ignore_system_library_special_case)
+	if proptools.Bool(a.properties.Test_only_corrupt_manifest) && !a.testApex {
+		ctx.PropertyErrorf("test_only_corrupt_manifest", "can only be set for apex_test modules")
+		return
+	}
+
+	if proptools.Bool(a.properties.Slim) {
+		if !a.testApex {
+			ctx.PropertyErrorf("slim", "can only be set for apex_test modules")
+			return
+		}
+		if !a.apexTypes.image() {
+			ctx.PropertyErrorf("slim", "requires an image payload_type")
+			return
+		}
+	}
+
+	switch proptools.StringDefault(a.properties.Strip_payload, "none") {
+	case "all", "keep_symbols", "none":
+	default:
+		ctx.PropertyErrorf("strip_payload", "%q is not one of \"all\", \"keep_symbols\", or \"none\".",
+			*a.properties.Strip_payload)
+		return
+	}
+
+	var bootstrapLibs []string
+	if !android.Bool(a.properties.Ignore_system_library_special_case) {
+		bootstrapLibs = append(bootstrapLibs, bionicBootstrapLibs...)
+	}
+	bootstrapLibs = append(bootstrapLibs, a.properties.Bootstrap_libs...)
+
+	// outputs of the prebuilts for the other architectures, by module name
+	archVariantPrebuilts := make(map[string]android.Paths)
 
 	ctx.WalkDepsBlueprint(func(child, parent blueprint.Module) bool {
 		if _, ok := parent.(*apexBundle); ok {
@@ -691,7 +1107,7 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			switch depTag {
 			case sharedLibTag:
 				if cc, ok := child.(*cc.Module); ok {
-					fileToCopy, dirInApex := getCopyManifestForNativeLibrary(cc, handleSpecialLibs)
+					fileToCopy, dirInApex := getCopyManifestForNativeLibrary(cc, bootstrapLibs)
 					filesInfo = append(filesInfo, apexFile{fileToCopy, depName, dirInApex, nativeSharedLib, cc, nil})
 					return true
 				} else {
@@ -722,6 +1138,19 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 				} else {
 					ctx.PropertyErrorf("binaries", "%q is neither cc_binary, (embedded) py_binary, (host) blueprint_go_binary, (host) bootstrap_go_binary, nor sh_binary", depName)
 				}
+			case postInstallHookTag:
+				if cc, ok := child.(*cc.Module); ok {
+					fileToCopy, dirInApex := getCopyManifestForExecutable(cc)
+					filesInfo = append(filesInfo, apexFile{fileToCopy, depName, dirInApex, nativeExecutable, cc, cc.Symlinks()})
+					a.postInstallHook = filepath.Join(dirInApex, fileToCopy.Base())
+					return true
+				} else if sh, ok := child.(*android.ShBinary); ok {
+					fileToCopy, dirInApex := getCopyManifestForShBinary(sh)
+					filesInfo = append(filesInfo, apexFile{fileToCopy, depName, dirInApex, shBinary, sh, nil})
+					a.postInstallHook = filepath.Join(dirInApex, fileToCopy.Base())
+				} else {
+					ctx.PropertyErrorf("post_install_hook", "%q is neither cc_binary nor sh_binary", depName)
+				}
 			case javaLibTag:
 				if java, ok := child.(*java.Library); ok {
 					fileToCopy, dirInApex := getCopyManifestForJavaLibrary(java)
@@ -742,6 +1171,11 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 				} else {
 					ctx.PropertyErrorf("prebuilts", "%q is not a prebuilt_etc module", depName)
 				}
+			case archVariantPrebuiltTag:
+				if prebuilt, ok := child.(*android.PrebuiltEtc); ok {
+					archVariantPrebuilts[depName] = append(archVariantPrebuilts[depName], prebuilt.OutputFile())
+				}
+				return false
 			case keyTag:
 				if key, ok := child.(*apexKey); ok {
 					a.private_key_file = key.private_key_file
@@ -778,7 +1212,7 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 						return false
 					}
 					depName := ctx.OtherModuleName(child)
-					fileToCopy, dirInApex := getCopyManifestForNativeLibrary(cc, handleSpecialLibs)
+					fileToCopy, dirInApex := getCopyManifestForNativeLibrary(cc, bootstrapLibs)
 					filesInfo = append(filesInfo, apexFile{fileToCopy, depName, dirInApex, nativeSharedLib, cc, nil})
 					return true
 				}
@@ -806,12 +1240,35 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		return result
 	}
 	filesInfo = removeDup(filesInfo)
+	a.buildDuplicatesReport(ctx, filesInfo)
+	if a.properties.Linker_config != nil {
+		filesInfo = a.addLinkerConfig(ctx, filesInfo)
+	}
+	a.syspropNames, a.syspropValues = a.providedSysprops(ctx)
+	if len(a.syspropNames) > 0 {
+		filesInfo = a.addSyspropFile(ctx, filesInfo)
+	}
+	a.stripPayload(ctx, filesInfo)
+
+	if !a.Host() {
+		a.checkPartitions(ctx, filesInfo)
+		a.checkNoStubs(ctx, filesInfo)
+	}
 
 	// to have consistent build rules
 	sort.Slice(filesInfo, func(i, j int) bool {
 		return filesInfo[i].builtFile.String() < filesInfo[j].builtFile.String()
 	})
 
+	a.symlinks = a.parseSymlinks(ctx, filesInfo)
+
+	if proptools.Bool(a.properties.Dependency_sbom) {
+		a.buildDependencySbom(ctx, filesInfo)
+	}
+	a.buildContentsJson(ctx, filesInfo)
+
+	a.archInvariantChecks = a.checkArchInvariantFiles(ctx, filesInfo, archVariantPrebuilts)
+
 	// prepend the name of this APEX to the module names. These names will be the names of
 	// modules that will be defined if the APEX is flattened.
 	for i := range filesInfo {
@@ -822,21 +1279,243 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	a.filesInfo = filesInfo
 
 	if a.apexTypes.zip() {
-		a.buildUnflattenedApex(ctx, zipApex)
+		a.buildUnflattenedApex(ctx, zipApex, false)
 	}
 	if a.apexTypes.image() {
 		// Build rule for unflattened APEX is created even when ctx.Config().FlattenApex()
 		// is true. This is to support referencing APEX via ":<module_name" syntax
 		// in other modules. It is in AndroidMk where the selection of flattened
 		// or unflattened APEX is made.
-		a.buildUnflattenedApex(ctx, imageApex)
+		a.buildUnflattenedApex(ctx, imageApex, false)
+		if proptools.Bool(a.properties.Slim) {
+			a.buildUnflattenedApex(ctx, imageApex, true)
+		}
 		a.buildFlattenedApex(ctx)
 	}
+
+	if dir := a.targetProperties.Target.Host.Test_fixture_dir; a.Host() && dir != nil {
+		a.installToTestFixture(ctx, *dir)
+	}
 }
 
-func (a *apexBundle) buildNoticeFile(ctx android.ModuleContext, apexFileName string) android.OptionalPath {
-	noticeFiles := []android.Path{}
+// installToTestFixture installs the built APEX bundles and the list of the files in them to the
+// given directory of the host output directory.
+func (a *apexBundle) installToTestFixture(ctx android.ModuleContext, dir string) {
+	if dir == "" || filepath.IsAbs(dir) || strings.HasPrefix(filepath.Clean(dir), "..") {
+		ctx.PropertyErrorf("target.host.test_fixture_dir",
+			"%q must be a relative path inside the host output directory", dir)
+		return
+	}
+
+	var installedFiles []string
 	for _, f := range a.filesInfo {
+		installedFiles = append(installedFiles, filepath.Join(f.installDir, f.builtFile.Base()))
+	}
+	sort.Strings(installedFiles)
+	installedFilesFile := android.PathForModuleOut(ctx, ctx.ModuleName()+"-installed-files.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.WriteFile,
+		Description: "apex installed files",
+		Output:      installedFilesFile,
+		Args: map[string]string{
+			"content": strings.Join(installedFiles, "\\n"),
+		},
+	})
+
+	fixtureDir := android.PathForModuleInstall(ctx, dir)
+	for _, apexType := range []apexPackaging{imageApex, zipApex} {
+		if output, ok := a.outputFiles[apexType]; ok {
+			ctx.InstallFile(fixtureDir, output.Base(), output)
+		}
+	}
+	ctx.InstallFile(fixtureDir, installedFilesFile.Base(), installedFilesFile)
+}
+
+// parseSymlinks converts the entries of the symlinks property to apexSymlinks, checking that each
+// of them is inside the APEX and doesn't collide with any other file in it.
+func (a *apexBundle) parseSymlinks(ctx android.ModuleContext, filesInfo []apexFile) []apexSymlink {
+	pathsInApex := make(map[string]bool)
+	for _, f := range filesInfo {
+		pathsInApex[filepath.Join(f.installDir, f.builtFile.Base())] = true
+		for _, sym := range f.symlinks {
+			pathsInApex[filepath.Join(f.installDir, sym)] = true
+		}
+	}
+
+	var symlinks []apexSymlink
+	for _, s := range a.properties.Symlinks {
+		split := strings.SplitN(s, ":", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			ctx.PropertyErrorf("symlinks", "%q is not in the form of \"<path>:<target>\"", s)
+			continue
+		}
+		path := filepath.Clean(strings.TrimPrefix(split[0], "/"))
+		if path == "." || path == ".." || strings.HasPrefix(path, "../") {
+			ctx.PropertyErrorf("symlinks", "%q is not a path inside the APEX", split[0])
+			continue
+		}
+		if pathsInApex[path] {
+			ctx.PropertyErrorf("symlinks", "%q conflicts with another file in the APEX", split[0])
+			continue
+		}
+		pathsInApex[path] = true
+		symlinks = append(symlinks, apexSymlink{path: path, target: split[1]})
+	}
+	return symlinks
+}
+
+func (a *apexBundle) buildDependencySbom(ctx android.ModuleContext, filesInfo []apexFile) {
+	var entries []string
+	var inputs android.Paths
+	for _, f := range filesInfo {
+		pathInApex := filepath.Join(f.installDir, f.builtFile.Base())
+		entries = append(entries, pathInApex+":"+f.moduleName+":"+f.builtFile.String())
+		for _, sym := range f.symlinks {
+			entries = append(entries, filepath.Join(f.installDir, sym)+":"+f.moduleName+":"+f.builtFile.String())
+		}
+		inputs = append(inputs, f.builtFile)
+	}
+	// The symlinks that aren't tied to a module come from the APEX itself, and have no file.
+	for _, s := range a.symlinks {
+		entries = append(entries, proptools.NinjaAndShellEscape(s.path+":"+ctx.ModuleName()+":"))
+	}
+
+	var optFlags []string
+	if proptools.Bool(a.properties.Dependency_sbom_build_ids) {
+		optFlags = append(optFlags, "--build_ids")
+	}
+
+	sbomName := ctx.ModuleName() + "-deps-sbom.json"
+	if proptools.Bool(a.properties.Dependency_sbom_spdx) {
+		optFlags = append(optFlags, "--spdx",
+			"--name", proptools.NinjaAndShellEscape(ctx.ModuleName()),
+			"--version", proptools.NinjaAndShellEscape(ctx.Config().BuildId()))
+		sbomName = ctx.ModuleName() + "-deps-sbom.spdx.json"
+	}
+
+	a.dependencySbom = android.PathForModuleOut(ctx, sbomName)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        apexDependencySbomRule,
+		Description: "apex dependency SBOM",
+		Implicits:   inputs,
+		Output:      a.dependencySbom,
+		Args: map[string]string{
+			"opt_flags": strings.Join(optFlags, " "),
+			"entries":   strings.Join(entries, " "),
+		},
+	})
+	ctx.CheckbuildFile(a.dependencySbom)
+}
+
+// apexContentsEntry is an entry of the contents JSON file of an APEX.
+type apexContentsEntry struct {
+	// Path of the file in the source or output tree, unset for symlinks
+	SourcePath string `json:"source_path,omitempty"`
+	// Path of the file inside the APEX
+	InstallPath string `json:"install_path"`
+	// Make class of the file, e.g. SHARED_LIBRARIES, unset for the symlinks of the APEX itself
+	Class string `json:"class,omitempty"`
+	// Name of the module that the file comes from
+	Module string `json:"module"`
+	// Target of the file if it is a symlink
+	SymlinkTarget string `json:"symlink_target,omitempty"`
+}
+
+// ContentsJson returns the JSON file that describes the files in the payload of this APEX, for
+// use by external tools.
+func (a *apexBundle) ContentsJson() android.Path {
+	return a.contentsJson
+}
+
+func (a *apexBundle) buildContentsJson(ctx android.ModuleContext, filesInfo []apexFile) {
+	entries := []apexContentsEntry{}
+	for _, f := range filesInfo {
+		entries = append(entries, apexContentsEntry{
+			SourcePath:  f.builtFile.String(),
+			InstallPath: filepath.Join(f.installDir, f.builtFile.Base()),
+			Class:       f.class.NameInMake(),
+			Module:      f.moduleName,
+		})
+		for _, sym := range f.symlinks {
+			entries = append(entries, apexContentsEntry{
+				InstallPath:   filepath.Join(f.installDir, sym),
+				Class:         f.class.NameInMake(),
+				Module:        f.moduleName,
+				SymlinkTarget: f.builtFile.Base(),
+			})
+		}
+	}
+	for _, s := range a.symlinks {
+		entries = append(entries, apexContentsEntry{
+			InstallPath:   s.path,
+			Module:        ctx.ModuleName(),
+			SymlinkTarget: s.target,
+		})
+	}
+
+	content, err := json.Marshal(entries)
+	if err != nil {
+		ctx.ModuleErrorf("failed to generate contents JSON: %s", err.Error())
+		return
+	}
+
+	a.contentsJson = android.PathForModuleOut(ctx, ctx.ModuleName()+"-contents.json")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.WriteFile,
+		Description: "apex contents JSON",
+		Output:      a.contentsJson,
+		Args: map[string]string{
+			"content": string(content),
+		},
+	})
+	ctx.CheckbuildFile(a.contentsJson)
+}
+
+// buildDuplicatesReport writes the list of the paths in the APEX that several variants of modules
+// are installed to, in which case only one of them ends up in the APEX. Each line is the path
+// followed by the module name and the variant of each of the files, e.g.
+// "lib64/libfoo.so: libfoo (android_arm64_armv8-a_core_shared_myapex), libfoo (...)".
+func (a *apexBundle) buildDuplicatesReport(ctx android.ModuleContext, filesInfo []apexFile) {
+	filesByPath := make(map[string][]apexFile)
+	var paths []string
+	for _, f := range filesInfo {
+		path := filepath.Join(f.installDir, f.builtFile.Base())
+		if _, exists := filesByPath[path]; !exists {
+			paths = append(paths, path)
+		}
+		filesByPath[path] = append(filesByPath[path], f)
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	for _, path := range paths {
+		if len(filesByPath[path]) < 2 {
+			continue
+		}
+		var files []string
+		for _, f := range filesByPath[path] {
+			// The outputs of a module are in the directory of its variant.
+			variant := filepath.Base(filepath.Dir(f.builtFile.String()))
+			files = append(files, fmt.Sprintf("%s (%s)", f.moduleName, variant))
+		}
+		lines = append(lines, path+": "+strings.Join(files, ", "))
+	}
+
+	a.duplicatesReport = android.PathForModuleOut(ctx, ctx.ModuleName()+"-duplicates.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.WriteFile,
+		Description: "apex duplicates report",
+		Output:      a.duplicatesReport,
+		Args: map[string]string{
+			"content": strings.Join(lines, "\\n"),
+		},
+	})
+	ctx.CheckbuildFile(a.duplicatesReport)
+}
+
+func (a *apexBundle) buildNoticeFile(ctx android.ModuleContext, apexFileName string, filesInfo []apexFile) android.OptionalPath {
+	noticeFiles := []android.Path{}
+	for _, f := range filesInfo {
 		if f.module != nil {
 			notice := f.module.NoticeFile()
 			if notice.Valid() {
@@ -857,7 +1536,30 @@ func (a *apexBundle) buildNoticeFile(ctx android.ModuleContext, apexFileName str
 		android.BuildNoticeOutput(ctx, a.installDir, apexFileName, android.FirstUniquePaths(noticeFiles)))
 }
 
-func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType apexPackaging) {
+// slimFilesInfo returns the files of this APEX that don't come from test modules.
+func (a *apexBundle) slimFilesInfo() []apexFile {
+	var filesInfo []apexFile
+	for _, f := range a.filesInfo {
+		if c, ok := f.module.(*cc.Module); ok && c.IsTest() {
+			continue
+		}
+		filesInfo = append(filesInfo, f)
+	}
+	return filesInfo
+}
+
+// SlimOutputFile returns the APEX built without the files of test modules, or nil if slim is not
+// set.
+func (a *apexBundle) SlimOutputFile() android.Path {
+	if a.slimOutputFile == nil {
+		return nil
+	}
+	return a.slimOutputFile
+}
+
+// buildUnflattenedApex builds the APEX of the given type. If slim is true, the APEX is built
+// without the files of test modules, and is neither installed nor bundled.
+func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType apexPackaging, slim bool) {
 	cert := String(a.properties.Certificate)
 	if cert != "" && android.SrcIsModule(cert) == "" {
 		defaultDir := ctx.Config().DefaultAppCertificateDir(ctx)
@@ -869,7 +1571,18 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 		a.container_private_key_file = key
 	}
 
-	manifest := android.PathForModuleSrc(ctx, proptools.StringDefault(a.properties.Manifest, "apex_manifest.json"))
+	var manifest android.Path = android.PathForModuleSrc(ctx, proptools.StringDefault(a.properties.Manifest, "apex_manifest.json"))
+	manifest = a.addFieldsToManifest(ctx, manifest)
+	if proptools.Bool(a.properties.Test_only_corrupt_manifest) && !slim {
+		corruptManifest := android.PathForModuleOut(ctx, "corrupt", "apex_manifest.json")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        corruptApexManifestRule,
+			Input:       manifest,
+			Output:      corruptManifest,
+			Description: "corrupt apex manifest",
+		})
+		manifest = corruptManifest
+	}
 
 	var abis []string
 	for _, target := range ctx.MultiTargets() {
@@ -880,27 +1593,41 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 
 	abis = android.FirstUniqueStrings(abis)
 
-	suffix := apexType.suffix()
+	variant := ""
+	filesInfo := a.filesInfo
+	if slim {
+		variant = "-slim"
+		filesInfo = a.slimFilesInfo()
+	}
+	suffix := variant + apexType.suffix()
 	unsignedOutputFile := android.PathForModuleOut(ctx, ctx.ModuleName()+suffix+".unsigned")
 
 	filesToCopy := []android.Path{}
-	for _, f := range a.filesInfo {
+	for _, f := range filesInfo {
 		filesToCopy = append(filesToCopy, f.builtFile)
 	}
 
 	copyCommands := []string{}
 	for i, src := range filesToCopy {
-		dest := filepath.Join(a.filesInfo[i].installDir, src.Base())
+		dest := filepath.Join(filesInfo[i].installDir, src.Base())
 		dest_path := filepath.Join(android.PathForModuleOut(ctx, "image"+suffix).String(), dest)
 		copyCommands = append(copyCommands, "mkdir -p "+filepath.Dir(dest_path))
 		copyCommands = append(copyCommands, "cp "+src.String()+" "+dest_path)
-		for _, sym := range a.filesInfo[i].symlinks {
+		for _, sym := range filesInfo[i].symlinks {
 			symlinkDest := filepath.Join(filepath.Dir(dest_path), sym)
 			copyCommands = append(copyCommands, "ln -s "+filepath.Base(dest)+" "+symlinkDest)
 		}
 	}
+	for _, s := range a.symlinks {
+		symlinkDest := filepath.Join(android.PathForModuleOut(ctx, "image"+suffix).String(), s.path)
+		copyCommands = append(copyCommands, "mkdir -p "+proptools.NinjaAndShellEscape(filepath.Dir(symlinkDest)))
+		copyCommands = append(copyCommands, "ln -sf "+proptools.NinjaAndShellEscape(s.target)+" "+
+			proptools.NinjaAndShellEscape(symlinkDest))
+	}
 	implicitInputs := append(android.Paths(nil), filesToCopy...)
 	implicitInputs = append(implicitInputs, manifest)
+	// The APEX is only built once its arch invariant files are checked.
+	implicitInputs = append(implicitInputs, a.archInvariantChecks...)
 
 	outHostBinDir := android.PathForOutput(ctx, "host", ctx.Config().PrebuiltOS(), "bin").String()
 	prebuiltSdkToolsBinDir := filepath.Join("prebuilts", "sdk", "tools", runtime.GOOS, "bin")
@@ -909,7 +1636,17 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 		// files and dirs that will be created in APEX
 		var readOnlyPaths []string
 		var executablePaths []string // this also includes dirs
-		for _, f := range a.filesInfo {
+		addDirs := func(dir string) {
+			for !android.InList(dir, executablePaths) && dir != "" {
+				executablePaths = append(executablePaths, dir)
+				dir, _ = filepath.Split(dir) // move up to the parent
+				if len(dir) > 0 {
+					// remove trailing slash
+					dir = dir[:len(dir)-1]
+				}
+			}
+		}
+		for _, f := range filesInfo {
 			pathInApex := filepath.Join(f.installDir, f.builtFile.Base())
 			if f.installDir == "bin" {
 				executablePaths = append(executablePaths, pathInApex)
@@ -919,19 +1656,21 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 			} else {
 				readOnlyPaths = append(readOnlyPaths, pathInApex)
 			}
-			dir := f.installDir
-			for !android.InList(dir, executablePaths) && dir != "" {
-				executablePaths = append(executablePaths, dir)
-				dir, _ = filepath.Split(dir) // move up to the parent
-				if len(dir) > 0 {
-					// remove trailing slash
-					dir = dir[:len(dir)-1]
-				}
+			addDirs(f.installDir)
+		}
+		for _, s := range a.symlinks {
+			dir, _ := filepath.Split(s.path)
+			dir = strings.TrimSuffix(dir, "/")
+			if dir == "bin" {
+				executablePaths = append(executablePaths, s.path)
+			} else {
+				readOnlyPaths = append(readOnlyPaths, s.path)
 			}
+			addDirs(dir)
 		}
 		sort.Strings(readOnlyPaths)
 		sort.Strings(executablePaths)
-		cannedFsConfig := android.PathForModuleOut(ctx, "canned_fs_config")
+		cannedFsConfig := android.PathForModuleOut(ctx, "canned_fs_config"+variant)
 		ctx.Build(pctx, android.BuildParams{
 			Rule:        generateFsConfig,
 			Output:      cannedFsConfig,
@@ -942,7 +1681,7 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 			},
 		})
 
-		fcName := proptools.StringDefault(a.properties.File_contexts, ctx.ModuleName())
+		fcName := a.fileContextsName(ctx)
 		fileContextsPath := "system/sepolicy/apex/" + fcName + "-file_contexts"
 		fileContextsOptionalPath := android.ExistentPathForSource(ctx, fileContextsPath)
 		if !fileContextsOptionalPath.Valid() {
@@ -979,7 +1718,16 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 		}
 		optFlags = append(optFlags, "--target_sdk_version "+targetSdkVersion)
 
-		noticeFile := a.buildNoticeFile(ctx, ctx.ModuleName()+suffix)
+		// The NOTICE is built once and embedded in the slim APEX as well. With slim set, it
+		// only covers the files of the slim APEX.
+		if !slim {
+			noticeFilesInfo := filesInfo
+			if proptools.Bool(a.properties.Slim) {
+				noticeFilesInfo = a.slimFilesInfo()
+			}
+			a.noticeOutput = a.buildNoticeFile(ctx, ctx.ModuleName()+suffix, noticeFilesInfo)
+		}
+		noticeFile := a.noticeOutput
 		if noticeFile.Valid() {
 			// If there's a NOTICE file, embed it as an asset file in the APEX.
 			implicitInputs = append(implicitInputs, noticeFile.Path())
@@ -1003,26 +1751,30 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 			},
 		})
 
-		apexProtoFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".pb"+suffix)
-		bundleModuleFile := android.PathForModuleOut(ctx, ctx.ModuleName()+suffix+"-base.zip")
-		a.bundleModuleFile = bundleModuleFile
-
-		ctx.Build(pctx, android.BuildParams{
-			Rule:        apexProtoConvertRule,
-			Input:       unsignedOutputFile,
-			Output:      apexProtoFile,
-			Description: "apex proto convert",
-		})
-
-		ctx.Build(pctx, android.BuildParams{
-			Rule:        apexBundleRule,
-			Input:       apexProtoFile,
-			Output:      a.bundleModuleFile,
-			Description: "apex bundle module",
-			Args: map[string]string{
-				"abi": strings.Join(abis, "."),
-			},
-		})
+		if !slim {
+			a.buildPayloadImage(ctx, unsignedOutputFile)
+
+			apexProtoFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".pb"+suffix)
+			bundleModuleFile := android.PathForModuleOut(ctx, ctx.ModuleName()+suffix+"-base.zip")
+			a.bundleModuleFile = bundleModuleFile
+
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        apexProtoConvertRule,
+				Input:       unsignedOutputFile,
+				Output:      apexProtoFile,
+				Description: "apex proto convert",
+			})
+
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        apexBundleRule,
+				Input:       apexProtoFile,
+				Output:      a.bundleModuleFile,
+				Description: "apex bundle module",
+				Args: map[string]string{
+					"abi": strings.Join(abis, "."),
+				},
+			})
+		}
 	} else {
 		ctx.Build(pctx, android.BuildParams{
 			Rule:        zipApexRule,
@@ -1038,11 +1790,11 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 		})
 	}
 
-	a.outputFiles[apexType] = android.PathForModuleOut(ctx, ctx.ModuleName()+suffix)
+	signedOutputFile := android.PathForModuleOut(ctx, ctx.ModuleName()+suffix)
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        java.Signapk,
 		Description: "signapk",
-		Output:      a.outputFiles[apexType],
+		Output:      signedOutputFile,
 		Input:       unsignedOutputFile,
 		Args: map[string]string{
 			"certificates": a.container_certificate_file.String() + " " + a.container_private_key_file.String(),
@@ -1050,17 +1802,102 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext, apexType ap
 		},
 	})
 
+	if slim {
+		a.slimOutputFile = signedOutputFile
+		ctx.CheckbuildFile(a.slimOutputFile)
+		return
+	}
+	a.outputFiles[apexType] = signedOutputFile
+
 	// Install to $OUT/soong/{target,host}/.../apex
 	if a.installable() && (!ctx.Config().FlattenApex() || apexType.zip()) {
 		ctx.InstallFile(a.installDir, ctx.ModuleName()+suffix, a.outputFiles[apexType])
 	}
 }
 
+// buildPayloadImage extracts apex_payload.img from the image APEX. It is only built on demand,
+// with "m <name>-payload_img".
+func (a *apexBundle) buildPayloadImage(ctx android.ModuleContext, apexFile android.Path) {
+	a.payloadImage = android.PathForModuleOut(ctx, "apex_payload.img")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        apexPayloadImageRule,
+		Input:       apexFile,
+		Output:      a.payloadImage,
+		Description: "apex payload image",
+	})
+
+	// The phony builds the payload images of all the variants, so only the last variant defines it.
+	if ctx.FinalModule() != ctx.Module() {
+		return
+	}
+	var payloadImages android.Paths
+	ctx.VisitAllModuleVariants(func(module android.Module) {
+		if image := module.(*apexBundle).PayloadImage(); image != nil {
+			payloadImages = append(payloadImages, image)
+		}
+	})
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   blueprint.Phony,
+		Output: android.PathForPhony(ctx, ctx.ModuleName()+"-payload_img"),
+		Inputs: payloadImages,
+	})
+}
+
+// PayloadImage returns the uncompressed payload image of the APEX, or nil if the APEX is not built
+// as an image.
+func (a *apexBundle) PayloadImage() android.Path {
+	if a.payloadImage == nil {
+		return nil
+	}
+	return a.payloadImage
+}
+
+// Returns the manifest with the path of the post_install_hook binary and the provided_sysprops
+// recorded in it, or the manifest itself if the APEX has neither. The rule is created only once,
+// as both the unflattened and the flattened APEX use it.
+func (a *apexBundle) addFieldsToManifest(ctx android.ModuleContext, manifest android.Path) android.Path {
+	jsonString := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	var fields []string
+	if a.postInstallHook != "" {
+		fields = append(fields, "--set", "postInstallHook", jsonString(a.postInstallHook))
+	}
+	if len(a.syspropNames) > 0 {
+		var sysprops []string
+		for i := range a.syspropNames {
+			sysprops = append(sysprops, jsonString(a.syspropNames[i])+": "+jsonString(a.syspropValues[i]))
+		}
+		fields = append(fields, "--set", "providedSysprops", "{"+strings.Join(sysprops, ", ")+"}")
+	}
+	if len(fields) == 0 {
+		return manifest
+	}
+	if a.manifestWithFields != nil {
+		return a.manifestWithFields
+	}
+
+	manifestWithFields := android.PathForModuleOut(ctx, "manifest_with_fields", "apex_manifest.json")
+	a.manifestWithFields = manifestWithFields
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        apexManifestFieldsRule,
+		Input:       manifest,
+		Output:      manifestWithFields,
+		Description: "add fields to apex manifest",
+		Args: map[string]string{
+			"fields": strings.Join(proptools.NinjaAndShellEscapeList(fields), " "),
+		},
+	})
+	return manifestWithFields
+}
+
 func (a *apexBundle) buildFlattenedApex(ctx android.ModuleContext) {
 	if a.installable() {
 		// For flattened APEX, do nothing but make sure that apex_manifest.json and apex_pubkey are also copied along
 		// with other ordinary files.
-		manifest := android.PathForModuleSrc(ctx, proptools.StringDefault(a.properties.Manifest, "apex_manifest.json"))
+		var manifest android.Path = android.PathForModuleSrc(ctx, proptools.StringDefault(a.properties.Manifest, "apex_manifest.json"))
+		manifest = a.addFieldsToManifest(ctx, manifest)
 
 		// rename to apex_manifest.json
 		copiedManifest := android.PathForModuleOut(ctx, "apex_manifest.json")
@@ -1088,6 +1925,10 @@ func (a *apexBundle) buildFlattenedApex(ctx android.ModuleContext) {
 					ctx.InstallSymlink(android.PathForModuleInstall(ctx, dir), sym, target)
 				}
 			}
+			for _, s := range a.symlinks {
+				dir, name := filepath.Split(s.path)
+				ctx.InstallAbsoluteSymlink(android.PathForModuleInstall(ctx, "apex", ctx.ModuleName(), dir), name, s.target)
+			}
 		}
 	}
 }
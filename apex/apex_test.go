@@ -15,8 +15,13 @@
 package apex
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -29,10 +34,39 @@ import (
 
 var buildDir string
 
+func testApexError(t *testing.T, pattern, bp string) {
+	ctx, config := testApexContext(t, bp)
+	defer teardown(buildDir)
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	if len(errs) > 0 {
+		android.FailIfNoMatchingErrors(t, pattern, errs)
+		return
+	}
+	_, errs = ctx.PrepareBuildActions(config)
+	if len(errs) > 0 {
+		android.FailIfNoMatchingErrors(t, pattern, errs)
+		return
+	}
+
+	t.Fatalf("missing expected error %q (0 errors are returned)", pattern)
+}
+
 func testApex(t *testing.T, bp string) *android.TestContext {
+	ctx, config := testApexContext(t, bp)
+	defer teardown(buildDir)
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	return ctx
+}
+
+func testApexContext(t *testing.T, bp string) (*android.TestContext, android.Config) {
 	var config android.Config
 	config, buildDir = setup(t)
-	defer teardown(buildDir)
 
 	ctx := android.NewTestArchContext()
 	ctx.RegisterModuleType("apex", android.ModuleFactoryAdaptor(apexBundleFactory))
@@ -54,6 +88,7 @@ func testApex(t *testing.T, bp string) *android.TestContext {
 	ctx.RegisterModuleType("cc_library_headers", android.ModuleFactoryAdaptor(cc.LibraryHeaderFactory))
 	ctx.RegisterModuleType("cc_binary", android.ModuleFactoryAdaptor(cc.BinaryFactory))
 	ctx.RegisterModuleType("cc_object", android.ModuleFactoryAdaptor(cc.ObjectFactory))
+	ctx.RegisterModuleType("cc_test", android.ModuleFactoryAdaptor(cc.TestFactory))
 	ctx.RegisterModuleType("llndk_library", android.ModuleFactoryAdaptor(cc.LlndkLibraryFactory))
 	ctx.RegisterModuleType("toolchain_library", android.ModuleFactoryAdaptor(cc.ToolchainLibraryFactory))
 	ctx.RegisterModuleType("prebuilt_etc", android.ModuleFactoryAdaptor(android.PrebuiltEtcFactory))
@@ -164,8 +199,10 @@ func testApex(t *testing.T, bp string) *android.TestContext {
 		"system/sepolicy/apex/myapex-file_contexts":         nil,
 		"system/sepolicy/apex/myapex_keytest-file_contexts": nil,
 		"system/sepolicy/apex/otherapex-file_contexts":      nil,
+		"system/sepolicy/apex/myapex.vendor-file_contexts":  nil,
 		"mylib.cpp":                            nil,
 		"myprebuilt":                           nil,
+		"myprebuilt_arm":                       nil,
 		"my_include":                           nil,
 		"vendor/foo/devkeys/test.x509.pem":     nil,
 		"vendor/foo/devkeys/test.pk8":          nil,
@@ -182,13 +219,10 @@ func testApex(t *testing.T, bp string) *android.TestContext {
 		"myapex-arm64.apex":                    nil,
 		"myapex-arm.apex":                      nil,
 		"frameworks/base/api/current.txt":      nil,
+		"myapex_linker.config.pb":              nil,
 	})
-	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
-	android.FailIfErrored(t, errs)
-	_, errs = ctx.PrepareBuildActions(config)
-	android.FailIfErrored(t, errs)
 
-	return ctx
+	return ctx, config
 }
 
 func setup(t *testing.T) (config android.Config, buildDir string) {
@@ -929,6 +963,110 @@ func TestMacro(t *testing.T) {
 	ensureContains(t, mylibCFlags, "-D__ANDROID_APEX__=otherapex")
 }
 
+func TestApexDefines(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			defines: ["__ANDROID_APEX_NAME__=\"com.android.foo\""],
+		}
+
+		apex {
+			name: "otherapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	define := `'-D__ANDROID_APEX_NAME__="com.android.foo"'`
+
+	// the define only applies to the variant built for myapex
+	mylibCFlags := ctx.ModuleForTests("mylib", "android_arm64_armv8-a_core_shared_myapex").Rule("cc").Args["cFlags"]
+	ensureContains(t, mylibCFlags, define)
+
+	mylibCFlags = ctx.ModuleForTests("mylib", "android_arm64_armv8-a_core_shared_otherapex").Rule("cc").Args["cFlags"]
+	ensureNotContains(t, mylibCFlags, define)
+
+	mylibCFlags = ctx.ModuleForTests("mylib", "android_arm64_armv8-a_core_shared").Rule("cc").Args["cFlags"]
+	ensureNotContains(t, mylibCFlags, define)
+}
+
+func TestInApexes(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+		}
+
+		apex {
+			name: "otherapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib", "mylib2"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "mylib2",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "mylib3",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	inApexes := func(name, variant string) []string {
+		return ctx.ModuleForTests(name, variant).Module().(*cc.Module).InApexes()
+	}
+
+	// every variant of a module reports all the apexes it is packaged into
+	for _, variant := range []string{"android_arm64_armv8-a_core_shared", "android_arm64_armv8-a_core_shared_myapex", "android_arm64_armv8-a_core_shared_otherapex"} {
+		if got, expected := inApexes("mylib", variant), []string{"myapex", "otherapex"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected mylib %s to be in %q, got %q", variant, expected, got)
+		}
+	}
+
+	if got, expected := inApexes("mylib2", "android_arm64_armv8-a_core_shared_otherapex"), []string{"otherapex"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected mylib2 to be in %q, got %q", expected, got)
+	}
+
+	if got := inApexes("mylib3", "android_arm64_armv8-a_core_shared"); len(got) != 0 {
+		t.Errorf("expected mylib3 not to be in any apex, got %q", got)
+	}
+}
+
 func TestHeaderLibsDependency(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
@@ -1184,107 +1322,1051 @@ func TestApexWithShBinary(t *testing.T) {
 	ensureContains(t, copyCmds, "image.apex/bin/script/myscript.sh")
 }
 
-func TestApexInProductPartition(t *testing.T) {
+func TestApexPostInstallHook(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			native_shared_libs: ["mylib"],
-			product_specific: true,
+			post_install_hook: "myhook",
 		}
 
 		apex_key {
 			name: "myapex.key",
 			public_key: "testkey.avbpubkey",
 			private_key: "testkey.pem",
-			product_specific: true,
 		}
 
-		cc_library {
-			name: "mylib",
-			srcs: ["mylib.cpp"],
-			system_shared_libs: [],
-			stl: "none",
+		sh_binary {
+			name: "myhook",
+			src: "mylib.cpp",
+			filename: "myhook.sh",
 		}
 	`)
 
-	apex := ctx.ModuleForTests("myapex", "android_common_myapex").Module().(*apexBundle)
-	expected := "target/product/test_device/product/apex"
-	actual := apex.installDir.RelPathString()
-	if actual != expected {
-		t.Errorf("wrong install path. expected %q. actual %q", expected, actual)
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	apexRule := module.Rule("apexRule")
+	ensureContains(t, apexRule.Args["copy_commands"], "image.apex/bin/myhook.sh")
+
+	// The APEX is built with the manifest that records the hook.
+	hookManifest := module.Rule("apexManifestFieldsRule")
+	if g, w := hookManifest.Args["fields"], `--set postInstallHook '"bin/myhook.sh"'`; g != w {
+		t.Errorf("expected fields %q, got %q", w, g)
+	}
+	if g, w := apexRule.Args["manifest"], hookManifest.Output.String(); g != w {
+		t.Errorf("expected manifest %q, got %q", w, g)
 	}
-}
 
-func TestApexKeyFromOtherModule(t *testing.T) {
-	ctx := testApex(t, `
+	testApexError(t, `"myhook" is neither cc_binary nor sh_binary`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			post_install_hook: "myhook",
+		}
+
 		apex_key {
 			name: "myapex.key",
-			public_key: ":my.avbpubkey",
-			private_key: ":my.pem",
-			product_specific: true,
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
 		}
 
-		filegroup {
-			name: "my.avbpubkey",
-			srcs: ["testkey2.avbpubkey"],
+		prebuilt_etc {
+			name: "myhook",
+			src: "myprebuilt",
 		}
+	`)
+}
 
-		filegroup {
-			name: "my.pem",
-			srcs: ["testkey2.pem"],
+func TestApexProvidedSysprops(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			provided_sysprops: [
+				"ro.myapex.enabled=true",
+				"ro.myapex.dir=/apex/myapex",
+			],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
 		}
 	`)
 
-	apex_key := ctx.ModuleForTests("myapex.key", "android_common").Module().(*apexKey)
-	expected_pubkey := "testkey2.avbpubkey"
-	actual_pubkey := apex_key.public_key_file.String()
-	if actual_pubkey != expected_pubkey {
-		t.Errorf("wrong public key path. expected %q. actual %q", expected_pubkey, actual_pubkey)
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	apexRule := module.Rule("apexRule")
+	ensureContains(t, apexRule.Args["copy_commands"], "image.apex/etc/build.prop")
+
+	buildProp := module.Output("build.prop")
+	if g, w := buildProp.Args["content"], `ro.myapex.enabled=true\nro.myapex.dir=/apex/myapex`; g != w {
+		t.Errorf("expected build.prop %q, got %q", w, g)
 	}
-	expected_privkey := "testkey2.pem"
-	actual_privkey := apex_key.private_key_file.String()
-	if actual_privkey != expected_privkey {
-		t.Errorf("wrong private key path. expected %q. actual %q", expected_privkey, actual_privkey)
+
+	manifest := module.Rule("apexManifestFieldsRule")
+	if g, w := manifest.Args["fields"], `--set providedSysprops '{"ro.myapex.enabled": "true", "ro.myapex.dir": "/apex/myapex"}'`; g != w {
+		t.Errorf("expected fields %q, got %q", w, g)
+	}
+	if g, w := apexRule.Args["manifest"], manifest.Output.String(); g != w {
+		t.Errorf("expected manifest %q, got %q", w, g)
 	}
+
+	testApexError(t, `"ro.myapex.enabled" must be of the form name=value`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			provided_sysprops: ["ro.myapex.enabled"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`)
 }
 
-func TestPrebuilt(t *testing.T) {
+func TestApexWithSymlinks(t *testing.T) {
 	ctx := testApex(t, `
-		prebuilt_apex {
+		apex {
 			name: "myapex",
-			arch: {
-				arm64: {
-					src: "myapex-arm64.apex",
-				},
-				arm: {
-					src: "myapex-arm.apex",
-				},
-			},
+			key: "myapex.key",
+			binaries: ["myscript"],
+			symlinks: [
+				"/bin/sh:myscript.sh",
+				"etc/foo/bar.conf:/system/etc/bar.conf",
+				"etc/baz.conf:/system/etc/$baz conf",
+			],
 		}
-	`)
 
-	prebuilt := ctx.ModuleForTests("myapex", "android_common").Module().(*Prebuilt)
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
 
-	expectedInput := "myapex-arm64.apex"
-	if prebuilt.inputApex.String() != expectedInput {
-		t.Errorf("inputApex invalid. expected: %q, actual: %q", expectedInput, prebuilt.inputApex.String())
-	}
+		sh_binary {
+			name: "myscript",
+			src: "mylib.cpp",
+			filename: "myscript.sh",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	copyCmds := module.Rule("apexRule").Args["copy_commands"]
+
+	ensureContains(t, copyCmds, "ln -sf myscript.sh ")
+	ensureContains(t, copyCmds, "image.apex/bin/sh")
+	ensureContains(t, copyCmds, "ln -sf /system/etc/bar.conf ")
+	ensureContains(t, copyCmds, "image.apex/etc/foo/bar.conf")
+	ensureContains(t, copyCmds, "ln -sf '/system/etc/$$baz conf' ")
+	ensureContains(t, copyCmds, "image.apex/etc/baz.conf")
+
+	generateFsRule := module.Rule("generateFsConfig")
+	ensureListContains(t, strings.Split(generateFsRule.Args["exec_paths"], " "), "bin/sh")
+	ensureListContains(t, strings.Split(generateFsRule.Args["exec_paths"], " "), "etc/foo")
+	ensureListContains(t, strings.Split(generateFsRule.Args["ro_paths"], " "), "etc/foo/bar.conf")
 }
 
-func TestPrebuiltFilenameOverride(t *testing.T) {
+func TestApexDependencySbom(t *testing.T) {
 	ctx := testApex(t, `
-		prebuilt_apex {
+		apex {
 			name: "myapex",
-			src: "myapex-arm.apex",
-			filename: "notmyapex.apex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			dependency_sbom: true,
+			dependency_sbom_build_ids: true,
+			symlinks: ["lib64/mylib_link.so:mylib.so"],
+		}
+
+		apex {
+			name: "myapex.nobuildids",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			file_contexts: "myapex",
+			dependency_sbom: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
 		}
 	`)
 
-	p := ctx.ModuleForTests("myapex", "android_common").Module().(*Prebuilt)
+	sbomRule := ctx.ModuleForTests("myapex", "android_common_myapex").Rule("apexDependencySbomRule")
+	ensureContains(t, sbomRule.Output.String(), "myapex-deps-sbom.json")
+	ensureContains(t, sbomRule.Args["opt_flags"], "--build_ids")
+	ensureContains(t, sbomRule.Args["entries"], "lib64/mylib.so:mylib:")
+	ensureContains(t, sbomRule.Args["entries"], "lib/mylib.so:mylib:")
+	ensureContains(t, sbomRule.Args["entries"], "lib64/mylib_link.so:myapex:")
 
-	expected := "notmyapex.apex"
-	if p.installFilename != expected {
-		t.Errorf("installFilename invalid. expected: %q, actual: %q", expected, p.installFilename)
+	sbomRule = ctx.ModuleForTests("myapex.nobuildids", "android_common_myapex.nobuildids").Rule("apexDependencySbomRule")
+	ensureNotContains(t, sbomRule.Args["opt_flags"], "--build_ids")
+}
+
+func TestApexContentsJson(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			binaries: ["mybin"],
+			symlinks: ["bin/mybin_alias:mybin"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_binary {
+			name: "mybin",
+			srcs: ["mylib.cpp"],
+			symlinks: ["mybin_link"],
+			system_shared_libs: [],
+			static_executable: true,
+			stl: "none",
+		}
+	`)
+
+	apexBundle := ctx.ModuleForTests("myapex", "android_common_myapex").Module().(*apexBundle)
+	rule := ctx.ModuleForTests("myapex", "android_common_myapex").Output("myapex-contents.json")
+
+	var entries []apexContentsEntry
+	if err := json.Unmarshal([]byte(rule.Args["content"]), &entries); err != nil {
+		t.Fatalf("invalid contents JSON %q: %s", rule.Args["content"], err)
+	}
+
+	var found bool
+	for _, entry := range entries {
+		if entry.InstallPath == "lib64/mylib.so" {
+			found = true
+			if entry.Class != "SHARED_LIBRARIES" || entry.Module != "mylib" {
+				t.Errorf("unexpected contents JSON entry %#v", entry)
+			}
+			ensureContains(t, entry.SourcePath, "mylib.so")
+		}
+	}
+	if !found {
+		t.Errorf("lib64/mylib.so is missing from the contents JSON %q", rule.Args["content"])
+	}
+
+	// Both the symlinks of modules and of the APEX itself are listed.
+	for _, want := range []apexContentsEntry{
+		{InstallPath: "bin/mybin_link", Class: "EXECUTABLES", Module: "mybin", SymlinkTarget: "mybin"},
+		{InstallPath: "bin/mybin_alias", Module: "myapex", SymlinkTarget: "mybin"},
+	} {
+		found = false
+		for _, entry := range entries {
+			if entry == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("symlink %#v is missing from the contents JSON %q", want, rule.Args["content"])
+		}
+	}
+	ensureContains(t, apexBundle.ContentsJson().String(), "myapex-contents.json")
+}
+
+func TestApexDependencySbomSpdx(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			dependency_sbom: true,
+			dependency_sbom_spdx: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	sbomRule := ctx.ModuleForTests("myapex", "android_common_myapex").Rule("apexDependencySbomRule")
+	ensureContains(t, sbomRule.Output.String(), "myapex-deps-sbom.spdx.json")
+	ensureContains(t, sbomRule.Args["opt_flags"], "--spdx --name myapex --version ")
+	ensureContains(t, sbomRule.Args["entries"], "lib64/mylib.so:mylib:")
+}
+
+func TestApexInProductPartition(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			product_specific: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+			product_specific: true,
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	apex := ctx.ModuleForTests("myapex", "android_common_myapex").Module().(*apexBundle)
+	expected := "target/product/test_device/product/apex"
+	actual := apex.installDir.RelPathString()
+	if actual != expected {
+		t.Errorf("wrong install path. expected %q. actual %q", expected, actual)
+	}
+}
+
+func TestApexKeyFromOtherModule(t *testing.T) {
+	ctx := testApex(t, `
+		apex_key {
+			name: "myapex.key",
+			public_key: ":my.avbpubkey",
+			private_key: ":my.pem",
+			product_specific: true,
+		}
+
+		filegroup {
+			name: "my.avbpubkey",
+			srcs: ["testkey2.avbpubkey"],
+		}
+
+		filegroup {
+			name: "my.pem",
+			srcs: ["testkey2.pem"],
+		}
+	`)
+
+	apex_key := ctx.ModuleForTests("myapex.key", "android_common").Module().(*apexKey)
+	expected_pubkey := "testkey2.avbpubkey"
+	actual_pubkey := apex_key.public_key_file.String()
+	if actual_pubkey != expected_pubkey {
+		t.Errorf("wrong public key path. expected %q. actual %q", expected_pubkey, actual_pubkey)
+	}
+	expected_privkey := "testkey2.pem"
+	actual_privkey := apex_key.private_key_file.String()
+	if actual_privkey != expected_privkey {
+		t.Errorf("wrong private key path. expected %q. actual %q", expected_privkey, actual_privkey)
+	}
+}
+
+func TestPrebuilt(t *testing.T) {
+	ctx := testApex(t, `
+		prebuilt_apex {
+			name: "myapex",
+			arch: {
+				arm64: {
+					src: "myapex-arm64.apex",
+				},
+				arm: {
+					src: "myapex-arm.apex",
+				},
+			},
+		}
+	`)
+
+	prebuilt := ctx.ModuleForTests("myapex", "android_common").Module().(*Prebuilt)
+
+	expectedInput := "myapex-arm64.apex"
+	if prebuilt.inputApex.String() != expectedInput {
+		t.Errorf("inputApex invalid. expected: %q, actual: %q", expectedInput, prebuilt.inputApex.String())
+	}
+}
+
+func TestPrebuiltFilenameOverride(t *testing.T) {
+	ctx := testApex(t, `
+		prebuilt_apex {
+			name: "myapex",
+			src: "myapex-arm.apex",
+			filename: "notmyapex.apex",
+		}
+	`)
+
+	p := ctx.ModuleForTests("myapex", "android_common").Module().(*Prebuilt)
+
+	expected := "notmyapex.apex"
+	if p.installFilename != expected {
+		t.Errorf("installFilename invalid. expected: %q, actual: %q", expected, p.installFilename)
+	}
+}
+
+func TestApexVendorFileContexts(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			use_vendor: %t,
+			target: {
+				vendor: {
+					file_contexts: "myapex.vendor",
+				},
+			},
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			vendor_available: true,
+			stl: "none",
+		}
+	`
+
+	ctx := testApex(t, fmt.Sprintf(bp, true))
+	apexRule := ctx.ModuleForTests("myapex", "android_common_myapex").Rule("apexRule")
+	ensureContains(t, apexRule.Args["file_contexts"], "system/sepolicy/apex/myapex.vendor-file_contexts")
+
+	ctx = testApex(t, fmt.Sprintf(bp, false))
+	apexRule = ctx.ModuleForTests("myapex", "android_common_myapex").Rule("apexRule")
+	ensureContains(t, apexRule.Args["file_contexts"], "system/sepolicy/apex/myapex-file_contexts")
+}
+
+func TestApexPayloadImage(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	payloadImage := module.Output("apex_payload.img")
+	ensureContains(t, payloadImage.Input.String(), "myapex.apex.unsigned")
+
+	phony := module.Output("myapex-payload_img")
+	if len(phony.Inputs) != 1 || phony.Inputs[0] != payloadImage.Output {
+		t.Errorf("expected myapex-payload_img to build %q, got %q", payloadImage.Output, phony.Inputs)
+	}
+
+	apexBundle := module.Module().(*apexBundle)
+	if apexBundle.PayloadImage() != payloadImage.Output {
+		t.Errorf("expected payload image %q, got %q", payloadImage.Output, apexBundle.PayloadImage())
+	}
+}
+
+func TestTestApexCorruptManifest(t *testing.T) {
+	ctx := testApex(t, `
+		apex_test {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			test_only_corrupt_manifest: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	corruptManifest := module.Rule("corruptApexManifestRule")
+	ensureContains(t, corruptManifest.Input.String(), "apex_manifest.json")
+
+	// The APEX is built with the corrupt manifest instead of the original one.
+	apexRule := module.Rule("apexRule")
+	if g, w := apexRule.Args["manifest"], corruptManifest.Output.String(); g != w {
+		t.Errorf("expected manifest %q, got %q", w, g)
+	}
+
+	// The corrupt manifest is malformed even if the manifest has no version.
+	dir, err := ioutil.TempDir("", "corrupt_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, manifest := range []string{
+		`{"name": "com.android.myapex", "version": 1}` + "\n",
+		`{"name": "com.android.myapex"}` + "\n",
+		`{}`,
+	} {
+		in, out := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+		if err := ioutil.WriteFile(in, []byte(manifest), 0666); err != nil {
+			t.Fatal(err)
+		}
+		command := strings.NewReplacer("$$", "$", "${in}", in, "${out}", out).Replace(corruptManifest.RuleParams.Command)
+		if output, err := exec.Command("/bin/bash", "-c", command).CombinedOutput(); err != nil {
+			t.Fatalf("failed to corrupt %q: %s", manifest, output)
+		}
+		corrupt, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(corrupt, &v); err == nil {
+			t.Errorf("expected the corrupt manifest of %q to be malformed, got %q", manifest, corrupt)
+		}
+	}
+}
+
+func TestTestApexSlim(t *testing.T) {
+	ctx := testApex(t, `
+		apex_test {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			binaries: ["mytest"],
+			slim: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_test {
+			name: "mytest",
+			srcs: ["mylib.cpp"],
+			gtest: false,
+			system_shared_libs: [],
+			static_executable: true,
+			stl: "none",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	copyCmds := module.Output("myapex.apex.unsigned").Args["copy_commands"]
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib.so")
+	ensureContains(t, copyCmds, "image.apex/bin/mytest")
+
+	// The slim APEX leaves out the test binary.
+	slimCopyCmds := module.Output("myapex-slim.apex.unsigned").Args["copy_commands"]
+	ensureContains(t, slimCopyCmds, "image-slim.apex/lib64/mylib.so")
+	ensureNotContains(t, slimCopyCmds, "mytest")
+
+	apexBundle := module.Module().(*apexBundle)
+	ensureContains(t, apexBundle.SlimOutputFile().String(), "myapex-slim.apex")
+
+	testApexError(t, `slim: can only be set for apex_test modules`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			slim: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`)
+}
+
+func TestTestApexSlimNotice(t *testing.T) {
+	ctx := testApex(t, `
+		apex_test {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			binaries: ["mytest"],
+			slim: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			notice: "NOTICE",
+		}
+
+		cc_test {
+			name: "mytest",
+			srcs: ["mylib.cpp"],
+			gtest: false,
+			system_shared_libs: [],
+			static_executable: true,
+			stl: "none",
+			notice: "custom_notice",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+
+	// The NOTICE is only built once, and only covers the files of the slim APEX.
+	noticeInputs := module.Rule("mergeNoticesRule").Inputs.Strings()
+	ensureListContains(t, noticeInputs, "NOTICE")
+	ensureListNotContains(t, noticeInputs, "custom_notice")
+
+	assetsDir := "--assets_dir " + buildDir + "/.intermediates/myapex/android_common_myapex/NOTICE"
+	ensureContains(t, module.Output("myapex.apex.unsigned").Args["opt_flags"], assetsDir)
+	ensureContains(t, module.Output("myapex-slim.apex.unsigned").Args["opt_flags"], assetsDir)
+}
+
+func TestApexPartitionMismatch(t *testing.T) {
+	testApexError(t, `"mylib" is built for the product partition, but the APEX is installed to the system partition`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			product_specific: true,
+		}
+	`)
+}
+
+func TestApexStripPayload(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			strip_payload: "keep_symbols",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	strip := module.Output("stripped/mylib/arm64/lib64/mylib.so")
+	ensureContains(t, strip.Input.String(), "unstripped/mylib.so")
+	ensureContains(t, strip.Args["stripFlags"], "--strip-debug")
+
+	copyCmds := module.Rule("apexRule").Args["copy_commands"]
+	ensureContains(t, copyCmds, strip.Output.String())
+
+	// Libraries with the same path in the APEX are stripped to different files.
+	ctx = testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib", "mylib2"],
+			strip_payload: "all",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "mylib2",
+			srcs: ["mylib.cpp"],
+			stem: "mylib",
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	module = ctx.ModuleForTests("myapex", "android_common_myapex")
+	strip = module.Output("stripped/mylib/arm64/lib64/mylib.so")
+	ensureContains(t, strip.Input.String(), "mylib/android_arm64_armv8-a_core_shared_myapex/unstripped/mylib.so")
+	strip2 := module.Output("stripped/mylib2/arm64/lib64/mylib.so")
+	ensureContains(t, strip2.Input.String(), "mylib2/android_arm64_armv8-a_core_shared_myapex/unstripped/mylib.so")
+
+	testApexError(t, `strip_payload: "some" is not one of "all", "keep_symbols", or "none"`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			strip_payload: "some",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`)
+}
+
+func TestApexLinkerConfig(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			linker_config: "myapex_linker.config.pb",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	// The linker config is installed as is, under the name linkerconfig looks for.
+	linkerConfig := module.Output("linker.config.pb")
+	if linkerConfig.Rule != android.Cp {
+		t.Errorf("expected the linker config to be copied, got rule %q", linkerConfig.Rule)
+	}
+	ensureContains(t, linkerConfig.Input.String(), "myapex_linker.config.pb")
+
+	copyCmds := module.Rule("apexRule").Args["copy_commands"]
+	ensureContains(t, copyCmds, "image.apex/etc/linker.config.pb")
+
+	testApexError(t, `linker_config: "myetc" is also installed to etc/linker.config.pb`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			linker_config: "myapex_linker.config.pb",
+			prebuilts: ["myetc"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		prebuilt_etc {
+			name: "myetc",
+			src: "myprebuilt",
+			filename: "linker.config.pb",
+		}
+	`)
+}
+
+func TestApexArchInvariantFiles(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			prebuilts: ["myetc"],
+			arch_invariant_files: ["etc/myetc"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		prebuilt_etc {
+			name: "myetc",
+			src: "myprebuilt",
+			filename: "myetc",
+			arch: {
+				arm: {
+					src: "myprebuilt_arm",
+				},
+			},
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex")
+	check := module.Output("arch_invariant/etc/myetc.check")
+	ensureContains(t, check.Input.String(), "android_arm64_armv8-a_core/myetc")
+	ensureContains(t, check.Args["others"], "android_arm_armv7-a-neon_core/myetc")
+
+	apexRule := module.Rule("apexRule")
+	ensureListContains(t, apexRule.Implicits.Strings(), check.Output.String())
+
+	testApexError(t, `arch_invariant_files: "etc/nonexistent" is not in the APEX`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			prebuilts: ["myetc"],
+			arch_invariant_files: ["etc/nonexistent"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		prebuilt_etc {
+			name: "myetc",
+			src: "myprebuilt",
+		}
+	`)
+}
+
+func TestApexNoStubsInPayload(t *testing.T) {
+	testApexError(t, `"libllndk.llndk" is a stubs variant, which cannot be installed in the APEX`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["libllndk.llndk"],
+			use_vendor: true,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		llndk_library {
+			name: "libllndk",
+			symbol_file: "",
+		}
+	`)
+}
+
+func TestApexTestFixtureDir(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			host_supported: true,
+			target: {
+				host: {
+					test_fixture_dir: "apex_fixtures/myapex",
+				},
+			},
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`)
+
+	hostOutputs := strings.Join(ctx.ModuleForTests("myapex", "linux_glibc_common_myapex").AllOutputs(), " ")
+	ensureContains(t, hostOutputs, "/host/linux-x86/apex_fixtures/myapex/myapex.apex")
+	ensureContains(t, hostOutputs, "/host/linux-x86/apex_fixtures/myapex/myapex-installed-files.txt")
+
+	deviceOutputs := strings.Join(ctx.ModuleForTests("myapex", "android_common_myapex").AllOutputs(), " ")
+	ensureNotContains(t, deviceOutputs, "apex_fixtures")
+
+	// Only one variant defines the phony building the payload images of both.
+	var payloadImgPhonies []android.TestingBuildParams
+	for _, variant := range []string{"android_common_myapex", "linux_glibc_common_myapex"} {
+		if phony := ctx.ModuleForTests("myapex", variant).MaybeOutput("myapex-payload_img"); phony.Rule != nil {
+			payloadImgPhonies = append(payloadImgPhonies, phony)
+		}
+	}
+	if len(payloadImgPhonies) != 1 {
+		t.Fatalf("expected myapex-payload_img to be defined once, got %d", len(payloadImgPhonies))
+	}
+	if g, w := len(payloadImgPhonies[0].Inputs), 2; g != w {
+		t.Errorf("expected myapex-payload_img to build %d payload images, got %q", w, payloadImgPhonies[0].Inputs)
+	}
+
+	testApexError(t, `test_fixture_dir: "../fixtures" must be a relative path inside the host output directory`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			host_supported: true,
+			target: {
+				host: {
+					test_fixture_dir: "../fixtures",
+				},
+			},
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`)
+}
+
+func TestApexBootstrapLibs(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib", "libmyruntime"],
+			bootstrap_libs: ["libmyruntime"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libmyruntime",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	copyCmds := ctx.ModuleForTests("myapex", "android_common_myapex").Rule("apexRule").Args["copy_commands"]
+	ensureContains(t, copyCmds, "image.apex/lib64/bionic/libmyruntime.so")
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib.so")
+	ensureNotContains(t, copyCmds, "image.apex/lib64/bionic/mylib.so")
+}
+
+func TestApexDuplicatesReport(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			prebuilts: ["myetc", "myetc2"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		prebuilt_etc {
+			name: "myetc",
+			src: "myprebuilt",
+			filename: "foo.conf",
+		}
+
+		prebuilt_etc {
+			name: "myetc2",
+			src: "myprebuilt",
+			filename: "foo.conf",
+		}
+	`)
+
+	report := ctx.ModuleForTests("myapex", "android_common_myapex").Output("myapex-duplicates.txt")
+	ensureContains(t, report.Args["content"],
+		"etc/foo.conf: myetc (android_arm64_armv8-a_core), myetc2 (android_arm64_armv8-a_core)")
+}
+
+func TestCcTestDataApexes(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_test {
+			name: "mytest",
+			srcs: ["mylib.cpp"],
+			gtest: false,
+			static_executable: true,
+			system_shared_libs: [],
+			stl: "none",
+			data_apexes: ["myapex"],
+		}
+	`)
+
+	apexFile := ctx.ModuleForTests("myapex", "android_common_myapex").Module().(android.SourceFileProducer).Srcs()
+	if len(apexFile) != 1 {
+		t.Fatalf("expected myapex to produce one .apex file, got %q", apexFile)
+	}
+
+	for _, variant := range []string{"android_arm64_armv8-a_core", "android_arm_armv7-a-neon_core"} {
+		data := ctx.ModuleForTests("mytest", variant).Module().(*cc.Module).DataPaths()
+		ensureListContains(t, data.Strings(), apexFile[0].String())
 	}
 }
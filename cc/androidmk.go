@@ -176,6 +176,9 @@ func (library *libraryDecorator) AndroidMk(ctx AndroidMkContext, ret *android.An
 				fmt.Fprintln(w, "LOCAL_ADDITIONAL_DEPENDENCIES += ", library.sAbiDiff.String())
 				fmt.Fprintln(w, "HEADER_ABI_DIFFS += ", library.sAbiDiff.String())
 			}
+			if library.sAbiMangledNamesCheck.Valid() && !library.static() {
+				fmt.Fprintln(w, "LOCAL_ADDITIONAL_DEPENDENCIES += ", library.sAbiMangledNamesCheck.String())
+			}
 		}
 
 		_, _, ext := splitFileExt(outputFile.Base())
@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 )
@@ -42,6 +43,10 @@ type BinaryLinkerProperties struct {
 	// extension (if any) appended
 	Symlinks []string `android:"arch_variant"`
 
+	// extra run-time search paths for shared libraries, e.g. "$ORIGIN/../lib". They are added
+	// in order after the default ones. Absolute paths are not allowed for device binaries.
+	Rpaths []string `android:"arch_variant"`
+
 	DynamicLinker string `blueprint:"mutated"`
 
 	// Names of modules to be overridden. Listed modules can only be other binaries
@@ -295,6 +300,16 @@ func (binary *binaryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Flags
 		}
 	}
 
+	if !binary.static() {
+		for _, rpath := range binary.Properties.Rpaths {
+			if ctx.Device() && filepath.IsAbs(rpath) {
+				ctx.PropertyErrorf("rpaths", "%q must not be an absolute path for device binaries", rpath)
+				continue
+			}
+			flags.LdFlags = append(flags.LdFlags, "-Wl,-rpath,"+proptools.NinjaAndShellEscape(rpath))
+		}
+	}
+
 	return flags
 }
 
@@ -380,11 +395,14 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 	}
 
 	linkerDeps = append(linkerDeps, objs.tidyFiles...)
+	linkerDeps = append(linkerDeps, objs.checkFiles...)
 	linkerDeps = append(linkerDeps, flags.LdFlagsDeps...)
 
+	linkMapOutputs := binary.linkMapOutputs(ctx, fileName, &builderFlags)
+
 	TransformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs, deps.StaticLibs,
 		deps.LateStaticLibs, deps.WholeStaticLibs, linkerDeps, deps.CrtBegin, deps.CrtEnd, true,
-		builderFlags, outputFile)
+		builderFlags, outputFile, linkMapOutputs)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
@@ -26,6 +26,7 @@ import (
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 	"android/soong/cc/config"
@@ -161,6 +162,113 @@ var (
 		},
 		"crossCompile", "format")
 
+	weakUndefinedSymbols = pctx.AndroidStaticRule("weakUndefinedSymbols",
+		blueprint.RuleParams{
+			Command: "rm -f $out && ${crossCompile}readelf --dyn-syms -W $in | " +
+				`awk '$$5 == "WEAK" && $$7 == "UND" {print $$8}' | sort -u > $out`,
+		},
+		"crossCompile")
+
+	// Fails if the version script names global symbols that the table of contents of the shared
+	// library doesn't export. Comments, e.g. the "# introduced=26" annotations of the map.txt
+	// files, are ignored.
+	versionScriptCheck = pctx.AndroidStaticRule("versionScriptCheck",
+		blueprint.RuleParams{
+			Command: "rm -f $out && " +
+				`awk '$$1 ~ /^[0-9]+:$$/ && $$3 != "LOCAL" && $$5 != "UND" && $$6 != "" ` +
+				`{sub(/@.*/, "", $$6); print $$6}' $in | LC_ALL=C sort -u > $exported && ` +
+				`awk '{sub(/#.*/, "")} /\{/ {global = 1} /global:/ {global = 1; next} /local:/ {global = 0; next} ` +
+				`global && /^[ \t]*[A-Za-z_][A-Za-z0-9_]*[ \t]*;/ {gsub(/[ \t;]/, ""); print}' $versionScript | ` +
+				`LC_ALL=C sort -u | LC_ALL=C comm -23 - $exported > $stale && ` +
+				`if [ -s $stale ]; then ` +
+				`echo "error: $versionScript lists symbols that are not exported by the library:" >&2 && ` +
+				`cat $stale >&2 && exit 1; ` +
+				`fi && touch $out`,
+		},
+		"versionScript", "exported", "stale")
+
+	unusedSharedLibs = pctx.AndroidStaticRule("unusedSharedLibs",
+		blueprint.RuleParams{
+			Command: "rm -f $out && touch $out && " +
+				`${crossCompile}nm -u $in | awk '{print $$NF}' | sed 's/@.*//' | LC_ALL=C sort -u > $undefined && ` +
+				`for toc in $tocs; do ` +
+				`awk '$$1 ~ /^[0-9]+:$$/ && $$3 != "LOCAL" && $$5 != "UND" && $$6 != "" {sub(/@.*/, "", $$6); print $$6}' $$toc | ` +
+				`LC_ALL=C sort -u | LC_ALL=C comm -12 - $undefined | grep -q . || ` +
+				`basename $$toc .toc >> $out; done && ` +
+				`sed 's/^/warning: $moduleName: unused shared library /' $out >&2`,
+		},
+		"crossCompile", "tocs", "moduleName", "undefined")
+
+	// Lists the compiler warnings of a source file, without line and column numbers so that the
+	// list doesn't change when unrelated lines are edited. The output of the compiler is kept in
+	// ${out}.log, and printed if it fails.
+	clangWarnings = pctx.AndroidStaticRule("clangWarnings",
+		blueprint.RuleParams{
+			Command: "rm -f $out && ($relPwd $ccCmd -fsyntax-only $cFlags -c $in >/dev/null 2>${out}.log || " +
+				"(cat ${out}.log >&2 && exit 1)) && " +
+				`sed -n -E 's/:[0-9]+:[0-9]+: warning: /: warning: /p' ${out}.log | LC_ALL=C sort -u > $out`,
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	// Lists the files included by a source file, which are only found through the include dirs
+	// when it is compiled, in the format of a depfile.
+	ccInputDeps = pctx.AndroidStaticRule("ccInputDeps",
+		blueprint.RuleParams{
+			Command:     "$relPwd $ccCmd $cFlags -M -MF $out $in",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	// Writes the sorted list of the given lines and of the files listed in the depfiles.
+	inputsManifest = pctx.AndroidStaticRule("inputsManifest",
+		blueprint.RuleParams{
+			Command: "(printf '%s\\n' $lines && " +
+				`sed -e 's/^[^:]*://' -e 's/\\$$//' /dev/null $in | tr -s ' ' '\n') | ` +
+				"grep -v '^$$' | LC_ALL=C sort -u > $out",
+		},
+		"lines")
+
+	warningBaselineCheck = pctx.AndroidStaticRule("warningBaselineCheck",
+		blueprint.RuleParams{
+			Command: "rm -f $out && new=$$(cat $in | LC_ALL=C sort -u | grep -v -x -F -f $baseline || true) && " +
+				`if [ -n "$$new" ]; then ` +
+				`echo "error: new compiler warnings that are not in $baseline:" >&2 && echo "$$new" >&2 && exit 1; ` +
+				`fi && touch $out`,
+		},
+		"baseline")
+
+	// Fails if the objects define or reference RTTI symbols: type_info objects and names, or the
+	// runtime support of dynamic_cast and typeid.
+	noRttiCheck = pctx.AndroidStaticRule("noRttiCheck",
+		blueprint.RuleParams{
+			Command: "rm -f $out && syms=$$(${crossCompile}nm -A $in) && " +
+				`rtti=$$(echo "$$syms" | grep -E ' (_ZTI|_ZTS|__dynamic_cast$$|__cxa_bad_cast$$|__cxa_bad_typeid$$)' || true) && ` +
+				`if [ -n "$$rtti" ]; then ` +
+				`echo "error: RTTI symbols found in a module built with no_rtti:" >&2 && echo "$$rtti" >&2 && exit 1; ` +
+				`fi && touch $out`,
+		},
+		"crossCompile")
+
+	// Compiles a header on its own in the language given by -x, only checking its syntax.
+	headerCheck = pctx.AndroidStaticRule("headerCheck",
+		blueprint.RuleParams{
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "rm -f $out && $ccCmd -fsyntax-only -x $lang $cFlags -MD -MF ${out}.d -MT $out $in && touch $out",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "lang", "cFlags")
+
+	prebuiltChecksum = pctx.AndroidStaticRule("prebuiltChecksum",
+		blueprint.RuleParams{
+			Command: `rm -f $out && ` +
+				`if ! echo "$sha256  $in" | sha256sum --check --status; then ` +
+				`echo "error: $in does not match sha256 $sha256, got $$(sha256sum $in | cut -d' ' -f1)" >&2 && exit 1; ` +
+				`fi && touch $out`,
+		},
+		"sha256")
+
 	clangTidy = pctx.AndroidStaticRule("clangTidy",
 		blueprint.RuleParams{
 			Command:     "rm -f $out && CLANG_TIDY=${config.ClangBin}/clang-tidy ${config.ClangTidyShellPath} $tidyFlags $in -- $cFlags && touch $out",
@@ -223,6 +331,22 @@ var (
 		},
 		"allowFlags", "referenceDump", "libName", "arch", "createReferenceDumpFlags")
 
+	// Fails if any mangled C++ name of the reference ABI dump is missing from the new dump, which
+	// happens when e.g. the inline namespace or the template arguments of an exported entity
+	// change, even if header-abi-diff considers the change compatible.
+	sAbiMangledNamesCheck = pctx.AndroidStaticRule("sAbiMangledNamesCheck",
+		blueprint.RuleParams{
+			Command: `rm -f $out && ` +
+				`grep -o 'linker_set_key: "_Z[^"]*"' ${referenceDump} | LC_ALL=C sort -u > ${out}.old && ` +
+				`grep -o 'linker_set_key: "_Z[^"]*"' $in | LC_ALL=C sort -u > ${out}.new && ` +
+				`LC_ALL=C comm -23 ${out}.old ${out}.new > ${out}.removed && ` +
+				`if [ -s ${out}.removed ]; then ` +
+				`echo "error: ${libName} must have a stable C++ ABI, but these mangled names changed:" && ` +
+				`cat ${out}.removed && exit 1; fi && ` +
+				`touch $out`,
+		},
+		"referenceDump", "libName")
+
 	unzipRefSAbiDump = pctx.AndroidStaticRule("unzipRefSAbiDump",
 		blueprint.RuleParams{
 			Command: "gunzip -c $in > $out",
@@ -250,6 +374,8 @@ type builderFlags struct {
 	toolingCppFlags string // A separate set of cppFlags for clang LibTooling tools
 	conlyFlags      string
 	cppFlags        string
+	objcFlags       string
+	objcppFlags     string
 	ldFlags         string
 	libFlags        string
 	yaccFlags       string
@@ -262,6 +388,12 @@ type builderFlags struct {
 	tidy            bool
 	coverage        bool
 	sAbiDump        bool
+	warnings        bool
+	inputDeps       bool
+
+	tidyDisabledSrcs android.Paths
+
+	srcFlags map[string]string
 
 	systemIncludeFlags string
 
@@ -272,6 +404,7 @@ type builderFlags struct {
 	stripKeepMiniDebugInfo bool
 	stripAddGnuDebuglink   bool
 	stripUseGnuStrip       bool
+	stripTool              android.OptionalPath
 
 	proto            android.ProtoFlags
 	protoC           bool
@@ -283,6 +416,14 @@ type Objects struct {
 	tidyFiles     android.Paths
 	coverageFiles android.Paths
 	sAbiDumpFiles android.Paths
+	warningFiles  android.Paths
+
+	// Lists of the files included by the compiled sources, in the format of depfiles
+	inputDepsFiles android.Paths
+
+	// Outputs of the checks of the compiled sources, which have to pass before the objects are
+	// linked
+	checkFiles android.Paths
 }
 
 func (a Objects) Copy() Objects {
@@ -291,6 +432,10 @@ func (a Objects) Copy() Objects {
 		tidyFiles:     append(android.Paths{}, a.tidyFiles...),
 		coverageFiles: append(android.Paths{}, a.coverageFiles...),
 		sAbiDumpFiles: append(android.Paths{}, a.sAbiDumpFiles...),
+		warningFiles:  append(android.Paths{}, a.warningFiles...),
+
+		inputDepsFiles: append(android.Paths{}, a.inputDepsFiles...),
+		checkFiles:     append(android.Paths{}, a.checkFiles...),
 	}
 }
 
@@ -300,6 +445,10 @@ func (a Objects) Append(b Objects) Objects {
 		tidyFiles:     append(a.tidyFiles, b.tidyFiles...),
 		coverageFiles: append(a.coverageFiles, b.coverageFiles...),
 		sAbiDumpFiles: append(a.sAbiDumpFiles, b.sAbiDumpFiles...),
+		warningFiles:  append(a.warningFiles, b.warningFiles...),
+
+		inputDepsFiles: append(a.inputDepsFiles, b.inputDepsFiles...),
+		checkFiles:     append(a.checkFiles, b.checkFiles...),
 	}
 }
 
@@ -309,8 +458,10 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 
 	objFiles := make(android.Paths, len(srcFiles))
 	var tidyFiles android.Paths
+	var tidyDisabledSrcs []string
 	if flags.tidy {
 		tidyFiles = make(android.Paths, 0, len(srcFiles))
+		tidyDisabledSrcs = flags.tidyDisabledSrcs.Strings()
 	}
 	var coverageFiles android.Paths
 	if flags.coverage {
@@ -346,6 +497,28 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 		flags.cppFlags,
 	}, " ")
 
+	// Objective-C and Objective-C++ sources get the flags of C and C++ sources respectively, plus
+	// their own flags which don't leak to the C and C++ compiles.
+	objcflags := strings.Join([]string{
+		cflags,
+		flags.objcFlags,
+	}, " ")
+
+	toolingObjcflags := strings.Join([]string{
+		toolingCflags,
+		flags.objcFlags,
+	}, " ")
+
+	objcppflags := strings.Join([]string{
+		cppflags,
+		flags.objcppFlags,
+	}, " ")
+
+	toolingObjcppflags := strings.Join([]string{
+		toolingCppflags,
+		flags.objcppFlags,
+	}, " ")
+
 	asflags := strings.Join([]string{
 		commonFlags,
 		flags.asFlags,
@@ -356,10 +529,24 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 		sAbiDumpFiles = make(android.Paths, 0, len(srcFiles))
 	}
 
+	var warningFiles android.Paths
+	if flags.warnings {
+		warningFiles = make(android.Paths, 0, len(srcFiles))
+	}
+
+	var inputDepsFiles android.Paths
+	if flags.inputDeps {
+		inputDepsFiles = make(android.Paths, 0, len(srcFiles))
+	}
+
 	cflags += " ${config.NoOverrideClangGlobalCflags}"
 	toolingCflags += " ${config.NoOverrideClangGlobalCflags}"
 	cppflags += " ${config.NoOverrideClangGlobalCflags}"
 	toolingCppflags += " ${config.NoOverrideClangGlobalCflags}"
+	objcflags += " ${config.NoOverrideClangGlobalCflags}"
+	toolingObjcflags += " ${config.NoOverrideClangGlobalCflags}"
+	objcppflags += " ${config.NoOverrideClangGlobalCflags}"
+	toolingObjcppflags += " ${config.NoOverrideClangGlobalCflags}"
 
 	for i, srcFile := range srcFiles {
 		objFile := android.ObjPathWithExt(ctx, subdir, srcFile, "o")
@@ -402,6 +589,7 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 		tidy := flags.tidy
 		coverage := flags.coverage
 		dump := flags.sAbiDump
+		warnings := flags.warnings
 		rule := cc
 
 		switch srcFile.Ext() {
@@ -414,19 +602,33 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 			tidy = false
 			coverage = false
 			dump = false
+			warnings = false
 		case ".c":
 			ccCmd = "clang"
 			moduleCflags = cflags
 			moduleToolingCflags = toolingCflags
-		case ".cpp", ".cc", ".mm":
+		case ".m":
+			ccCmd = "clang"
+			moduleCflags = objcflags
+			moduleToolingCflags = toolingObjcflags
+		case ".cpp", ".cc":
 			ccCmd = "clang++"
 			moduleCflags = cppflags
 			moduleToolingCflags = toolingCppflags
+		case ".mm":
+			ccCmd = "clang++"
+			moduleCflags = objcppflags
+			moduleToolingCflags = toolingObjcppflags
 		default:
 			ctx.ModuleErrorf("File %s has unknown extension", srcFile)
 			continue
 		}
 
+		if srcFlags := flags.srcFlags[srcFile.String()]; srcFlags != "" {
+			moduleCflags += " " + srcFlags
+			moduleToolingCflags += " " + srcFlags
+		}
+
 		ccDesc := ccCmd
 
 		ccCmd = "${config.ClangBin}/" + ccCmd
@@ -452,7 +654,7 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 			},
 		})
 
-		if tidy {
+		if tidy && !inList(srcFile.String(), tidyDisabledSrcs) {
 			tidyFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy")
 			tidyFiles = append(tidyFiles, tidyFile)
 
@@ -471,6 +673,43 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 			})
 		}
 
+		if warnings {
+			warningFile := android.ObjPathWithExt(ctx, subdir, srcFile, "warnings")
+			warningFiles = append(warningFiles, warningFile)
+
+			ctx.Build(pctx, android.BuildParams{
+				Rule:           clangWarnings,
+				Description:    "warnings " + srcFile.Rel(),
+				Output:         warningFile,
+				ImplicitOutput: android.ObjPathWithExt(ctx, subdir, srcFile, "warnings.log"),
+				Input:          srcFile,
+				// Depend on objFile like clang-tidy does, for the generated headers.
+				Implicit: objFile,
+				Args: map[string]string{
+					"cFlags": moduleCflags,
+					"ccCmd":  ccCmd,
+				},
+			})
+		}
+
+		if flags.inputDeps && rule == cc {
+			inputDepsFile := android.ObjPathWithExt(ctx, subdir, srcFile, "deps")
+			inputDepsFiles = append(inputDepsFiles, inputDepsFile)
+
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        ccInputDeps,
+				Description: "input deps " + srcFile.Rel(),
+				Output:      inputDepsFile,
+				Input:       srcFile,
+				// Depend on objFile like clang-tidy does, for the generated headers.
+				Implicit: objFile,
+				Args: map[string]string{
+					"cFlags": moduleCflags,
+					"ccCmd":  ccCmd,
+				},
+			})
+		}
+
 		if dump {
 			sAbiDumpFile := android.ObjPathWithExt(ctx, subdir, srcFile, "sdump")
 			sAbiDumpFiles = append(sAbiDumpFiles, sAbiDumpFile)
@@ -495,7 +734,96 @@ func TransformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 		tidyFiles:     tidyFiles,
 		coverageFiles: coverageFiles,
 		sAbiDumpFiles: sAbiDumpFiles,
+		warningFiles:  warningFiles,
+
+		inputDepsFiles: inputDepsFiles,
+	}
+}
+
+// Generate a rule for writing the sorted list of the given lines, which must be escaped for ninja
+// and the shell, and of the files listed in the given depfiles
+func TransformInputDepsToInputsManifest(ctx android.ModuleContext, lines []string,
+	inputDepsFiles android.Paths, outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        inputsManifest,
+		Description: "inputs manifest " + outputFile.Base(),
+		Output:      outputFile,
+		Inputs:      inputDepsFiles,
+		Args: map[string]string{
+			"lines": strings.Join(lines, " "),
+		},
+	})
+}
+
+// Generate a rule for checking that the compiler warnings listed in warningFiles are all in the
+// baseline file
+func TransformWarningsToBaselineCheck(ctx android.ModuleContext, warningFiles android.Paths,
+	baseline android.Path, outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        warningBaselineCheck,
+		Description: "check warnings against " + baseline.Rel(),
+		Output:      outputFile,
+		Inputs:      warningFiles,
+		Implicit:    baseline,
+		Args: map[string]string{
+			"baseline": baseline.String(),
+		},
+	})
+}
+
+// Generate a rule for checking that objFiles don't use RTTI
+func TransformObjsToNoRttiCheck(ctx android.ModuleContext, objFiles android.Paths,
+	flags builderFlags, outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        noRttiCheck,
+		Description: "check no RTTI " + outputFile.Base(),
+		Output:      outputFile,
+		Inputs:      objFiles,
+		Args: map[string]string{
+			"crossCompile": gccCmd(flags.toolchain, ""),
+		},
+	})
+}
+
+// Generate rules for checking that headers compile both as C and as C++, and return the outputs
+// of the checks
+func TransformHeadersToDualLanguageChecks(ctx android.ModuleContext, headers android.Paths,
+	flags builderFlags, includeFlags string) android.Paths {
+
+	commonFlags := strings.Join([]string{
+		flags.globalFlags,
+		flags.systemIncludeFlags,
+		includeFlags,
+		flags.cFlags,
+	}, " ")
+
+	var checks android.Paths
+	for _, header := range headers {
+		for _, l := range []struct {
+			lang, ccCmd, cFlags string
+		}{
+			{"c-header", "clang", commonFlags + " " + flags.conlyFlags},
+			{"c++-header", "clang++", commonFlags + " " + flags.cppFlags},
+		} {
+			check := android.ObjPathWithExt(ctx, "dual_language_headers", header, l.lang+".check")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        headerCheck,
+				Description: l.ccCmd + " check " + header.Rel(),
+				Output:      check,
+				Input:       header,
+				Args: map[string]string{
+					"ccCmd":  "${config.ClangBin}/" + l.ccCmd,
+					"lang":   l.lang,
+					"cFlags": l.cFlags,
+				},
+			})
+			checks = append(checks, check)
+		}
 	}
+	return checks
 }
 
 // Generate a rule for compiling multiple .o files to a static library (.a)
@@ -609,7 +937,8 @@ func transformDarwinObjToStaticLib(ctx android.ModuleContext, objFiles android.P
 // and shared libraries, to a shared library (.so) or dynamic executable
 func TransformObjToDynamicBinary(ctx android.ModuleContext,
 	objFiles, sharedLibs, staticLibs, lateStaticLibs, wholeStaticLibs, deps android.Paths,
-	crtBegin, crtEnd android.OptionalPath, groupLate bool, flags builderFlags, outputFile android.WritablePath) {
+	crtBegin, crtEnd android.OptionalPath, groupLate bool, flags builderFlags, outputFile android.WritablePath,
+	implicitOutputs android.WritablePaths) {
 
 	ldCmd := "${config.ClangBin}/clang++"
 
@@ -657,11 +986,12 @@ func TransformObjToDynamicBinary(ctx android.ModuleContext,
 	}
 
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        ld,
-		Description: "link " + outputFile.Base(),
-		Output:      outputFile,
-		Inputs:      objFiles,
-		Implicits:   deps,
+		Rule:            ld,
+		Description:     "link " + outputFile.Base(),
+		Output:          outputFile,
+		ImplicitOutputs: implicitOutputs,
+		Inputs:          objFiles,
+		Implicits:       deps,
 		Args: map[string]string{
 			"ldCmd":    ldCmd,
 			"crtBegin": crtBegin.String(),
@@ -758,6 +1088,69 @@ func SourceAbiDiff(ctx android.ModuleContext, inputDump android.Path, referenceD
 	return android.OptionalPathForPath(outputFile)
 }
 
+// Generate a rule for checking that the mangled C++ names of a library match its reference ABI dump
+func SourceAbiMangledNamesCheck(ctx android.ModuleContext, inputDump android.Path, referenceDump android.Path,
+	baseName string) android.Path {
+
+	outputFile := android.PathForModuleOut(ctx, baseName+".mangled_names_check")
+	libName := strings.TrimSuffix(baseName, filepath.Ext(baseName))
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sAbiMangledNamesCheck,
+		Description: "mangled names check " + outputFile.Base(),
+		Output:      outputFile,
+		ImplicitOutputs: android.WritablePaths{
+			android.PathForModuleOut(ctx, outputFile.Base()+".old"),
+			android.PathForModuleOut(ctx, outputFile.Base()+".new"),
+			android.PathForModuleOut(ctx, outputFile.Base()+".removed"),
+		},
+		Input:    inputDump,
+		Implicit: referenceDump,
+		Args: map[string]string{
+			"referenceDump": referenceDump.String(),
+			"libName":       libName,
+		},
+	})
+	return outputFile
+}
+
+// Generate a rule for listing the shared libraries that define none of the symbols referenced by
+// the given objects and archives, and printing a warning for each of them
+func TransformObjsToUnusedSharedLibsReport(ctx android.ModuleContext, objFiles android.Paths,
+	tocFiles android.Paths, flags builderFlags, outputFile android.ModuleOutPath) {
+
+	undefined := outputFile.InSameDir(ctx, outputFile.Base()+".undefined")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:           unusedSharedLibs,
+		Description:    "check unused shared libs " + outputFile.Base(),
+		Output:         outputFile,
+		ImplicitOutput: undefined,
+		Inputs:         objFiles,
+		Implicits:      tocFiles,
+		Args: map[string]string{
+			"crossCompile": gccCmd(flags.toolchain, ""),
+			"tocs":         strings.Join(tocFiles.Strings(), " "),
+			"moduleName":   ctx.ModuleName(),
+			"undefined":    undefined.String(),
+		},
+	})
+}
+
+// Generate a rule for verifying the SHA-256 checksum of a prebuilt file
+func TransformPrebuiltToChecksumCheck(ctx android.ModuleContext, inputFile android.Path,
+	sha256 string, outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        prebuiltChecksum,
+		Description: "verify checksum " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"sha256": sha256,
+		},
+	})
+}
+
 // Generate a rule for extracting a table of contents from a shared library (.so)
 func TransformSharedObjectToToc(ctx android.ModuleContext, inputFile android.Path,
 	outputFile android.WritablePath, flags builderFlags) {
@@ -787,6 +1180,45 @@ func TransformSharedObjectToToc(ctx android.ModuleContext, inputFile android.Pat
 	})
 }
 
+// Generate a rule for listing the weak undefined symbols in the dynamic symbol table of a
+// shared library (.so).  These are the symbols that are optionally resolved at runtime, as
+// opposed to the strong imports that must be satisfied at load time.
+func TransformSharedObjectToWeakUndefinedSymbols(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath, flags builderFlags) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        weakUndefinedSymbols,
+		Description: "weak undefined symbols " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"crossCompile": gccCmd(flags.toolchain, ""),
+		},
+	})
+}
+
+// Generate a rule for checking that the symbols in a version script are exported by the shared
+// library whose table of contents is given
+func TransformTocToVersionScriptCheck(ctx android.ModuleContext, tocFile android.Path,
+	versionScript android.Path, outputFile android.ModuleOutPath) {
+
+	exported := outputFile.InSameDir(ctx, outputFile.Base()+".exported")
+	stale := outputFile.InSameDir(ctx, outputFile.Base()+".stale")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:            versionScriptCheck,
+		Description:     "check version script " + versionScript.Base(),
+		Output:          outputFile,
+		ImplicitOutputs: android.WritablePaths{exported, stale},
+		Input:           tocFile,
+		Implicit:        versionScript,
+		Args: map[string]string{
+			"versionScript": versionScript.String(),
+			"exported":      exported.String(),
+			"stale":         stale.String(),
+		},
+	})
+}
+
 // Generate a rule for compiling multiple .o files to a .o using ld partial linking
 func TransformObjsToObj(ctx android.ModuleContext, objFiles android.Paths,
 	flags builderFlags, outputFile android.WritablePath) {
@@ -805,6 +1237,42 @@ func TransformObjsToObj(ctx android.ModuleContext, objFiles android.Paths,
 	})
 }
 
+// Generate a rule for aligning the allocated sections of an object file, by partially linking it
+// again with a linker script that gives each output section the alignment
+func TransformObjToSectionAlignment(ctx android.ModuleContext, alignment int64, inputFile android.Path,
+	flags builderFlags, outputFile android.WritablePath) {
+
+	align := strconv.FormatInt(alignment, 10)
+	linkerScript := android.PathForModuleOut(ctx, "section_alignment.ld")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        writeLines,
+		Description: "section alignment linker script",
+		Output:      linkerScript,
+		Args: map[string]string{
+			"lines": strings.Join(proptools.NinjaAndShellEscapeList([]string{
+				"SECTIONS {",
+				"  .text : ALIGN(" + align + ") { *(.text .text.*) }",
+				"  .rodata : ALIGN(" + align + ") { *(.rodata .rodata.*) }",
+				"  .data : ALIGN(" + align + ") { *(.data .data.*) }",
+				"  .bss : ALIGN(" + align + ") { *(.bss .bss.*) }",
+				"}",
+			}), " "),
+		},
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        partialLd,
+		Description: "align sections " + outputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Implicit:    linkerScript,
+		Args: map[string]string{
+			"ldCmd":   "${config.ClangBin}/clang++",
+			"ldFlags": flags.ldFlags + " -Wl,-T," + linkerScript.String(),
+		},
+	})
+}
+
 // Generate a rule for runing objcopy --prefix-symbols on a binary
 func TransformBinaryPrefixSymbols(ctx android.ModuleContext, prefix string, inputFile android.Path,
 	flags builderFlags, outputFile android.WritablePath) {
@@ -843,12 +1311,18 @@ func TransformStrip(ctx android.ModuleContext, inputFile android.Path,
 	if flags.stripUseGnuStrip {
 		args += " --use-gnu-strip"
 	}
+	var implicits android.Paths
+	if flags.stripTool.Valid() {
+		args += " -t " + flags.stripTool.String()
+		implicits = append(implicits, flags.stripTool.Path())
+	}
 
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        strip,
 		Description: "strip " + outputFile.Base(),
 		Output:      outputFile,
 		Input:       inputFile,
+		Implicits:   implicits,
 		Args: map[string]string{
 			"crossCompile": crossCompile,
 			"args":         args,
@@ -19,6 +19,7 @@ package cc
 // is handled in builder.go
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -81,6 +82,9 @@ type Deps struct {
 	HeaderLibs                                  []string
 	RuntimeLibs                                 []string
 
+	// Header libraries that are not allowed in ReexportHeaderLibHeaders
+	PrivateHeaderLibs []string
+
 	ReexportSharedLibHeaders, ReexportStaticLibHeaders, ReexportHeaderLibHeaders []string
 
 	ObjFiles []string
@@ -95,6 +99,12 @@ type Deps struct {
 	// Used for host bionic
 	LinkerFlagsFile string
 	DynamicLinker   string
+
+	// Genrule whose exported include directory is the sysroot
+	Sysroot string
+
+	// Used for the apexes installed alongside tests
+	DataApexes []string
 }
 
 type PathDeps struct {
@@ -114,6 +124,9 @@ type PathDeps struct {
 	GeneratedSources android.Paths
 	GeneratedHeaders android.Paths
 
+	// Extra cflags of the generated source files, keyed by their paths
+	GeneratedSourceFlags map[string][]string
+
 	Flags, ReexportedFlags []string
 	ReexportedFlagsDeps    android.Paths
 
@@ -123,8 +136,19 @@ type PathDeps struct {
 	// Path to the file container flags to use with the linker
 	LinkerFlagsFile android.OptionalPath
 
+	// Path to the generated sysroot
+	Sysroot android.OptionalPath
+
 	// Path to the dynamic linker binary
 	DynamicLinker android.OptionalPath
+
+	// Paths to the .a files of the static libraries that come from prebuilts instead of being
+	// built from source
+	PrebuiltStaticLibs android.Paths
+
+	// Paths to the .so.toc files of the direct shared_libs dependencies, only set when unused
+	// shared libraries are reported
+	UnusedSharedLibsCandidates android.Paths
 }
 
 type Flags struct {
@@ -135,6 +159,8 @@ type Flags struct {
 	ToolingCFlags   []string // Flags that apply to C and C++ source files parsed by clang LibTooling tools
 	ConlyFlags      []string // Flags that apply to C source files
 	CppFlags        []string // Flags that apply to C++ source files
+	ObjcFlags       []string // Flags that apply to Objective-C source files
+	ObjcppFlags     []string // Flags that apply to Objective-C++ source files
 	ToolingCppFlags []string // Flags that apply to C++ source files parsed by clang LibTooling tools
 	YaccFlags       []string // Flags that apply to Yacc source files
 	aidlFlags       []string // Flags that apply to aidl source files
@@ -154,6 +180,14 @@ type Flags struct {
 	Coverage  bool
 	SAbiDump  bool
 
+	TidyDisabledSrcs android.Paths // Source files that should not be checked by clang-tidy
+
+	SrcFlags map[string]string // Extra cflags of some source files, keyed by their paths
+
+	WarningBaseline android.OptionalPath // File listing the compiler warnings that are allowed
+
+	InputDeps bool // Whether to list the files included by each source file
+
 	RequiredInstructionSet string
 	DynamicLinker          string
 
@@ -167,12 +201,136 @@ type Flags struct {
 	protoOptionsFile bool // Whether to look for a .options file next to the .proto
 }
 
+// PrebuiltStaticLibsInfo describes the static libraries that a module links against which are not
+// built from source.
+type PrebuiltStaticLibsInfo struct {
+	// Whether the module links against any prebuilt static library.
+	LinksPrebuiltStaticLibs bool
+	// The archives of the prebuilt static libraries, including whole static libraries.
+	Archives android.Paths
+}
+
+// FlagExporterInfo describes the flags that a library exports to the modules that depend on it.
+type FlagExporterInfo struct {
+	// The exported flags, e.g. "-I<dir>" and "-D<macro>=<value>". Kept for compatibility, consumers
+	// that need the macros should use ExportedDefines instead of parsing these flags.
+	ReexportedFlags []string
+	// The files that the exported flags depend on, e.g. generated headers.
+	ReexportedFlagsDeps android.Paths
+	// The macros defined by the exported flags, mapped to their values.
+	ExportedDefines map[string]string
+}
+
+// AidlResolvedInfo describes how the .aidl sources of a module are translated, so that the code
+// generation can be replayed outside of the build.
+type AidlResolvedInfo struct {
+	// The flags passed to aidl-cpp, including the include flags.
+	Flags []string
+	// The directories added to the aidl include paths.
+	IncludeDirs android.Paths
+}
+
+// OptimizationInfo describes the size and link-time optimizations requested by a module.
+type OptimizationInfo struct {
+	// The icf property of the module, or the empty string for the toolchain default.
+	Icf string
+	// Whether the sources are compiled with -faddrsig to allow safe identical code folding.
+	Addrsig bool
+	// The kind of link-time optimization, "none", "thin" or "full".
+	LtoMode string
+}
+
+// LinkerFlagsInfo describes the link flags of a module, separated by whether they are passed
+// through to the linker or handled by the compiler driver.
+type LinkerFlagsInfo struct {
+	// Flags passed through to the linker, e.g. "-Wl,--gc-sections" or "-Xlinker --gc-sections".
+	LinkerFlags []string
+	// Flags handled by the compiler driver, e.g. "-nostdlib" or "-fuse-ld=lld". Entries may
+	// reference ninja variables that need to be evaluated by the consumer.
+	DriverFlags []string
+}
+
+// ImplMappingInfo maps a stubs variant of a library to the implementation it stands in for.
+type ImplMappingInfo struct {
+	// The name of the module that implements the stubs.
+	ImplementationModuleName string
+	// The name of the implementation in Make, e.g. "libfoo.bootstrap" for a library that is
+	// directly in an APEX.
+	ImplementationModuleNameForMake string
+	// The version of the stubs, e.g. "28", or the empty string for LL-NDK stubs.
+	StubsVersion string
+	// The output file of the stubs, which modules link against instead of the implementation.
+	OutputFile android.Path
+}
+
+// StrippedMappingInfo maps the stripped output file of a module to its unstripped counterpart,
+// e.g. for symbolizing crashes of the installed binaries.
+type StrippedMappingInfo struct {
+	// The output file of the module, which is stripped unless strip.none is set.
+	Stripped android.Path
+	// The output file of the linker before stripping, with the symbols and debug info.
+	Unstripped android.Path
+	// The installed copy of Stripped, if the module is installed.
+	Installed android.OptionalPath
+}
+
+// SanitizersInfo describes the sanitizers a module is built with.
+type SanitizersInfo struct {
+	// The sanitizers passed to -fsanitize=, e.g. "address" or "cfi".
+	Sanitizers []string
+	// The sanitizers that run in the diagnostic mode.
+	DiagSanitizers []string
+}
+
+// SanitizerVariantsInfo lists the sanitized variants that were built for a module.
+type SanitizerVariantsInfo struct {
+	// The sanitizer variations of the module, e.g. "cfi" or "hwasan", in the order they were
+	// created.
+	Variations []string
+}
+
+// FpPolicyInfo describes the floating point policy of a module.
+type FpPolicyInfo struct {
+	// The fp_policy property of the module, or the empty string for the toolchain default.
+	Policy string
+}
+
+// LanguageStandardInfo describes the language standards that the sources of a module are compiled
+// with, after merging the defaults with the module's own flags.
+type LanguageStandardInfo struct {
+	// The value of the effective -std= flag for C sources, e.g. "gnu99"
+	CStd string
+	// The value of the effective -std= flag for C++ sources, e.g. "gnu++17"
+	CppStd string
+}
+
+// ToolchainIncludesInfo describes the system include directories that the toolchain and the build
+// system inject into the compilation of a module, separately from the module's own include
+// directories.
+type ToolchainIncludesInfo struct {
+	// Flags that add the system include directories, e.g. "-isystem <dir>". Entries may
+	// reference ninja variables that need to be evaluated by the consumer.
+	SystemIncludeFlags []string
+}
+
+// EffectiveCflagsInfo describes the flags that are passed to clang for the sources of a module,
+// after the global, toolchain, sanitizer and other features have added theirs.
+type EffectiveCflagsInfo struct {
+	// The global flags, the C flags and the system include flags, in the order they are passed
+	// to clang. Entries may reference ninja variables that need to be evaluated by the consumer.
+	CFlags []string
+}
+
 type ObjectLinkerProperties struct {
 	// names of other cc_object modules to link into this module using partial linking
 	Objs []string `android:"arch_variant"`
 
 	// if set, add an extra objcopy --prefix-symbols= step
 	Prefix_symbols *string
+
+	// if set, the allocated sections of the output object are aligned to this many bytes. Must be
+	// a power of two. Useful when the object is later placed at a page boundary in another binary.
+	Section_alignment *int64
 }
 
 // Properties used to compile all C or C++ modules
@@ -180,13 +338,24 @@ type BaseProperties struct {
 	// Deprecated. true is the default, false is invalid.
 	Clang *bool `android:"arch_variant"`
 
-	// Minimum sdk version supported when compiling against the ndk
-	Sdk_version *string
+	// Minimum sdk version supported when compiling against the ndk. May be set per architecture,
+	// e.g. when an architecture needs a higher minimum than the others.
+	Sdk_version *string `android:"arch_variant"`
+
+	// API level of the NDK stub libraries to link against, if different from sdk_version.
+	// Must not be newer than sdk_version, and is ignored when not compiling against the ndk.
+	Ndk_api_level *string `android:"arch_variant"`
+
+	// List of names of modules built without sdk_version or with an incompatible STL that this
+	// module may nevertheless link against when it is built against the NDK.  Only for the rare
+	// cases that are known to be safe.
+	Allow_ndk_link_to []string
 
 	AndroidMkSharedLibs       []string `blueprint:"mutated"`
 	AndroidMkStaticLibs       []string `blueprint:"mutated"`
 	AndroidMkRuntimeLibs      []string `blueprint:"mutated"`
 	AndroidMkWholeStaticLibs  []string `blueprint:"mutated"`
+	AndroidMkHeaderLibs       []string `blueprint:"mutated"`
 	HideFromMake              bool     `blueprint:"mutated"`
 	PreventInstall            bool     `blueprint:"mutated"`
 	ApexesProvidingSharedLibs []string `blueprint:"mutated"`
@@ -205,6 +374,16 @@ type BaseProperties struct {
 	// Allows this module to use non-APEX version of libraries. Useful
 	// for building binaries that are started before APEXes are activated.
 	Bootstrap *bool
+
+	// List of flags in the global list of illegal cflags, e.g. "-w", that are kept in the cflags
+	// of this module instead of being removed. Only meant for the transition of legacy code, the
+	// modules that set it are reported by the build.
+	Allow_illegal_cflags []string
+
+	// Write a manifest listing the inputs of the build of this module, e.g. its sources, the headers
+	// they include, flags files, libraries and the compiler and archiver, for use as a key by
+	// external build caches.
+	Inputs_manifest *bool
 }
 
 type VendorProperties struct {
@@ -350,6 +529,7 @@ var (
 	crtBeginDepTag        = dependencyTag{name: "crtbegin"}
 	crtEndDepTag          = dependencyTag{name: "crtend"}
 	linkerFlagsDepTag     = dependencyTag{name: "linker flags file"}
+	sysrootDepTag         = dependencyTag{name: "sysroot"}
 	dynamicLinkerDepTag   = dependencyTag{name: "dynamic linker"}
 	reuseObjTag           = dependencyTag{name: "reuse objects"}
 	staticVariantTag      = dependencyTag{name: "static variant"}
@@ -357,6 +537,7 @@ var (
 	ndkLateStubDepTag     = dependencyTag{name: "ndk late stub", library: true}
 	vndkExtDepTag         = dependencyTag{name: "vndk extends", library: true}
 	runtimeDepTag         = dependencyTag{name: "runtime lib"}
+	dataApexDepTag        = dependencyTag{name: "data apex"}
 )
 
 // Module contains the properties and members used by all C/C++ module types, and implements
@@ -399,6 +580,23 @@ type Module struct {
 	// Flags used to compile this module
 	flags Flags
 
+	toolchainIncludesInfo  ToolchainIncludesInfo
+	effectiveCflagsInfo    EffectiveCflagsInfo
+	languageStandardInfo   LanguageStandardInfo
+	prebuiltStaticLibsInfo PrebuiltStaticLibsInfo
+	linkerFlagsInfo        LinkerFlagsInfo
+	implMappingInfo        *ImplMappingInfo
+	strippedMappingInfo    *StrippedMappingInfo
+
+	// the manifest of the inputs of this module, see inputs_manifest
+	inputsManifest android.OptionalPath
+
+	compileMetrics CcCompileMetrics
+
+	// The kind of library dependency, e.g. "static", on each of the dependencies resolved by
+	// depsToPaths, keyed by their module names
+	resolvedDepKinds map[string]string
+
 	// When calling a linker, if module A depends on module B, then A must precede B in its command
 	// line invocation. depsInLinkOrder stores the proper ordering of all of the transitive
 	// deps of this module
@@ -426,6 +624,169 @@ func (c *Module) RelativeInstallPath() string {
 	return ""
 }
 
+// DataPaths returns the data files installed alongside a test or a benchmark.
+func (c *Module) DataPaths() android.Paths {
+	switch t := c.linker.(type) {
+	case *testBinary:
+		return t.data
+	case *benchmarkDecorator:
+		return t.data
+	}
+	return nil
+}
+
+// LinkMapFile returns the map file written by the linker when emit_link_map is set.
+func (c *Module) LinkMapFile() android.OptionalPath {
+	if linker, ok := c.linker.(interface {
+		linkMap() android.OptionalPath
+	}); ok {
+		return linker.linkMap()
+	}
+	return android.OptionalPath{}
+}
+
+// NonPicStaticLibFile returns the archive of the objects compiled without -fPIC if
+// build_nonpic_variant is set for the static variant of a library.
+func (c *Module) NonPicStaticLibFile() android.OptionalPath {
+	if library, ok := c.linker.(*libraryDecorator); ok {
+		return library.nonPicOutputFile
+	}
+	return android.OptionalPath{}
+}
+
+// ToolchainIncludesInfo returns the system include directories injected by the toolchain when
+// compiling this module.
+func (c *Module) ToolchainIncludesInfo() ToolchainIncludesInfo {
+	return c.toolchainIncludesInfo
+}
+
+// EffectiveCflagsInfo returns the flags that are passed to clang for the sources of this module.
+func (c *Module) EffectiveCflagsInfo() EffectiveCflagsInfo {
+	return c.effectiveCflagsInfo
+}
+
+// LanguageStandardInfo returns the language standards this module is compiled with.
+func (c *Module) LanguageStandardInfo() LanguageStandardInfo {
+	return c.languageStandardInfo
+}
+
+// LinkerFlagsInfo returns the link flags of this module, with the flags passed through to the
+// linker separated from the compiler driver flags.
+func (c *Module) LinkerFlagsInfo() LinkerFlagsInfo {
+	return c.linkerFlagsInfo
+}
+
+// ImplMappingInfo returns the implementation of this module if it is a stubs variant, or false
+// otherwise.
+func (c *Module) ImplMappingInfo() (ImplMappingInfo, bool) {
+	if c.implMappingInfo == nil {
+		return ImplMappingInfo{}, false
+	}
+	return *c.implMappingInfo, true
+}
+
+// StrippedMappingInfo returns the stripped and unstripped output files of this module, or false
+// if it doesn't link an output file that is stripped, e.g. for static libraries.
+func (c *Module) StrippedMappingInfo() (StrippedMappingInfo, bool) {
+	if c.strippedMappingInfo == nil {
+		return StrippedMappingInfo{}, false
+	}
+	return *c.strippedMappingInfo, true
+}
+
+// InputsManifest returns the manifest listing the inputs of the build of this module, if
+// inputs_manifest is set.
+func (c *Module) InputsManifest() android.OptionalPath {
+	return c.inputsManifest
+}
+
+// PrebuiltStaticLibsInfo returns the prebuilt static libraries this module links against.
+func (c *Module) PrebuiltStaticLibsInfo() PrebuiltStaticLibsInfo {
+	return c.prebuiltStaticLibsInfo
+}
+
+// FlagExporterInfo returns the flags this module exports to the modules that depend on it. It is
+// empty for modules that are not libraries.
+func (c *Module) FlagExporterInfo() FlagExporterInfo {
+	var info FlagExporterInfo
+	if exporter, ok := c.linker.(exportedFlagsProducer); ok {
+		info.ReexportedFlags = append([]string(nil), exporter.exportedFlags()...)
+		info.ReexportedFlagsDeps = append(android.Paths(nil), exporter.exportedFlagsDeps()...)
+		if defines := exporter.exportedDefines(); len(defines) > 0 {
+			info.ExportedDefines = make(map[string]string, len(defines))
+			for name, value := range defines {
+				info.ExportedDefines[name] = value
+			}
+		}
+	}
+	return info
+}
+
+// AidlResolvedInfo returns the flags and include directories used to translate the .aidl sources of
+// this module.
+func (c *Module) AidlResolvedInfo() AidlResolvedInfo {
+	info := AidlResolvedInfo{
+		Flags: append([]string(nil), c.flags.aidlFlags...),
+	}
+	if compiler, ok := c.compiler.(interface {
+		aidlResolvedIncludeDirs() android.Paths
+	}); ok {
+		info.IncludeDirs = append(android.Paths(nil), compiler.aidlResolvedIncludeDirs()...)
+	}
+	return info
+}
+
+// SanitizersInfo returns the sanitizers this module is built with.
+func (c *Module) SanitizersInfo() SanitizersInfo {
+	if c.sanitize == nil {
+		return SanitizersInfo{}
+	}
+	return SanitizersInfo{
+		Sanitizers:     append([]string(nil), c.sanitize.Properties.Sanitizers...),
+		DiagSanitizers: append([]string(nil), c.sanitize.Properties.DiagSanitizers...),
+	}
+}
+
+// SanitizerVariantsInfo returns the sanitized variants that were built for this module.
+func (c *Module) SanitizerVariantsInfo() SanitizerVariantsInfo {
+	if c.sanitize == nil {
+		return SanitizerVariantsInfo{}
+	}
+	return SanitizerVariantsInfo{
+		Variations: append([]string(nil), c.sanitize.Properties.SanitizerVariations...),
+	}
+}
+
+// FpPolicyInfo returns the floating point policy this module is compiled with.
+func (c *Module) FpPolicyInfo() FpPolicyInfo {
+	if compiler, ok := c.compiler.(interface {
+		fpPolicy() string
+	}); ok {
+		return FpPolicyInfo{Policy: compiler.fpPolicy()}
+	}
+	return FpPolicyInfo{}
+}
+
+// usesFastFpPolicyOnUntrustedInput returns true if this module chose fp_policy: "fast" while
+// being compiled with the integer_overflow or cfi sanitizers, which are enabled for code that
+// handles untrusted input.
+func (c *Module) usesFastFpPolicyOnUntrustedInput() bool {
+	return c.FpPolicyInfo().Policy == "fast" &&
+		(c.sanitize.isSanitizerEnabled(intOverflow) || c.sanitize.isSanitizerEnabled(cfi))
+}
+
+// OptimizationInfo returns the size and link-time optimizations this module is built with.
+func (c *Module) OptimizationInfo() OptimizationInfo {
+	info := OptimizationInfo{LtoMode: c.lto.Mode()}
+	if linker, ok := c.linker.(interface {
+		icf() string
+	}); ok {
+		info.Icf = linker.icf()
+		info.Addrsig = info.Icf == "safe"
+	}
+	return info
+}
+
 func (c *Module) Init() android.Module {
 	c.AddProperties(&c.Properties, &c.VendorProperties)
 	if c.compiler != nil {
@@ -687,6 +1048,14 @@ func (ctx *moduleContextImpl) sdkVersion() string {
 	return ""
 }
 
+// ndkApiLevel returns the API level that NDK stub library dependencies should be resolved at.
+func (ctx *moduleContextImpl) ndkApiLevel() string {
+	if ctx.useSdk() && ctx.mod.Properties.Ndk_api_level != nil {
+		return String(ctx.mod.Properties.Ndk_api_level)
+	}
+	return ctx.sdkVersion()
+}
+
 func (ctx *moduleContextImpl) useVndk() bool {
 	return ctx.mod.useVndk()
 }
@@ -927,6 +1296,10 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	if ctx.Failed() {
 		return
 	}
+	c.prebuiltStaticLibsInfo = PrebuiltStaticLibsInfo{
+		LinksPrebuiltStaticLibs: len(deps.PrebuiltStaticLibs) > 0,
+		Archives:                deps.PrebuiltStaticLibs,
+	}
 
 	if c.Properties.Clang != nil && *c.Properties.Clang == false {
 		ctx.PropertyErrorf("clang", "false (GCC) is no longer supported")
@@ -966,12 +1339,30 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		return
 	}
 
-	flags.CFlags, _ = filterList(flags.CFlags, config.IllegalFlags)
-	flags.CppFlags, _ = filterList(flags.CppFlags, config.IllegalFlags)
-	flags.ConlyFlags, _ = filterList(flags.ConlyFlags, config.IllegalFlags)
+	illegalFlags := c.illegalCflags(ctx)
+	flags.CFlags, _ = filterList(flags.CFlags, illegalFlags)
+	flags.CppFlags, _ = filterList(flags.CppFlags, illegalFlags)
+	flags.ConlyFlags, _ = filterList(flags.ConlyFlags, illegalFlags)
+	flags.ObjcFlags, _ = filterList(flags.ObjcFlags, illegalFlags)
+	flags.ObjcppFlags, _ = filterList(flags.ObjcppFlags, illegalFlags)
 
 	flags.GlobalFlags = append(flags.GlobalFlags, deps.Flags...)
 	c.flags = flags
+	c.toolchainIncludesInfo = ToolchainIncludesInfo{
+		SystemIncludeFlags: append([]string(nil), flags.SystemIncludeFlags...),
+	}
+	effectiveCflags := append([]string(nil), flags.GlobalFlags...)
+	effectiveCflags = append(effectiveCflags, flags.CFlags...)
+	effectiveCflags = append(effectiveCflags, flags.SystemIncludeFlags...)
+	c.effectiveCflagsInfo = EffectiveCflagsInfo{CFlags: effectiveCflags}
+	c.languageStandardInfo = LanguageStandardInfo{
+		CStd:   lastStdFlag(flags.CFlags, flags.ConlyFlags),
+		CppStd: lastStdFlag(flags.CFlags, flags.CppFlags),
+	}
+	c.linkerFlagsInfo.LinkerFlags, c.linkerFlagsInfo.DriverFlags = splitLinkerFlags(flags.LdFlags)
+	if c.usesFastFpPolicyOnUntrustedInput() {
+		addToModuleList(ctx, modulesUsingFastFpPolicyOnUntrustedInputKey, ctx.ModuleDir()+"/Android.bp:"+ctx.ModuleName())
+	}
 	// We need access to all the flags seen by a source file.
 	if c.sabi != nil {
 		flags = c.sabi.flags(ctx, flags)
@@ -984,6 +1375,7 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	flags.CFlags = []string{"$cflags"}
 	flags.CppFlags = []string{"$cppflags"}
 	flags.AsFlags = []string{"$asflags"}
+	flags.InputDeps = Bool(c.Properties.Inputs_manifest)
 
 	var objs Objects
 	if c.compiler != nil {
@@ -991,6 +1383,7 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		if ctx.Failed() {
 			return
 		}
+		c.compileMetrics = c.computeCompileMetrics()
 	}
 
 	if c.linker != nil {
@@ -1000,6 +1393,23 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		}
 		c.outputFile = android.OptionalPathForPath(outputFile)
 
+		if c.IsStubs() {
+			c.implMappingInfo = c.implementationMapping(ctx, outputFile)
+		}
+
+		if len(deps.UnusedSharedLibsCandidates) > 0 && !c.static() {
+			var linkedObjs android.Paths
+			linkedObjs = append(linkedObjs, objs.objFiles...)
+			linkedObjs = append(linkedObjs, deps.Objs.objFiles...)
+			linkedObjs = append(linkedObjs, deps.WholeStaticLibs...)
+			linkedObjs = append(linkedObjs, deps.StaticLibs...)
+			linkedObjs = append(linkedObjs, deps.LateStaticLibs...)
+			report := android.PathForModuleOut(ctx, "unused_shared_libs.txt")
+			TransformObjsToUnusedSharedLibsReport(ctx, linkedObjs, deps.UnusedSharedLibsCandidates,
+				flagsToBuilderFlags(flags), report)
+			ctx.CheckbuildFile(report)
+		}
+
 		// If a lib is directly included in any of the APEXes, unhide the stubs
 		// variant having the latest version gets visible to make. In addition,
 		// the non-stubs variant is renamed to <libname>.bootstrap. This is to
@@ -1015,12 +1425,99 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		}
 	}
 
+	if Bool(c.Properties.Inputs_manifest) {
+		c.inputsManifest = android.OptionalPathForPath(c.buildInputsManifest(ctx, flags, deps, objs))
+	}
+
 	if c.installer != nil && !c.Properties.PreventInstall && c.IsForPlatform() && c.outputFile.Valid() {
 		c.installer.install(ctx, c.outputFile.Path())
 		if ctx.Failed() {
 			return
 		}
 	}
+
+	if unstripped := c.UnstrippedOutputFile(); unstripped != nil && c.outputFile.Valid() {
+		c.strippedMappingInfo = &StrippedMappingInfo{
+			Stripped:   c.outputFile.Path(),
+			Unstripped: unstripped,
+		}
+		if installer, ok := c.installer.(interface {
+			installedFile() android.OptionalPath
+		}); ok {
+			c.strippedMappingInfo.Installed = installer.installedFile()
+		}
+	}
+}
+
+// illegalCflags returns the flags to remove from the cflags of this module, which are the global
+// illegal flags except the ones listed in allow_illegal_cflags.
+func (c *Module) illegalCflags(ctx ModuleContext) []string {
+	allowed := c.Properties.Allow_illegal_cflags
+	if len(allowed) == 0 {
+		return config.IllegalFlags
+	}
+	for _, flag := range allowed {
+		if !inList(flag, config.IllegalFlags) {
+			ctx.PropertyErrorf("allow_illegal_cflags", "%q is not an illegal flag", flag)
+		}
+	}
+	addToModuleList(ctx, modulesAllowingIllegalCflagsKey, ctx.ModuleDir()+"/Android.bp:"+ctx.ModuleName())
+	illegalFlags, _ := filterList(config.IllegalFlags, allowed)
+	return illegalFlags
+}
+
+// buildInputsManifest writes the sorted list of the inputs of the build of this module, one per
+// line, relative to the top of the source tree.
+func (c *Module) buildInputsManifest(ctx ModuleContext, flags Flags, deps PathDeps, objs Objects) android.Path {
+	var inputs android.Paths
+	if compiler, ok := c.compiler.(CompiledInterface); ok {
+		inputs = append(inputs, compiler.Srcs()...)
+	}
+	inputs = append(inputs, deps.GeneratedHeaders...)
+	inputs = append(inputs, deps.ReexportedFlagsDeps...)
+	inputs = append(inputs, flags.CFlagsDeps...)
+	inputs = append(inputs, flags.LdFlagsDeps...)
+	if c.linker != nil {
+		inputs = append(inputs, deps.SharedLibs...)
+		inputs = append(inputs, deps.EarlySharedLibs...)
+		inputs = append(inputs, deps.LateSharedLibs...)
+		inputs = append(inputs, deps.StaticLibs...)
+		inputs = append(inputs, deps.LateStaticLibs...)
+		inputs = append(inputs, deps.WholeStaticLibs...)
+		inputs = append(inputs, deps.Objs.objFiles...)
+		for _, crt := range []android.OptionalPath{deps.CrtBegin, deps.CrtEnd, deps.LinkerFlagsFile} {
+			if crt.Valid() {
+				inputs = append(inputs, crt.Path())
+			}
+		}
+	}
+
+	lines := proptools.NinjaAndShellEscapeList(android.FirstUniqueStrings(inputs.Strings()))
+	// The compiler and the archiver are run from the toolchain, which ninja expands to its path.
+	lines = append(lines, "${config.ClangBin}/clang", "${config.ClangBin}/clang++",
+		"${config.ClangBin}/llvm-ar")
+
+	manifest := android.PathForModuleOut(ctx, "inputs_manifest.txt")
+	TransformInputDepsToInputsManifest(ctx, lines, objs.inputDepsFiles, manifest)
+	ctx.CheckbuildFile(manifest)
+	return manifest
+}
+
+// implementationMapping returns the implementation of this stubs variant.
+func (c *Module) implementationMapping(ctx ModuleContext, outputFile android.Path) *ImplMappingInfo {
+	info := &ImplMappingInfo{
+		ImplementationModuleName:        ctx.baseModuleName(),
+		ImplementationModuleNameForMake: ctx.baseModuleName(),
+		OutputFile:                      outputFile,
+	}
+	if library, ok := c.linker.(*libraryDecorator); ok {
+		info.StubsVersion = library.stubsVersion()
+		// Keep in sync with the SubName of the implementation in libraryDecorator.AndroidMk.
+		if android.DirectlyInAnyApex(ctx, ctx.baseModuleName()) && !c.inRecovery() && !c.useVndk() {
+			info.ImplementationModuleNameForMake += ".bootstrap"
+		}
+	}
+	return info
 }
 
 func (c *Module) toolchain(ctx android.BaseContext) config.Toolchain {
@@ -1067,6 +1564,18 @@ func (c *Module) begin(ctx BaseModuleContext) {
 			ctx.PropertyErrorf("sdk_version", err.Error())
 		}
 		c.Properties.Sdk_version = StringPtr(version)
+
+		if c.Properties.Ndk_api_level != nil {
+			ndkVersion, err := normalizeNdkApiLevel(ctx, String(c.Properties.Ndk_api_level), ctx.Arch())
+			if err != nil {
+				ctx.PropertyErrorf("ndk_api_level", err.Error())
+			} else if ndkApiLevelNewerThan(ndkVersion, version) {
+				ctx.PropertyErrorf("ndk_api_level", "%q is newer than sdk_version %q", ndkVersion, version)
+			}
+			c.Properties.Ndk_api_level = StringPtr(ndkVersion)
+		}
+	} else if c.Properties.Ndk_api_level != nil && c.Properties.Sdk_version == nil {
+		ctx.PropertyErrorf("ndk_api_level", "requires sdk_version to be set")
 	}
 }
 
@@ -1113,6 +1622,7 @@ func (c *Module) deps(ctx DepsContext) Deps {
 	deps.SharedLibs = android.LastUniqueStrings(deps.SharedLibs)
 	deps.LateSharedLibs = android.LastUniqueStrings(deps.LateSharedLibs)
 	deps.HeaderLibs = android.LastUniqueStrings(deps.HeaderLibs)
+	deps.PrivateHeaderLibs = android.LastUniqueStrings(deps.PrivateHeaderLibs)
 	deps.RuntimeLibs = android.LastUniqueStrings(deps.RuntimeLibs)
 
 	for _, lib := range deps.ReexportSharedLibHeaders {
@@ -1128,11 +1638,21 @@ func (c *Module) deps(ctx DepsContext) Deps {
 	}
 
 	for _, lib := range deps.ReexportHeaderLibHeaders {
-		if !inList(lib, deps.HeaderLibs) {
+		if inList(lib, deps.PrivateHeaderLibs) {
+			ctx.PropertyErrorf("export_header_lib_headers", "Header library in private_header_libs can't be exported: '%s'", lib)
+		} else if !inList(lib, deps.HeaderLibs) {
 			ctx.PropertyErrorf("export_header_lib_headers", "Header library not in header_libs: '%s'", lib)
 		}
 	}
 
+	for _, lib := range deps.PrivateHeaderLibs {
+		if inList(lib, deps.HeaderLibs) {
+			ctx.PropertyErrorf("private_header_libs", "Header library also in header_libs: '%s'", lib)
+		}
+	}
+	// Past the checks above, private_header_libs are plain header_libs.
+	deps.HeaderLibs = append(deps.HeaderLibs, deps.PrivateHeaderLibs...)
+
 	for _, gen := range deps.ReexportGeneratedHeaders {
 		if !inList(gen, deps.GeneratedHeaders) {
 			ctx.PropertyErrorf("export_generated_headers", "Generated header module not in generated_headers: '%s'", gen)
@@ -1175,10 +1695,40 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 
 	deps := c.deps(ctx)
 
+	// Replace the aliases of renamed libraries with their names. The modules still using aliases
+	// are listed for make to warn about.
+	aliases := libraryAliases(actx.Config())
+	resolveAliases := func(list []string) []string {
+		var ret []string
+		for _, entry := range list {
+			name, version := stubsLibNameAndVersion(entry)
+			if lib, ok := aliases[name]; ok {
+				getNamedMapForConfig(actx.Config(), modulesUsingLibraryAliasesKey).Store(
+					ctx.ModuleDir()+"/Android.bp:"+ctx.ModuleName(), true)
+				entry = lib
+				if version != "" {
+					entry += "#" + version
+				}
+			}
+			ret = append(ret, entry)
+		}
+		return ret
+	}
+	deps.SharedLibs = resolveAliases(deps.SharedLibs)
+	deps.LateSharedLibs = resolveAliases(deps.LateSharedLibs)
+	deps.StaticLibs = resolveAliases(deps.StaticLibs)
+	deps.LateStaticLibs = resolveAliases(deps.LateStaticLibs)
+	deps.WholeStaticLibs = resolveAliases(deps.WholeStaticLibs)
+	deps.HeaderLibs = resolveAliases(deps.HeaderLibs)
+	deps.RuntimeLibs = resolveAliases(deps.RuntimeLibs)
+	deps.ReexportSharedLibHeaders = resolveAliases(deps.ReexportSharedLibHeaders)
+	deps.ReexportStaticLibHeaders = resolveAliases(deps.ReexportStaticLibHeaders)
+	deps.ReexportHeaderLibHeaders = resolveAliases(deps.ReexportHeaderLibHeaders)
+
 	variantNdkLibs := []string{}
 	variantLateNdkLibs := []string{}
 	if ctx.Os() == android.Android {
-		version := ctx.sdkVersion()
+		version := ctx.ndkApiLevel()
 
 		// rewriteNdkLibs takes a list of names of shared libraries and scans it for three types
 		// of names:
@@ -1293,6 +1843,10 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 	}, lateStaticDepTag, deps.LateStaticLibs...)
 
 	addSharedLibDependencies := func(depTag dependencyTag, name string, version string) {
+		if isStaticOnlyLibrary(actx.Config(), name, c) {
+			ctx.PropertyErrorf("shared_libs", "%q is a static library, move it to static_libs", name)
+			return
+		}
 		var variations []blueprint.Variation
 		variations = append(variations, blueprint.Variation{Mutator: "link", Variation: "shared"})
 		versionVariantAvail := !ctx.useVndk() && !c.inRecovery()
@@ -1373,8 +1927,16 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 	if deps.DynamicLinker != "" {
 		actx.AddDependency(c, dynamicLinkerDepTag, deps.DynamicLinker)
 	}
+	if deps.Sysroot != "" {
+		actx.AddDependency(c, sysrootDepTag, deps.Sysroot)
+	}
+
+	// apex modules are only built for the common architecture
+	actx.AddFarVariationDependencies([]blueprint.Variation{
+		{Mutator: "arch", Variation: ctx.Os().String() + "_common"},
+	}, dataApexDepTag, deps.DataApexes...)
 
-	version := ctx.sdkVersion()
+	version := ctx.ndkApiLevel()
 	actx.AddVariationDependencies([]blueprint.Variation{
 		{Mutator: "ndk_api", Variation: version},
 		{Mutator: "link", Variation: "shared"},
@@ -1442,9 +2004,7 @@ func checkLinkType(ctx android.ModuleContext, from *Module, to *Module, tag depe
 		return
 	}
 
-	if strings.HasPrefix(ctx.ModuleName(), "libclang_rt.") && to.Name() == "libc++" {
-		// Bug: http://b/121358700 - Allow libclang_rt.* shared libraries (with sdk_version)
-		// to link to libc++ (non-NDK and without sdk_version).
+	if inList(ctx.OtherModuleName(to), allowedNdkLinkTargets(ctx.ModuleName(), from)) {
 		return
 	}
 
@@ -1504,6 +2064,48 @@ func checkLinkType(ctx android.ModuleContext, from *Module, to *Module, tag depe
 	}
 }
 
+// allowedNdkLinkTargets returns the names of the modules that the NDK module from may link against
+// regardless of their sdk_version and STL.
+func allowedNdkLinkTargets(fromName string, from *Module) []string {
+	allowed := append([]string(nil), from.Properties.Allow_ndk_link_to...)
+	if strings.HasPrefix(fromName, "libclang_rt.") {
+		// Bug: http://b/121358700 - Allow libclang_rt.* shared libraries (with sdk_version)
+		// to link to libc++ (non-NDK and without sdk_version).
+		allowed = append(allowed, "libc++")
+	}
+	return allowed
+}
+
+// doubleLoadableChain formats a dependency path found by checkDoubleLoadableLibraries with one hop
+// per line, so that it is clear which edge has to be cut. tags[i] is the tag of the dependency from
+// path[i] to path[i+1].
+func doubleLoadableChain(path []android.Module, tags []blueprint.DependencyTag) string {
+	vendorVariant := func(m android.Module) string {
+		if c, ok := m.(*Module); ok && c.hasVendorVariant() {
+			return "has vendor variant"
+		}
+		return "no vendor variant"
+	}
+
+	var lines []string
+	for i, m := range path {
+		if i == 0 {
+			lines = append(lines, fmt.Sprintf("    %s (%s)", m.Name(), vendorVariant(m)))
+			continue
+		}
+		kind := "unknown"
+		if i-1 < len(tags) {
+			if tag, ok := tags[i-1].(dependencyTag); ok {
+				kind = tag.name
+			} else if tags[i-1] != nil {
+				kind = fmt.Sprintf("%T", tags[i-1])
+			}
+		}
+		lines = append(lines, fmt.Sprintf("    -> [%s] %s (%s)", kind, m.Name(), vendorVariant(m)))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // Tests whether the dependent library is okay to be double loaded inside a single process.
 // If a library has a vendor variant and is a (transitive) dependency of an LLNDK library,
 // it is subject to be double loaded. Such lib should be explicitly marked as double_loadable: true
@@ -1529,13 +2131,9 @@ func checkDoubleLoadableLibraries(ctx android.TopDownMutatorContext) {
 			return false
 		}
 
-		var stringPath []string
-		for _, m := range ctx.GetWalkPath() {
-			stringPath = append(stringPath, m.Name())
-		}
 		ctx.ModuleErrorf("links a library %q which is not LL-NDK, "+
-			"VNDK-SP, or explicitly marked as 'double_loadable:true'. "+
-			"(dependency: %s)", ctx.OtherModuleName(to), strings.Join(stringPath, " -> "))
+			"VNDK-SP, or explicitly marked as 'double_loadable:true'. Dependency chain:\n%s",
+			ctx.OtherModuleName(to), doubleLoadableChain(ctx.GetWalkPath(), ctx.GetTagPath()))
 		return false
 	}
 	if module, ok := ctx.Module().(*Module); ok {
@@ -1547,12 +2145,24 @@ func checkDoubleLoadableLibraries(ctx android.TopDownMutatorContext) {
 	}
 }
 
+// Returns the number of objects, including the ones of its own whole_static_libs, that a single
+// whole_static_libs entry can contribute before the module is listed for make to warn about, or 0
+// if SOONG_WHOLE_STATIC_LIBS_OBJECT_LIMIT is not set to a number.
+func wholeStaticLibObjectLimit(config android.Config) int {
+	limit, err := strconv.Atoi(config.Getenv("SOONG_WHOLE_STATIC_LIBS_OBJECT_LIMIT"))
+	if err != nil {
+		return 0
+	}
+	return limit
+}
+
 // Convert dependencies to paths.  Returns a PathDeps containing paths
 func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 	var depPaths PathDeps
 
 	directStaticDeps := []*Module{}
 	directSharedDeps := []*Module{}
+	c.resolvedDepKinds = make(map[string]string)
 
 	ctx.VisitDirectDeps(func(dep android.Module) {
 		depName := ctx.OtherModuleName(dep)
@@ -1566,6 +2176,14 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				if genRule, ok := dep.(genrule.SourceFileGenerator); ok {
 					depPaths.GeneratedSources = append(depPaths.GeneratedSources,
 						genRule.GeneratedSourceFiles()...)
+					if flagsGen, ok := dep.(genrule.SourceFileFlagsGenerator); ok {
+						for src, flags := range flagsGen.GeneratorSourceFlags() {
+							if depPaths.GeneratedSourceFlags == nil {
+								depPaths.GeneratedSourceFlags = make(map[string][]string)
+							}
+							depPaths.GeneratedSourceFlags[src] = flags
+						}
+					}
 				} else {
 					ctx.ModuleErrorf("module %q is not a gensrcs or genrule", depName)
 				}
@@ -1599,6 +2217,18 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				} else {
 					ctx.ModuleErrorf("module %q is not a genrule", depName)
 				}
+			case sysrootDepTag:
+				if genRule, ok := dep.(genrule.SourceFileGenerator); ok {
+					dirs := genRule.GeneratedHeaderDirs()
+					if len(dirs) == 1 {
+						depPaths.Sysroot = android.OptionalPathForPath(dirs[0])
+						depPaths.GeneratedHeaders = append(depPaths.GeneratedHeaders, genRule.GeneratedDeps()...)
+					} else {
+						ctx.ModuleErrorf("module %q must export a single include directory to be used as the sysroot", depName)
+					}
+				} else {
+					ctx.ModuleErrorf("module %q is not a genrule", depName)
+				}
 			}
 			return
 		}
@@ -1651,6 +2281,14 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			case staticDepTag, staticExportDepTag, lateStaticDepTag, wholeStaticDepTag:
 				depIsStatic = true
 			}
+			if depTag == sharedDepTag || depTag == sharedExportDepTag {
+				// Prebuilt libraries always have a shared variant, even when they are only
+				// built static, so they are not caught by isStaticOnlyLibrary.
+				if l, ok := ccDep.linker.(libraryInterface); ok && !l.buildShared() {
+					ctx.PropertyErrorf("shared_libs", "%q is a static library, move it to static_libs", depName)
+					return
+				}
+			}
 			if dependentLibrary, ok := ccDep.linker.(*libraryDecorator); ok && !depIsStatic {
 				depIsStubs := dependentLibrary.buildStubs()
 				depHasStubs := ccDep.HasStubsVariants()
@@ -1716,6 +2354,9 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			depPtr = &depPaths.SharedLibsDeps
 			depFile = ccDep.linker.(libraryInterface).toc()
 			directSharedDeps = append(directSharedDeps, ccDep)
+			if depTag != ndkStubDepTag && depFile.Valid() && ctx.Config().IsEnvTrue("WARN_UNUSED_SHARED_LIBS") {
+				depPaths.UnusedSharedLibsCandidates = append(depPaths.UnusedSharedLibsCandidates, depFile.Path())
+			}
 		case earlySharedDepTag:
 			ptr = &depPaths.EarlySharedLibs
 			depPtr = &depPaths.EarlySharedLibsDeps
@@ -1746,6 +2387,13 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				ctx.AddMissingDependencies(missingDeps)
 			}
 			depPaths.WholeStaticLibObjs = depPaths.WholeStaticLibObjs.Append(staticLib.objs())
+
+			// Whole-archiving a library that pulls in many objects is often a mistake, list the
+			// module for make to warn about.
+			if limit := wholeStaticLibObjectLimit(ctx.Config()); limit > 0 && len(staticLib.objs().objFiles) > limit {
+				getNamedMapForConfig(ctx.Config(), modulesWithLargeWholeStaticLibsKey).Store(
+					ctx.ModuleDir()+"/Android.bp:"+ctx.ModuleName()+":"+depName, true)
+			}
 		case headerDepTag:
 			// Nothing
 		case objDepTag:
@@ -1776,6 +2424,13 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 
 		}
 
+		switch depTag {
+		case staticDepTag, staticExportDepTag, lateStaticDepTag, wholeStaticDepTag:
+			if _, ok := ccDep.linker.(*prebuiltLibraryLinker); ok && linkFile.Valid() {
+				depPaths.PrebuiltStaticLibs = append(depPaths.PrebuiltStaticLibs, linkFile.Path())
+			}
+		}
+
 		if ptr != nil {
 			if !linkFile.Valid() {
 				ctx.ModuleErrorf("module %q missing output file", depName)
@@ -1849,6 +2504,15 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 		case wholeStaticDepTag:
 			c.Properties.AndroidMkWholeStaticLibs = append(
 				c.Properties.AndroidMkWholeStaticLibs, makeLibName(depName))
+		case headerDepTag, headerExportDepTag:
+			c.Properties.AndroidMkHeaderLibs = append(
+				c.Properties.AndroidMkHeaderLibs, makeLibName(depName))
+		}
+
+		if kind := ccDepKind(depTag); kind != "" {
+			if _, exists := c.resolvedDepKinds[depName]; !exists {
+				c.resolvedDepKinds[depName] = kind
+			}
 		}
 	})
 
@@ -1868,6 +2532,15 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 	return depPaths
 }
 
+// IsTest returns true if the module is a test or a benchmark, e.g. cc_test or cc_benchmark.
+func (c *Module) IsTest() bool {
+	switch c.linker.(type) {
+	case *testBinary, *testLibrary, *benchmarkDecorator:
+		return true
+	}
+	return false
+}
+
 func (c *Module) InstallInData() bool {
 	if c.installer == nil {
 		return false
@@ -1889,6 +2562,15 @@ func (c *Module) InstallInRecovery() bool {
 	return c.inRecovery()
 }
 
+func (c *Module) InstallPartition() string {
+	if installer, ok := c.installer.(interface {
+		installPartition() string
+	}); ok {
+		return installer.installPartition()
+	}
+	return ""
+}
+
 func (c *Module) HostToolPath() android.OptionalPath {
 	if c.installer == nil {
 		return android.OptionalPath{}
@@ -16,11 +16,19 @@ package cc
 
 import (
 	"android/soong/android"
+	"android/soong/cc/config"
+	"android/soong/genrule"
+
+	"github.com/google/blueprint"
 
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"sort"
 	"strings"
 	"testing"
@@ -64,13 +72,19 @@ func createTestContext(t *testing.T, config android.Config, bp string, fs map[st
 	ctx.RegisterModuleType("toolchain_library", android.ModuleFactoryAdaptor(ToolchainLibraryFactory))
 	ctx.RegisterModuleType("llndk_library", android.ModuleFactoryAdaptor(LlndkLibraryFactory))
 	ctx.RegisterModuleType("llndk_headers", android.ModuleFactoryAdaptor(llndkHeadersFactory))
+	ctx.RegisterModuleType("ndk_library", android.ModuleFactoryAdaptor(ndkLibraryFactory))
 	ctx.RegisterModuleType("vendor_public_library", android.ModuleFactoryAdaptor(vendorPublicLibraryFactory))
 	ctx.RegisterModuleType("cc_object", android.ModuleFactoryAdaptor(ObjectFactory))
 	ctx.RegisterModuleType("filegroup", android.ModuleFactoryAdaptor(android.FileGroupFactory))
+	ctx.RegisterModuleType("cc_test", android.ModuleFactoryAdaptor(TestFactory))
+	ctx.RegisterModuleType("genrule", android.ModuleFactoryAdaptor(genrule.GenRuleFactory))
+	ctx.RegisterSingletonType("cc_dep_graph", android.SingletonFactoryAdaptor(ccDepGraphSingleton))
+	ctx.RegisterSingletonType("cc_whydep", android.SingletonFactoryAdaptor(ccWhyDepSingleton))
 	ctx.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
 		ctx.BottomUp("image", ImageMutator).Parallel()
 		ctx.BottomUp("link", LinkageMutator).Parallel()
 		ctx.BottomUp("vndk", VndkMutator).Parallel()
+		ctx.BottomUp("ndk_api", ndkApiMutator).Parallel()
 		ctx.BottomUp("version", VersionMutator).Parallel()
 		ctx.BottomUp("begin", BeginMutator).Parallel()
 	})
@@ -107,7 +121,19 @@ func testCcWithConfig(t *testing.T, bp string, config android.Config) *android.T
 
 func testCcWithConfigForOs(t *testing.T, bp string, config android.Config, os android.OsType) *android.TestContext {
 	t.Helper()
-	ctx := createTestContext(t, config, bp, nil, os)
+	return testCcWithConfigAndFsForOs(t, bp, config, nil, os)
+}
+
+func testCcWithConfigAndFs(t *testing.T, bp string, config android.Config, fs map[string][]byte) *android.TestContext {
+	t.Helper()
+	return testCcWithConfigAndFsForOs(t, bp, config, fs, android.Android)
+}
+
+func testCcWithConfigAndFsForOs(t *testing.T, bp string, config android.Config, fs map[string][]byte,
+	os android.OsType) *android.TestContext {
+
+	t.Helper()
+	ctx := createTestContext(t, config, bp, fs, os)
 	ctx.Register()
 
 	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
@@ -127,6 +153,14 @@ func testCc(t *testing.T, bp string) *android.TestContext {
 	return testCcWithConfig(t, bp, config)
 }
 
+// testCcWithFs is like testCc without VNDK, with the files in fs added to the mock file system.
+func testCcWithFs(t *testing.T, bp string, fs map[string][]byte) *android.TestContext {
+	t.Helper()
+	config := android.TestArchConfig(buildDir, nil)
+
+	return testCcWithConfigAndFs(t, bp, config, fs)
+}
+
 func testCcNoVndk(t *testing.T, bp string) *android.TestContext {
 	t.Helper()
 	config := android.TestArchConfig(buildDir, nil)
@@ -141,6 +175,11 @@ func testCcError(t *testing.T, pattern string, bp string) {
 	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
 	config.TestProductVariables.Platform_vndk_version = StringPtr("VER")
 
+	testCcErrorWithConfig(t, pattern, bp, config)
+}
+
+func testCcErrorWithConfig(t *testing.T, pattern string, bp string, config android.Config) {
+	t.Helper()
 	ctx := createTestContext(t, config, bp, nil, android.Android)
 	ctx.Register()
 
@@ -159,6 +198,23 @@ func testCcError(t *testing.T, pattern string, bp string) {
 	t.Fatalf("missing expected error %q (0 errors are returned)", pattern)
 }
 
+// runRuleCommand runs the command of a rule with bash after replacing the given ninja variables in
+// it, and returns the error of the command.
+func runRuleCommand(command string, vars map[string]string) error {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	// Replace the longest names first, so that e.g. $in doesn't match the start of $include.
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	oldnew := []string{"$$", "$"}
+	for _, name := range names {
+		oldnew = append(oldnew, "${"+name+"}", vars[name], "$"+name, vars[name])
+	}
+	return exec.Command("/bin/bash", "-c", strings.NewReplacer(oldnew...).Replace(command)).Run()
+}
+
 const (
 	coreVariant     = "android_arm64_armv8-a_core_shared"
 	vendorVariant   = "android_arm64_armv8-a_vendor_shared"
@@ -747,6 +803,41 @@ func TestDoubleLoadableDepError(t *testing.T) {
 	`)
 }
 
+func TestDoubleLoadableDepErrorChain(t *testing.T) {
+	// Check that the error prints each hop of a deep chain on its own line.
+	testCcError(t, regexp.QuoteMeta(`links a library "libvendoravailable" which is not LL-NDK, `+
+		`VNDK-SP, or explicitly marked as 'double_loadable:true'. Dependency chain:
+    libllndk (no vendor variant)
+    -> [shared] libcoreonly (no vendor variant)
+    -> [shared] libcoreonly2 (no vendor variant)
+    -> [shared] libvendoravailable (has vendor variant)`), `
+		cc_library {
+			name: "libllndk",
+			shared_libs: ["libcoreonly"],
+		}
+
+		llndk_library {
+			name: "libllndk",
+			symbol_file: "",
+		}
+
+		cc_library {
+			name: "libcoreonly",
+			shared_libs: ["libcoreonly2"],
+		}
+
+		cc_library {
+			name: "libcoreonly2",
+			shared_libs: ["libvendoravailable"],
+		}
+
+		cc_library {
+			name: "libvendoravailable",
+			vendor_available: true,
+		}
+	`)
+}
+
 func TestVndkMustNotBeProductSpecific(t *testing.T) {
 	// Check whether an error is emitted when a vndk lib has 'product_specific: true'.
 	testCcError(t, "product_specific must not be true when `vndk: {enabled: true}`", `
@@ -1801,6 +1892,108 @@ func TestExcludeRuntimeLibs(t *testing.T) {
 	checkRuntimeLibs(t, nil, module)
 }
 
+func TestArchExcludeSharedLibs(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			shared_libs: ["libbar", "libbaz"],
+			export_shared_lib_headers: ["libbaz"],
+			arch: {
+				arm64: {
+					exclude_shared_libs: ["libbaz"],
+				},
+			},
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libbar",
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libbaz",
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}`)
+
+	for _, tc := range []struct {
+		variant string
+		libbaz  bool
+	}{
+		{"android_arm64_armv8-a_core_shared", false},
+		{"android_arm_armv7-a-neon_core_shared", true},
+	} {
+		libFlags := ctx.ModuleForTests("libfoo", tc.variant).Rule("ld").Args["libFlags"]
+		if !strings.Contains(libFlags, "libbar.so") {
+			t.Errorf("%s: expected libbar.so in libFlags, got %q", tc.variant, libFlags)
+		}
+		if g, w := strings.Contains(libFlags, "libbaz.so"), tc.libbaz; g != w {
+			t.Errorf("%s: expected libbaz.so in libFlags to be %t, got %q", tc.variant, w, libFlags)
+		}
+	}
+}
+
+func TestPrivateHeaderLibs(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_headers {
+			name: "libfoo_impl_headers",
+			export_include_dirs: ["impl_include"],
+		}
+
+		cc_library_headers {
+			name: "libfoo_api_headers",
+			export_include_dirs: ["api_include"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_libs: ["libfoo_api_headers"],
+			export_header_lib_headers: ["libfoo_api_headers"],
+			private_header_libs: ["libfoo_impl_headers"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	// the static variants are used since the shared variants reuse their objects
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static").Rule("cc").Args["cFlags"]
+	for _, include := range []string{"-Iimpl_include", "-Iapi_include"} {
+		if !strings.Contains(libfoo, include) {
+			t.Errorf("expected %q in the cflags of libfoo, got %q", include, libfoo)
+		}
+	}
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_static").Rule("cc").Args["cFlags"]
+	if !strings.Contains(libbar, "-Iapi_include") {
+		t.Errorf("expected %q in the cflags of libbar, got %q", "-Iapi_include", libbar)
+	}
+	if strings.Contains(libbar, "-Iimpl_include") {
+		t.Errorf("expected no %q in the cflags of libbar, got %q", "-Iimpl_include", libbar)
+	}
+
+	testCcError(t, `Header library in private_header_libs can't be exported: 'libfoo_impl_headers'`, `
+		cc_library_headers {
+			name: "libfoo_impl_headers",
+		}
+
+		cc_library {
+			name: "libfoo",
+			private_header_libs: ["libfoo_impl_headers"],
+			export_header_lib_headers: ["libfoo_impl_headers"],
+		}`)
+}
+
 func TestRuntimeLibsNoVndk(t *testing.T) {
 	ctx := testCcNoVndk(t, runtimeLibAndroidBp)
 
@@ -2165,3 +2358,2002 @@ func TestStaticDepsOrderWithStubs(t *testing.T) {
 		)
 	}
 }
+
+func TestTidyDisabledSrcs(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.c"],
+			tidy: true,
+			tidy_disabled_srcs: ["b*.c"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static")
+
+	var tidyFiles []string
+	for _, o := range libfoo.AllOutputs() {
+		if strings.HasSuffix(o, ".tidy") {
+			tidyFiles = append(tidyFiles, filepath.Base(o))
+		}
+	}
+	if !reflect.DeepEqual(tidyFiles, []string{"foo.tidy"}) {
+		t.Errorf("expected tidy to run only on foo.c, got tidy outputs %q", tidyFiles)
+	}
+
+	for _, implicit := range libfoo.Output("libfoo.a").Implicits.Strings() {
+		if strings.HasSuffix(implicit, "bar.tidy") {
+			t.Errorf("libfoo.a should not depend on %q", implicit)
+		}
+	}
+}
+
+func TestTidyDisabledGeneratedSrcs(t *testing.T) {
+	ctx := testCcWithFs(t, `
+		genrule {
+			name: "gen_source",
+			cmd: "touch $(out)",
+			out: ["gen.c"],
+		}
+
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generated_sources: ["gen_source"],
+			tidy: true,
+			tidy_disabled_srcs: [":gen_source"],
+		}`, nil)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static")
+
+	var tidyFiles []string
+	for _, o := range libfoo.AllOutputs() {
+		if strings.HasSuffix(o, ".tidy") {
+			tidyFiles = append(tidyFiles, filepath.Base(o))
+		}
+	}
+	if !reflect.DeepEqual(tidyFiles, []string{"foo.tidy"}) {
+		t.Errorf("expected tidy to run only on foo.c, got tidy outputs %q", tidyFiles)
+	}
+}
+
+func TestNdkApiLevel(t *testing.T) {
+	ctx := testCc(t, `
+		ndk_library {
+			name: "libz",
+			symbol_file: "foo.map.txt",
+			first_version: "9",
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sdk_version: "current",
+			ndk_api_level: "23",
+			shared_libs: ["libz"],
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module()
+
+	var ndkApiLevels []string
+	ctx.VisitDirectDeps(libfoo, func(dep blueprint.Module) {
+		if m, ok := dep.(*Module); ok {
+			if stub, ok := m.compiler.(*stubDecorator); ok {
+				ndkApiLevels = append(ndkApiLevels, stub.properties.ApiLevel)
+			}
+		}
+	})
+
+	if !reflect.DeepEqual(ndkApiLevels, []string{"23"}) {
+		t.Errorf("expected libfoo to link against the API level 23 stub of libz, got %q", ndkApiLevels)
+	}
+}
+
+func TestNdkApiLevelNewerThanSdkVersion(t *testing.T) {
+	testCcError(t, `ndk_api_level: "28" is newer than sdk_version "23"`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sdk_version: "23",
+			ndk_api_level: "28",
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}`)
+}
+
+func TestToolchainIncludesInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			include_dirs: ["my_include"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static").Module().(*Module)
+	systemIncludeFlags := libfoo.ToolchainIncludesInfo().SystemIncludeFlags
+
+	if !inList("${config.CommonGlobalIncludes}", systemIncludeFlags) {
+		t.Errorf("expected toolchain includes to contain %q, got %q",
+			"${config.CommonGlobalIncludes}", systemIncludeFlags)
+	}
+	for _, flag := range systemIncludeFlags {
+		if strings.Contains(flag, "my_include") {
+			t.Errorf("module include dir leaked into toolchain includes: %q", flag)
+		}
+	}
+}
+
+func TestQuarantineBuild(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			include_dirs: ["my_include"],
+			quarantine_build: true,
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["foo.c"],
+			include_dirs: ["my_include"],
+		}`)
+
+	// libfoo relies on headers from the global include dirs without declaring them, so it must
+	// not see them in quarantine.
+	libfooCflags := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static").Rule("cc").Args["cFlags"]
+	if !strings.Contains(libfooCflags, "-Imy_include") {
+		t.Errorf("expected declared include dir in libfoo cflags, got %q", libfooCflags)
+	}
+	for _, undeclared := range []string{"${config.CommonGlobalIncludes}", "${config.CommonNativehelperInclude}"} {
+		if strings.Contains(libfooCflags, undeclared) {
+			t.Errorf("expected %q not to be in libfoo cflags in quarantine, got %q", undeclared, libfooCflags)
+		}
+	}
+
+	libbarCflags := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_static").Rule("cc").Args["cFlags"]
+	if !strings.Contains(libbarCflags, "${config.CommonGlobalIncludes}") {
+		t.Errorf("expected global include dirs in libbar cflags, got %q", libbarCflags)
+	}
+}
+
+func TestPerArchSdkVersion(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sdk_version: "21",
+			arch: {
+				arm64: {
+					sdk_version: "26",
+				},
+			},
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}`)
+
+	for _, tc := range []struct {
+		variant string
+		want    string
+	}{
+		{"android_arm64_armv8-a_core_shared", "26"},
+		{"android_arm_armv7-a-neon_core_shared", "21"},
+	} {
+		libfoo := ctx.ModuleForTests("libfoo", tc.variant).Module().(*Module)
+		if got := String(libfoo.Properties.Sdk_version); got != tc.want {
+			t.Errorf("%s: expected sdk_version %q, got %q", tc.variant, tc.want, got)
+		}
+	}
+}
+
+func TestAllowNdkLinkTo(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libndk",
+			srcs: ["foo.c"],
+			sdk_version: "current",
+			shared_libs: ["libplatform"],
+			%s
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}
+
+		cc_library_shared {
+			name: "libplatform",
+			srcs: ["foo.c"],
+			system_shared_libs: [],
+			stl: "none",
+		}`
+
+	testCcError(t, `depends on non-NDK-built library "libplatform"`, fmt.Sprintf(bp, ""))
+	testCc(t, fmt.Sprintf(bp, `allow_ndk_link_to: ["libplatform"],`))
+}
+
+func TestAllowedNdkLinkTargets(t *testing.T) {
+	m := &Module{}
+	m.Properties.Allow_ndk_link_to = []string{"libfoo"}
+
+	if got, want := allowedNdkLinkTargets("libbar", m), []string{"libfoo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got, want := allowedNdkLinkTargets("libclang_rt.asan-aarch64-android", m), []string{"libfoo", "libc++"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestFpPolicy(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_static {
+			name: "libprecise",
+			srcs: ["foo.c"],
+			fp_policy: "precise",
+		}
+
+		cc_library_static {
+			name: "libfast",
+			srcs: ["foo.c"],
+			fp_policy: "fast",
+		}
+
+		cc_library_static {
+			name: "libcontract",
+			srcs: ["foo.c"],
+			fp_policy: "contract-on",
+		}
+
+		cc_library_static {
+			name: "libdefault",
+			srcs: ["foo.c"],
+		}`)
+
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		expected []string
+	}{
+		{"libprecise", "precise", []string{"-fno-fast-math", "-ffp-contract=off"}},
+		{"libfast", "fast", []string{"-ffast-math"}},
+		{"libcontract", "contract-on", []string{"-ffp-contract=on"}},
+		{"libdefault", "", nil},
+	} {
+		module := ctx.ModuleForTests(tc.name, "android_arm64_armv8-a_core_static").Module().(*Module)
+
+		var fpFlags []string
+		for _, flag := range module.flags.CFlags {
+			if strings.HasPrefix(flag, "-ffp-") || strings.HasSuffix(flag, "fast-math") {
+				fpFlags = append(fpFlags, flag)
+			}
+		}
+		if !reflect.DeepEqual(fpFlags, tc.expected) {
+			t.Errorf("%s: expected floating point flags %q, got %q", tc.name, tc.expected, fpFlags)
+		}
+		if got := module.FpPolicyInfo().Policy; got != tc.policy {
+			t.Errorf("%s: expected fp policy %q, got %q", tc.name, tc.policy, got)
+		}
+	}
+
+	bp := `
+		cc_library_static {
+			name: "libparser",
+			srcs: ["foo.c"],
+			fp_policy: "fast",
+			sanitize: {
+				integer_overflow: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libfast",
+			srcs: ["foo.c"],
+			fp_policy: "fast",
+		}`
+	config := android.TestArchConfig(buildDir, nil)
+	testCcWithConfig(t, bp, config)
+
+	var got []string
+	getNamedMapForConfig(config, modulesUsingFastFpPolicyOnUntrustedInputKey).Range(func(key, value interface{}) bool {
+		got = append(got, key.(string))
+		return true
+	})
+	if w := []string{"./Android.bp:libparser"}; !reflect.DeepEqual(got, w) {
+		t.Errorf("expected %q to be reported, got %q", w, got)
+	}
+}
+
+func TestLanguageStandardInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			c_std: "gnu11",
+			cppflags: ["-std=c++14"],
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static").Module().(*Module)
+	if got, want := libfoo.LanguageStandardInfo(), (LanguageStandardInfo{CStd: "gnu11", CppStd: "c++14"}); got != want {
+		t.Errorf("expected %#v, got %#v", want, got)
+	}
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_static").Module().(*Module)
+	if got, want := libbar.LanguageStandardInfo(), (LanguageStandardInfo{CStd: config.CStdVersion, CppStd: config.CppStdVersion}); got != want {
+		t.Errorf("expected %#v, got %#v", want, got)
+	}
+}
+
+func TestWeakNdkApis(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sdk_version: "current",
+			weak_ndk_apis: true,
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			weak_ndk_apis: true,
+			system_shared_libs: [],
+			stl: "none",
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if !inList("-D__ANDROID_UNAVAILABLE_SYMBOLS_ARE_WEAK__", libfoo.flags.CFlags) {
+		t.Errorf("expected weak NDK API flags in libfoo cflags, got %q", libfoo.flags.CFlags)
+	}
+
+	// weak_ndk_apis has no effect when not compiling against the NDK.
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if inList("-D__ANDROID_UNAVAILABLE_SYMBOLS_ARE_WEAK__", libbar.flags.CFlags) {
+		t.Errorf("unexpected weak NDK API flags in libbar cflags %q", libbar.flags.CFlags)
+	}
+}
+
+func TestObjectSectionAlignment(t *testing.T) {
+	ctx := testCc(t, `
+		cc_object {
+			name: "obj",
+			srcs: ["foo.c"],
+			section_alignment: 4096,
+			system_shared_libs: [],
+			stl: "none",
+		}`)
+
+	obj := ctx.ModuleForTests("obj", "android_arm64_armv8-a_core")
+	linkerScript := obj.Output("section_alignment.ld")
+	if g, w := linkerScript.Args["lines"], "'  .text : ALIGN(4096) { *(.text .text.*) }'"; !strings.Contains(g, w) {
+		t.Errorf("expected the linker script to contain %q, got %q", w, g)
+	}
+
+	align := obj.Output("aligned/obj.o")
+	if g, w := align.Input.String(), obj.Rule("cc").Output.String(); g != w {
+		t.Errorf("expected the compiled object %q to be aligned, got %q", w, g)
+	}
+	if g, w := align.Implicit.String(), linkerScript.Output.String(); g != w {
+		t.Errorf("expected the linker script %q as an implicit input, got %q", w, g)
+	}
+	if g, w := align.Args["ldFlags"], "-Wl,-T,"+linkerScript.Output.String(); !strings.Contains(g, w) {
+		t.Errorf("expected the ldflags to contain %q, got %q", w, g)
+	}
+
+	testCcError(t, `section_alignment: must be a power of two, got 1000`, `
+		cc_object {
+			name: "obj",
+			srcs: ["foo.c"],
+			section_alignment: 1000,
+			system_shared_libs: [],
+			stl: "none",
+		}`)
+}
+
+func TestUnusedSharedLibs(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			shared_libs: ["libbar"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}`
+
+	config := android.TestArchConfig(buildDir, map[string]string{"WARN_UNUSED_SHARED_LIBS": "true"})
+	ctx := testCcWithConfig(t, bp, config)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	report := libfoo.Output("unused_shared_libs.txt")
+	libbarToc := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared").Rule("toc").Output
+	if g, w := report.Args["tocs"], libbarToc.String(); g != w {
+		t.Errorf("expected tocs %q, got %q", w, g)
+	}
+	if !inList(libfoo.Output("obj/foo.o").Output.String(), report.Inputs.Strings()) {
+		t.Errorf("expected the objects of libfoo in the inputs, got %q", report.Inputs.Strings())
+	}
+	if g, w := report.ImplicitOutput.String(), report.Output.String()+".undefined"; g != w {
+		t.Errorf("expected the undefined symbols to be written to %q, got %q", w, g)
+	}
+
+	// The report is only generated when requested.
+	ctx = testCc(t, bp)
+	libfoo = ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	if libfoo.MaybeOutput("unused_shared_libs.txt").Rule != nil {
+		t.Errorf("unexpected unused shared libs report")
+	}
+}
+
+func TestAidlResolvedInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["b.aidl"],
+			aidl: {
+				local_include_dirs: ["my_include"],
+				include_dirs: ["my_include"],
+				generate_traces: true,
+			},
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	info := libfoo.AidlResolvedInfo()
+	if g, w := info.IncludeDirs.Strings(), []string{"my_include", "my_include"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected aidl include dirs %q, got %q", w, g)
+	}
+	if g, w := info.Flags, []string{"-Imy_include", "-Imy_include", "-t"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected aidl flags %q, got %q", w, g)
+	}
+}
+
+func TestWarningBaseline(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.c"],
+			warning_baseline: "warnings.txt",
+		}`
+
+	ctx := testCcWithFs(t, bp, map[string][]byte{
+		"warnings.txt": []byte("foo.c: warning: unused variable 'x' [-Wunused-variable]\n"),
+	})
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	if !inList("-Wno-error", libfoo.Module().(*Module).flags.CFlags) {
+		t.Errorf("expected warnings not to be errors with a baseline, got cflags %q",
+			libfoo.Module().(*Module).flags.CFlags)
+	}
+
+	fooWarnings := libfoo.Output("obj/foo.warnings")
+	barWarnings := libfoo.Output("obj/bar.warnings")
+
+	// Warnings listed in the baseline are allowed, any other one fails the check.
+	check := libfoo.Rule("warningBaselineCheck")
+	if g, w := check.Inputs.Strings(), []string{fooWarnings.Output.String(), barWarnings.Output.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected the warnings of %q to be checked, got %q", w, g)
+	}
+	if g, w := check.Args["baseline"], "warnings.txt"; g != w {
+		t.Errorf("expected baseline %q, got %q", w, g)
+	}
+
+	dir, err := ioutil.TempDir("", "warning_baseline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0777); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The warnings are listed without their line and column, and a failing compile fails the rule.
+	fakeClang := writeFile("clang", "#!/bin/sh\n"+
+		"echo \"foo.c:3:7: warning: unused variable 'x' [-Wunused-variable]\" >&2\n"+
+		"echo \"foo.c:5:1: warning: unused variable 'y' [-Wunused-variable]\" >&2\n"+
+		"echo \"foo.c:3:7: note: declared here\" >&2\n"+
+		"exit $FAKE_CLANG_EXIT\n")
+	runWarnings := func(exit string) error {
+		return runRuleCommand(fooWarnings.RuleParams.Command, map[string]string{
+			"relPwd": "FAKE_CLANG_EXIT=" + exit,
+			"ccCmd":  fakeClang,
+			"cFlags": "",
+			"in":     "foo.c",
+			"out":    filepath.Join(dir, "foo.warnings"),
+		})
+	}
+	if err := runWarnings("1"); err == nil {
+		t.Errorf("expected the warnings rule to fail when the compile fails")
+	}
+	if err := runWarnings("0"); err != nil {
+		t.Fatalf("expected the warnings rule to pass, got %s", err)
+	}
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "foo.warnings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := string(dat), "foo.c: warning: unused variable 'x' [-Wunused-variable]\n"+
+		"foo.c: warning: unused variable 'y' [-Wunused-variable]\n"; g != w {
+		t.Errorf("expected the warnings %q, got %q", w, g)
+	}
+
+	runCheck := func(baseline string) error {
+		return runRuleCommand(check.RuleParams.Command, map[string]string{
+			"in":       filepath.Join(dir, "foo.warnings"),
+			"baseline": writeFile("warnings.txt", baseline),
+			"out":      filepath.Join(dir, "warnings.check"),
+		})
+	}
+	if err := runCheck("foo.c: warning: unused variable 'x' [-Wunused-variable]\n"); err == nil {
+		t.Errorf("expected the check to fail on a warning missing from the baseline")
+	}
+	if err := runCheck("foo.c: warning: unused variable 'x' [-Wunused-variable]\n" +
+		"foo.c: warning: unused variable 'y' [-Wunused-variable]\n"); err != nil {
+		t.Errorf("expected the check to pass with all the warnings in the baseline, got %s", err)
+	}
+
+	// The module is only linked once the check passed.
+	ld := libfoo.Rule("ld")
+	if !inList(check.Output.String(), ld.Implicits.Strings()) {
+		t.Errorf("expected the link to depend on %q, got %q", check.Output, ld.Implicits.Strings())
+	}
+}
+
+func TestBinaryRpaths(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cc_binary_host tests fail on mac when trying to exec xcrun")
+	}
+	ctx := testCc(t, `
+		cc_binary_host {
+			name: "mytool",
+			srcs: ["foo.c"],
+			stl: "none",
+			rpaths: ["$ORIGIN/../lib/mytool", "lib/extra"],
+		}`)
+
+	buildOS := android.BuildOs.String()
+	mytool := ctx.ModuleForTests("mytool", buildOS+"_x86_64").Module().(*Module)
+	ldflags := strings.Join(mytool.flags.LdFlags, " ")
+	expected := `-Wl,-rpath,\$$ORIGIN/lib64 -Wl,-rpath,'$$ORIGIN/../lib/mytool' -Wl,-rpath,lib/extra`
+	if !strings.Contains(ldflags, expected) {
+		t.Errorf("expected the rpaths %q after the default ones, got %q", expected, ldflags)
+	}
+
+	testCcError(t, `rpaths: "/vendor/lib" must not be an absolute path for device binaries`, `
+		cc_binary {
+			name: "mybin",
+			srcs: ["foo.c"],
+			rpaths: ["/vendor/lib"],
+		}`)
+}
+
+func TestIcf(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			icf: "safe",
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	if ldFlags := libfoo.Rule("ld").Args["ldFlags"]; !strings.Contains(ldFlags, "-Wl,--icf=safe") {
+		t.Errorf("expected -Wl,--icf=safe in ldflags, got %q", ldFlags)
+	}
+	module := libfoo.Module().(*Module)
+	if !inList("-faddrsig", module.flags.CFlags) {
+		t.Errorf("expected -faddrsig in cflags, got %q", module.flags.CFlags)
+	}
+	if info, expected := module.OptimizationInfo(), (OptimizationInfo{Icf: "safe", Addrsig: true, LtoMode: "none"}); info != expected {
+		t.Errorf("expected OptimizationInfo %+v, got %+v", expected, info)
+	}
+
+	testCcError(t, `icf: identical code folding requires use_clang_lld`, `
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			icf: "all",
+			use_clang_lld: false,
+		}`)
+}
+
+func TestSystemHeaderLib(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_headers {
+			name: "libthirdparty_headers",
+			export_include_dirs: ["thirdparty/include"],
+			system_header_lib: true,
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_libs: ["libthirdparty_headers"],
+		}`)
+
+	cFlags := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Rule("cc").Args["cFlags"]
+	if !strings.Contains(cFlags, "-isystem thirdparty/include") {
+		t.Errorf("expected -isystem for the header lib's dirs in cflags, got %q", cFlags)
+	}
+	if strings.Contains(cFlags, "-Ithirdparty/include") {
+		t.Errorf("expected no -I for the header lib's dirs in cflags, got %q", cFlags)
+	}
+
+	testCcError(t, `system_header_lib: is only supported on cc_library_headers`, `
+		cc_library_static {
+			name: "libbar",
+			srcs: ["foo.c"],
+			system_header_lib: true,
+		}`)
+}
+
+func TestCcDepGraph(t *testing.T) {
+	bp := `
+		cc_library_headers {
+			name: "libfoo_headers",
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_static {
+			name: "libbaz",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_libs: ["libfoo_headers"],
+			static_libs: ["libbar"],
+			whole_static_libs: ["libbaz"],
+		}
+
+		cc_library_shared {
+			name: "libvendor",
+			srcs: ["foo.c"],
+			vendor_available: true,
+		}
+
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			shared_libs: ["libfoo"],
+		}
+
+		cc_binary {
+			name: "vendor_bin",
+			srcs: ["foo.c"],
+			vendor: true,
+			shared_libs: ["libvendor"],
+		}`
+	config := android.TestArchConfig(buildDir, map[string]string{
+		"SOONG_GEN_CC_DEP_GRAPH": "true",
+	})
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("VER")
+	testCcWithConfig(t, bp, config)
+
+	dat, err := ioutil.ReadFile(filepath.Join(buildDir, "cc_dep_graph.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(dat)
+	for _, edge := range []string{
+		`"foo" -> "libfoo" [style=solid];`,
+		`"libfoo" -> "libbar" [style=dashed];`,
+		`"libfoo" -> "libbaz" [style=bold];`,
+		`"libfoo" -> "libfoo_headers" [style=dotted];`,
+		`"vendor_bin" -> "libvendor" [style=solid];`,
+	} {
+		if !strings.Contains(content, edge) {
+			t.Errorf("expected edge %q in the dependency graph, got %q", edge, content)
+		}
+	}
+	// The nodes are module names, not the names of the variants in Make.
+	if strings.Contains(content, "libvendor.vendor") {
+		t.Errorf("unexpected Make name of libvendor in the dependency graph, got %q", content)
+	}
+}
+
+func TestLinkerFlagsInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			ldflags: ["-Wl,--gc-sections", "-static-libgcc", "-Xlinker", "--no-undefined-version"],
+		}`)
+
+	info := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module).LinkerFlagsInfo()
+	for _, flag := range []string{"-Wl,--gc-sections", "-Xlinker", "--no-undefined-version"} {
+		if !inList(flag, info.LinkerFlags) {
+			t.Errorf("expected %q in the linker flags, got %q", flag, info.LinkerFlags)
+		}
+		if inList(flag, info.DriverFlags) {
+			t.Errorf("expected %q not to be in the driver flags, got %q", flag, info.DriverFlags)
+		}
+	}
+	if !inList("-static-libgcc", info.DriverFlags) {
+		t.Errorf("expected -static-libgcc in the driver flags, got %q", info.DriverFlags)
+	}
+	if inList("-static-libgcc", info.LinkerFlags) {
+		t.Errorf("expected -static-libgcc not to be in the linker flags, got %q", info.LinkerFlags)
+	}
+}
+
+func TestNoRtti(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["rtti.cpp"],
+			no_rtti: true,
+		}`
+
+	rttiSrc := []byte(`
+		struct Base { virtual ~Base() {} };
+		struct Derived : Base {};
+		bool isDerived(Base* b) { return dynamic_cast<Derived*>(b) != nullptr; }
+	`)
+	ctx := testCcWithFs(t, bp, map[string][]byte{
+		"rtti.cpp": rttiSrc,
+	})
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	cppFlags := libfoo.Module().(*Module).flags.CppFlags
+	if !inList("-fno-rtti", cppFlags) || inList("-frtti", cppFlags) {
+		t.Errorf("expected -fno-rtti and no -frtti in cppflags, got %q", cppFlags)
+	}
+
+	// The objects of the module are checked for RTTI symbols before it is linked.
+	obj := libfoo.Output("obj/rtti.o")
+	check := libfoo.Rule("noRttiCheck")
+	if g, w := check.Inputs.Strings(), []string{obj.Output.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected the objects %q to be checked, got %q", w, g)
+	}
+	if !strings.Contains(check.RuleParams.Command, "__dynamic_cast$$") {
+		t.Errorf("expected the check to look for __dynamic_cast, got %q", check.RuleParams.Command)
+	}
+
+	// The module is only linked once the check passed.
+	ld := libfoo.Rule("ld")
+	if !inList(check.Output.String(), ld.Implicits.Strings()) {
+		t.Errorf("expected the link to depend on %q, got %q", check.Output, ld.Implicits.Strings())
+	}
+
+	testCcError(t, `no_rtti: can't be set with rtti: true`, `
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			rtti: true,
+			no_rtti: true,
+		}`)
+
+	// Run the check on objects built by the host compiler, which has RTTI enabled like the
+	// objects of a prebuilt library built without no_rtti would.
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("no host C++ compiler to build objects for the check")
+	}
+	dir, err := ioutil.TempDir("", "no_rtti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runCheckOn := func(objFile string) error {
+		return runRuleCommand(check.RuleParams.Command, map[string]string{
+			"crossCompile": "",
+			"in":           objFile,
+			"out":          filepath.Join(dir, "check"),
+		})
+	}
+	runCheck := func(src string) error {
+		srcFile := filepath.Join(dir, "src.cpp")
+		if err := ioutil.WriteFile(srcFile, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		objFile := filepath.Join(dir, "src.o")
+		if out, err := exec.Command(cxx, "-c", srcFile, "-o", objFile).CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile %q: %s", src, out)
+		}
+		return runCheckOn(objFile)
+	}
+
+	if err := runCheck(string(rttiSrc)); err == nil {
+		t.Errorf("expected the check to fail on an object using dynamic_cast")
+	}
+	if err := runCheck("int foo() { return 1; }"); err != nil {
+		t.Errorf("expected the check to pass on an object without RTTI, got %s", err)
+	}
+
+	// An object nm can't read fails the check instead of passing it.
+	notAnObject := filepath.Join(dir, "not_an_object.o")
+	if err := ioutil.WriteFile(notAnObject, []byte("not an object"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCheckOn(notAnObject); err == nil {
+		t.Errorf("expected the check to fail when nm fails")
+	}
+}
+
+func TestImplMappingInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libFoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "foo.map.txt",
+				versions: ["1", "2"],
+			},
+		}`)
+
+	stubs := ctx.ModuleForTests("libFoo", "android_arm64_armv8-a_core_shared_2").Module().(*Module)
+	info, ok := stubs.ImplMappingInfo()
+	if !ok {
+		t.Fatalf("expected the stubs variant of libFoo to be mapped to its implementation")
+	}
+	expected := ImplMappingInfo{
+		ImplementationModuleName:        "libFoo",
+		ImplementationModuleNameForMake: "libFoo",
+		StubsVersion:                    "2",
+		OutputFile:                      stubs.OutputFile().Path(),
+	}
+	if info != expected {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+
+	impl := ctx.ModuleForTests("libFoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if _, ok := impl.ImplMappingInfo(); ok {
+		t.Errorf("expected no mapping for the implementation variant of libFoo")
+	}
+}
+
+func TestStrippedMappingInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+		}`)
+
+	shared := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	info, ok := shared.StrippedMappingInfo()
+	if !ok {
+		t.Fatalf("expected the shared variant of libfoo to have a stripped mapping")
+	}
+	if info.Stripped != shared.OutputFile().Path() {
+		t.Errorf("expected stripped file %q, got %q", shared.OutputFile().Path(), info.Stripped)
+	}
+	if info.Unstripped != shared.UnstrippedOutputFile() {
+		t.Errorf("expected unstripped file %q, got %q", shared.UnstrippedOutputFile(), info.Unstripped)
+	}
+	if !info.Installed.Valid() || !strings.HasSuffix(info.Installed.String(), "system/lib64/libfoo.so") {
+		t.Errorf("expected installed file system/lib64/libfoo.so, got %q", info.Installed)
+	}
+
+	static := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static").Module().(*Module)
+	if _, ok := static.StrippedMappingInfo(); ok {
+		t.Errorf("expected no stripped mapping for the static variant of libfoo")
+	}
+}
+
+func TestIncompatibleSanitizers(t *testing.T) {
+	testCcError(t, `sanitize: incompatible sanitizers enabled: address and hwaddress`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				hwaddress: true,
+			},
+		}`)
+
+	testCcError(t, `sanitize: incompatible sanitizers enabled: cfi and hwaddress`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				cfi: true,
+				hwaddress: true,
+			},
+		}`)
+}
+
+func TestSanitizerVariantsInfo(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				cfi: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`
+
+	config := android.TestArchConfig(buildDir, nil)
+	ctx := createTestContext(t, config, bp, nil, android.Android)
+	ctx.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.TopDown("cfi_deps", sanitizerDepsMutator(cfi))
+		ctx.BottomUp("cfi", sanitizerMutator(cfi)).Parallel()
+	})
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	for _, variant := range []string{"android_arm64_armv8-a_core_static", "android_arm64_armv8-a_core_static_cfi"} {
+		info := ctx.ModuleForTests("libfoo", variant).Module().(*Module).SanitizerVariantsInfo()
+		if !reflect.DeepEqual(info.Variations, []string{"cfi"}) {
+			t.Errorf("%s: expected sanitizer variations [cfi], got %q", variant, info.Variations)
+		}
+	}
+
+	info := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_static").Module().(*Module).SanitizerVariantsInfo()
+	if len(info.Variations) != 0 {
+		t.Errorf("expected no sanitizer variations for libbar, got %q", info.Variations)
+	}
+}
+
+func TestCcWhyDep(t *testing.T) {
+	// The vendor variants name their dependencies with a ".vendor" suffix, which must not break the
+	// path through the dependencies.
+	bp := `
+		cc_library_static {
+			name: "libbaz",
+			vendor_available: true,
+			srcs: ["foo.c"],
+		}
+
+		cc_library_static {
+			name: "libbar",
+			vendor_available: true,
+			srcs: ["foo.c"],
+			whole_static_libs: ["libbaz"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			vendor_available: true,
+			srcs: ["foo.c"],
+			static_libs: ["libbar"],
+		}
+
+		cc_binary {
+			name: "foo",
+			vendor: true,
+			srcs: ["foo.c"],
+			shared_libs: ["libfoo"],
+			static_libs: ["libbar"],
+		}`
+	config := android.TestArchConfig(buildDir, map[string]string{
+		"SOONG_CC_WHYDEP": "foo:libbaz",
+	})
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("VER")
+	ctx := testCcWithConfig(t, bp, config)
+
+	lines := ctx.SingletonForTests("cc_whydep").Output("cc_whydep.txt").Args["lines"]
+	expected := `foo '    -> [static] libbar' '    -> [whole_static] libbaz'`
+	if lines != expected {
+		t.Errorf("expected dependency path %q, got %q", expected, lines)
+	}
+}
+
+func TestExportIncludeDirsAllowlist(t *testing.T) {
+	testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			export_include_dirs_allowlist: ["include"],
+		}`)
+
+	testCcError(t, `export_include_dirs: "internal" is not listed in export_include_dirs_allowlist`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include", "internal"],
+			export_include_dirs_allowlist: ["include"],
+		}`)
+
+	// The allowlist can be set per arch, and a directory listed twice is only reported once.
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["internal"],
+			export_include_dirs_allowlist: ["include"],
+			arch: {
+				arm64: {
+					export_include_dirs: ["internal/"],
+				},
+				arm: {
+					export_include_dirs_allowlist: ["internal"],
+				},
+			},
+		}`
+	config := android.TestArchConfig(buildDir, nil)
+	ctx := createTestContext(t, config, bp, nil, android.Android)
+	ctx.Register()
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	count := 0
+	for _, err := range errs {
+		if strings.Contains(err.Error(), `"internal" is not listed in export_include_dirs_allowlist`) {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected the arm64 variant to report internal once and the arm variant to allow it, got %q", errs)
+	}
+}
+
+func TestExcludeSrcsHasSrcExt(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c", "b.aidl"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c", "aidl/*.aidl"],
+			exclude_srcs: ["aidl/b.aidl"],
+		}
+
+		cc_library_shared {
+			name: "libbaz",
+			vendor_available: true,
+			srcs: ["foo.c", "b.aidl"],
+			target: {
+				vendor: {
+					exclude_srcs: ["b.aidl"],
+				},
+			},
+		}`
+	config := android.TestArchConfig(buildDir, nil)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("VER")
+	ctx := testCcWithConfigAndFs(t, bp, config, map[string][]byte{
+		"aidl/b.aidl": nil,
+	})
+
+	hasAidlInclude := func(name, variant string) bool {
+		cFlags := ctx.ModuleForTests(name, variant).Rule("cc").Args["cFlags"]
+		return strings.Contains(cFlags, "/gen/aidl")
+	}
+
+	if !hasAidlInclude("libfoo", "android_arm64_armv8-a_core_shared") {
+		t.Errorf("expected the aidl include dir in the cflags of libfoo")
+	}
+	if hasAidlInclude("libbar", "android_arm64_armv8-a_core_shared") {
+		t.Errorf("expected no aidl include dir in the cflags of libbar when the globbed aidl files are excluded")
+	}
+	if !hasAidlInclude("libbaz", "android_arm64_armv8-a_core_shared") {
+		t.Errorf("expected the aidl include dir in the cflags of the core variant of libbaz")
+	}
+	if hasAidlInclude("libbaz", "android_arm64_armv8-a_vendor_shared") {
+		t.Errorf("expected no aidl include dir in the cflags of the vendor variant of libbaz when the aidl files are excluded")
+	}
+}
+
+func TestDualLanguageHeaders(t *testing.T) {
+	bp := `
+		cc_library_headers {
+			name: "libfoo_headers",
+			export_include_dirs: ["include"],
+			dual_language_headers: true,
+		}`
+	// Uses the C++ keyword "class" as an identifier, so it only compiles as C.
+	header := []byte("struct foo { int class; };\n")
+	ctx := testCcWithFs(t, bp, map[string][]byte{
+		"include/foo.h": header,
+	})
+
+	dir, err := ioutil.TempDir("", "dual_language_headers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	headerFile := filepath.Join(dir, "foo.h")
+	if err := ioutil.WriteFile(headerFile, header, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	module := ctx.ModuleForTests("libfoo_headers", "android_arm64_armv8-a_core")
+	for _, tc := range []struct {
+		lang    string
+		hostCmd string
+		pass    bool
+	}{
+		{"c-header", "cc", true},
+		{"c++-header", "c++", false},
+	} {
+		check := module.Output("dual_language_headers/include/foo." + tc.lang + ".check")
+		if check.Args["lang"] != tc.lang {
+			t.Errorf("expected foo.h to be compiled as %s, got %q", tc.lang, check.Args["lang"])
+		}
+		if !strings.Contains(check.Args["cFlags"], "-Iinclude") {
+			t.Errorf("expected the exported include dir in cflags, got %q", check.Args["cFlags"])
+		}
+		if !inList(check.Output.String(), module.Rule("ar").Implicits.Strings()) {
+			t.Errorf("expected the library to depend on the %s check of foo.h", tc.lang)
+		}
+
+		// Compile the header with the host compiler instead of the target flags of the module.
+		hostCmd, err := exec.LookPath(tc.hostCmd)
+		if err != nil {
+			t.Logf("not compiling foo.h as %s: %s", tc.lang, err)
+			continue
+		}
+		err = runRuleCommand(check.RuleParams.Command, map[string]string{
+			"ccCmd":  hostCmd,
+			"lang":   tc.lang,
+			"cFlags": "-I" + dir,
+			"in":     headerFile,
+			"out":    filepath.Join(dir, "foo."+tc.lang+".check"),
+		})
+		if pass := err == nil; pass != tc.pass {
+			t.Errorf("expected the %s check of foo.h to pass: %t, got error %v", tc.lang, tc.pass, err)
+		}
+	}
+}
+
+func TestSoname(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			soname: "libthirdparty.so",
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Output("libfoo.so")
+	if !strings.Contains(libfoo.Args["ldFlags"], "-Wl,-soname,libthirdparty.so") {
+		t.Errorf("expected -Wl,-soname,libthirdparty.so in ldflags, got %q", libfoo.Args["ldFlags"])
+	}
+
+	testCcError(t, `soname: "libthirdparty.so.1" must end in .so`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			soname: "libthirdparty.so.1",
+		}`)
+}
+
+func TestInputsManifest(t *testing.T) {
+	bp := `
+		genrule {
+			name: "gen_header",
+			cmd: "touch $(out)",
+			out: ["gen.h"],
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generated_headers: ["gen_header"],
+			static_libs: ["libbar"],
+			inputs_manifest: true,
+		}`
+	ctx := testCcWithConfig(t, bp, android.TestArchConfig(buildDir, nil))
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	manifest := libfoo.Output("inputs_manifest.txt")
+	if manifest.Output.String() != libfoo.Module().(*Module).InputsManifest().String() {
+		t.Errorf("expected InputsManifest to return %q", manifest.Output)
+	}
+	lines := strings.Split(manifest.Args["lines"], " ")
+	for _, input := range []string{"foo.c", "/gen_header/gen/gen.h", "/libbar.a", "${config.ClangBin}/clang"} {
+		found := false
+		for _, line := range lines {
+			if strings.HasSuffix(line, input) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected %q in the inputs manifest, got %q", input, lines)
+		}
+	}
+
+	// The headers found through the include dirs are listed from the depfile of foo.c.
+	inputDeps := libfoo.Output("obj/foo.deps")
+	if inputDeps.Rule != ccInputDeps {
+		t.Errorf("expected the input deps of foo.c to be listed by the compiler, got rule %q", inputDeps.Rule)
+	}
+	if g, w := manifest.Inputs.Strings(), []string{inputDeps.Output.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected the inputs manifest to read %q, got %q", w, g)
+	}
+
+	dir, err := ioutil.TempDir("", "inputs_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	depFile := filepath.Join(dir, "foo.deps")
+	out := filepath.Join(dir, "inputs_manifest.txt")
+	if err := ioutil.WriteFile(depFile, []byte("foo.o: foo.c include/foo.h \\\n  include/bar.h\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRuleCommand(manifest.RuleParams.Command, map[string]string{
+		"lines": "foo.c libbar.a",
+		"in":    depFile,
+		"out":   out,
+	}); err != nil {
+		t.Fatalf("failed to run the inputs manifest command: %s", err)
+	}
+	dat, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := string(dat), "foo.c\ninclude/bar.h\ninclude/foo.h\nlibbar.a\n"; g != w {
+		t.Errorf("expected the inputs manifest %q, got %q", w, g)
+	}
+}
+
+func TestStaticLibInSharedLibs(t *testing.T) {
+	testCcError(t, `shared_libs: "libbar" is a static library, move it to static_libs`, `
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			shared_libs: ["libbar"],
+		}`)
+
+	// libbar is only built static for arm, which must not stop the arm64 variant of libfoo from
+	// linking against it.
+	testCc(t, `
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			arch: {
+				arm: {
+					shared: {
+						enabled: false,
+					},
+				},
+			},
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			arch: {
+				arm64: {
+					shared_libs: ["libbar"],
+				},
+			},
+		}`)
+}
+
+func TestEffectiveCflagsInfo(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			cflags: ["-DFOO"],
+		}`)
+
+	info := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module).EffectiveCflagsInfo()
+	for _, flag := range []string{
+		// global
+		"${config.CommonClangGlobalCflags}",
+		// module
+		"-DFOO",
+		// system includes
+		"${config.CommonGlobalIncludes}",
+	} {
+		if !inList(flag, info.CFlags) {
+			t.Errorf("expected %q in the effective cflags, got %q", flag, info.CFlags)
+		}
+	}
+	if indexList("${config.CommonClangGlobalCflags}", info.CFlags) > indexList("-DFOO", info.CFlags) {
+		t.Errorf("expected the global flags before the module flags, got %q", info.CFlags)
+	}
+	if indexList("$cflags", info.CFlags) != -1 {
+		t.Errorf("expected the effective cflags not to reference the module variable, got %q", info.CFlags)
+	}
+}
+
+func TestAllowIllegalCflags(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			cflags: ["-w"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			cflags: ["-w"],
+			allow_illegal_cflags: ["-w"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if inList("-w", libfoo.flags.CFlags) {
+		t.Errorf("expected -w to be removed from the cflags, got %q", libfoo.flags.CFlags)
+	}
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if !inList("-w", libbar.flags.CFlags) {
+		t.Errorf("expected -w to be kept in the cflags, got %q", libbar.flags.CFlags)
+	}
+
+	testCcError(t, `allow_illegal_cflags: "-Wall" is not an illegal flag`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			allow_illegal_cflags: ["-Wall"],
+		}`)
+}
+
+func TestVersionScriptCheck(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			version_script: "foo.map.txt",
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	check := libfoo.Output("libfoo.so.version_script.check")
+	if check.Args["versionScript"] != "foo.map.txt" {
+		t.Errorf("expected foo.map.txt to be checked, got %q", check.Args["versionScript"])
+	}
+	if !strings.HasSuffix(check.Input.String(), "libfoo.so.toc") {
+		t.Errorf("expected the version script to be checked against the toc, got %q", check.Input.String())
+	}
+	if !strings.Contains(libfoo.Output("libfoo.so").Args["ldFlags"], "-Wl,--version-script,foo.map.txt") {
+		t.Errorf("expected the version script in the ldflags")
+	}
+	for _, output := range check.ImplicitOutputs.Strings() {
+		if !strings.HasSuffix(output, ".exported") && !strings.HasSuffix(output, ".stale") {
+			t.Errorf("unexpected implicit output %q", output)
+		}
+	}
+
+	// Run the check on a table of contents and annotated version scripts.
+	dir, err := ioutil.TempDir("", "version_script_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	toc := filepath.Join(dir, "libfoo.so.toc")
+	err = ioutil.WriteFile(toc, []byte(strings.Join([]string{
+		"Symbol table '.dynsym' contains 4 entries:",
+		"   Num:    Type    Bind   Vis      Ndx Name",
+		"     0:   NOTYPE  LOCAL  DEFAULT  UND ",
+		"     1:   FUNC    GLOBAL DEFAULT  UND abort@LIBC",
+		"     2:   FUNC    GLOBAL DEFAULT   10 foo",
+		"     3:   FUNC    WEAK   DEFAULT   10 bar",
+	}, "\n")+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCheck := func(versionScript string) error {
+		mapFile := filepath.Join(dir, "foo.map.txt")
+		if err := ioutil.WriteFile(mapFile, []byte(versionScript), 0666); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "check")
+		return runRuleCommand(check.RuleParams.Command, map[string]string{
+			"in":            toc,
+			"out":           out,
+			"versionScript": mapFile,
+			"exported":      out + ".exported",
+			"stale":         out + ".stale",
+		})
+	}
+
+	if err := runCheck("LIBFOO {\n  global:\n    foo; # introduced=26\n    bar; # var\n  local:\n    *;\n};\n"); err != nil {
+		t.Errorf("expected the check to pass on annotated exported symbols, got %s", err)
+	}
+	if err := runCheck("LIBFOO {\n  global:\n    foo; # introduced=26\n    baz; # introduced=28\n  local:\n    *;\n};\n"); err == nil {
+		t.Errorf("expected the check to fail on a symbol that is not exported")
+	}
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared")
+	if libbar.MaybeOutput("libbar.so.version_script.check").Rule != nil {
+		t.Errorf("expected no version script check without version_script")
+	}
+}
+
+func TestObjcFlags(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c", "foo_cpp.cpp", "foo_objc.m", "foo_objcpp.mm"],
+			conlyflags: ["-DCONLY"],
+			cppflags: ["-DCPP"],
+			objcflags: ["-DOBJC"],
+			objcppflags: ["-DOBJCPP"],
+		}`
+
+	ctx := testCcWithFs(t, bp, map[string][]byte{
+		"foo_cpp.cpp":   nil,
+		"foo_objc.m":    nil,
+		"foo_objcpp.mm": nil,
+	})
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	// The cflags and cppflags are collapsed into module variables, expand them from the flags of
+	// the module.
+	moduleFlags := libfoo.Module().(*Module).flags
+	expand := map[string][]string{
+		"$cflags":   moduleFlags.CFlags,
+		"$cppflags": moduleFlags.CppFlags,
+	}
+	for _, tc := range []struct {
+		obj      string
+		ccCmd    string
+		flags    []string
+		notFlags []string
+	}{
+		{"obj/foo.o", "clang", []string{"-DCONLY"}, []string{"-DCPP", "-DOBJC", "-DOBJCPP"}},
+		{"obj/foo_cpp.o", "clang++", []string{"-DCPP"}, []string{"-DCONLY", "-DOBJC", "-DOBJCPP"}},
+		{"obj/foo_objc.o", "clang", []string{"-DCONLY", "-DOBJC"}, []string{"-DCPP", "-DOBJCPP"}},
+		{"obj/foo_objcpp.o", "clang++", []string{"-DCPP", "-DOBJCPP"}, []string{"-DCONLY", "-DOBJC"}},
+	} {
+		rule := libfoo.Output(tc.obj)
+		if !strings.HasSuffix(rule.Args["ccCmd"], "/"+tc.ccCmd) {
+			t.Errorf("expected %q to be compiled with %q, got %q", tc.obj, tc.ccCmd, rule.Args["ccCmd"])
+		}
+		var cFlags []string
+		for _, flag := range strings.Fields(rule.Args["cFlags"]) {
+			if expanded, ok := expand[flag]; ok {
+				cFlags = append(cFlags, expanded...)
+			} else {
+				cFlags = append(cFlags, flag)
+			}
+		}
+		for _, flag := range tc.flags {
+			if !inList(flag, cFlags) {
+				t.Errorf("expected %q in the cflags of %q, got %q", flag, tc.obj, cFlags)
+			}
+		}
+		for _, flag := range tc.notFlags {
+			if inList(flag, cFlags) {
+				t.Errorf("expected no %q in the cflags of %q, got %q", flag, tc.obj, cFlags)
+			}
+		}
+	}
+}
+
+func TestLtoMode(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libnone",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libthin",
+			srcs: ["foo.c"],
+			lto: {
+				thin: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libfull",
+			srcs: ["foo.c"],
+			lto: {
+				full: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libnever",
+			srcs: ["foo.c"],
+			lto: {
+				thin: true,
+				never: true,
+			},
+		}`)
+
+	for _, tc := range []struct {
+		name string
+		mode string
+	}{
+		{"libnone", "none"},
+		{"libthin", "thin"},
+		{"libfull", "full"},
+		{"libnever", "none"},
+	} {
+		module := ctx.ModuleForTests(tc.name, "android_arm64_armv8-a_core_shared").Module().(*Module)
+		if mode := module.OptimizationInfo().LtoMode; mode != tc.mode {
+			t.Errorf("expected LtoMode %q for %s, got %q", tc.mode, tc.name, mode)
+		}
+	}
+}
+
+func TestLibraryAliases(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library {
+			name: "libnew",
+			srcs: ["foo.c"],
+			aliases: ["libold"],
+		}
+
+		cc_library_shared {
+			name: "libshareduser",
+			srcs: ["foo.c"],
+			shared_libs: ["libold"],
+		}
+
+		cc_library_shared {
+			name: "libstaticuser",
+			srcs: ["foo.c"],
+			static_libs: ["libold"],
+		}`)
+
+	sharedUser := ctx.ModuleForTests("libshareduser", "android_arm64_armv8-a_core_shared").Rule("ld")
+	libnewShared := ctx.ModuleForTests("libnew", "android_arm64_armv8-a_core_shared").Module().(*Module).OutputFile().Path()
+	if !strings.Contains(sharedUser.Args["libFlags"], libnewShared.String()) {
+		t.Errorf("expected the alias to link %q, got libFlags %q", libnewShared, sharedUser.Args["libFlags"])
+	}
+
+	staticUser := ctx.ModuleForTests("libstaticuser", "android_arm64_armv8-a_core_shared").Rule("ld")
+	libnewStatic := ctx.ModuleForTests("libnew", "android_arm64_armv8-a_core_static").Module().(*Module).OutputFile().Path()
+	if !strings.Contains(staticUser.Args["libFlags"], libnewStatic.String()) {
+		t.Errorf("expected the alias to link %q, got libFlags %q", libnewStatic, staticUser.Args["libFlags"])
+	}
+
+	testCcError(t, `aliases: "libbar" is the name of an existing module`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			aliases: ["libbar"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}`)
+
+	testCcError(t, `aliases: "libold" is already an alias of`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			aliases: ["libold"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["foo.c"],
+			aliases: ["libold"],
+		}`)
+}
+
+func TestEmitLinkMap(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			emit_link_map: true,
+		}
+
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			emit_link_map: true,
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}`)
+
+	for _, tc := range []struct {
+		name    string
+		variant string
+		mapFile string
+	}{
+		{"libfoo", "android_arm64_armv8-a_core_shared", "libfoo.so.map"},
+		{"foo", "android_arm64_armv8-a_core", "foo.map"},
+	} {
+		module := ctx.ModuleForTests(tc.name, tc.variant)
+		linkMap := module.Output(tc.mapFile)
+		if linkMap.Rule != ld {
+			t.Errorf("expected %q to be written by the linker, got rule %v", tc.mapFile, linkMap.Rule)
+		}
+		mapFile := module.Module().(*Module).LinkMapFile()
+		if !mapFile.Valid() || !inList(mapFile.String(), linkMap.ImplicitOutputs.Strings()) {
+			t.Errorf("expected LinkMapFile to be one of %q, got %q", linkMap.ImplicitOutputs, mapFile)
+		}
+		if !strings.Contains(linkMap.Args["ldFlags"], "-Wl,-Map="+mapFile.String()) {
+			t.Errorf("expected -Wl,-Map=%s in ldflags, got %q", mapFile, linkMap.Args["ldFlags"])
+		}
+	}
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared")
+	if mapFile := libbar.Module().(*Module).LinkMapFile(); mapFile.Valid() {
+		t.Errorf("expected no link map without emit_link_map, got %q", mapFile)
+	}
+	if ldFlags := libbar.Rule("ld").Args["ldFlags"]; strings.Contains(ldFlags, "-Wl,-Map=") {
+		t.Errorf("expected no -Wl,-Map= in ldflags, got %q", ldFlags)
+	}
+}
+
+func TestStripTool(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			strip_tool: "my_strip",
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`
+
+	ctx := testCcWithFs(t, bp, map[string][]byte{
+		"my_strip": nil,
+	})
+
+	strip := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Rule("strip")
+	if !strings.Contains(strip.Args["args"], "-t my_strip") {
+		t.Errorf("expected the strip tool in the strip args, got %q", strip.Args["args"])
+	}
+	if !inList("my_strip", strip.Implicits.Strings()) {
+		t.Errorf("expected the strip to depend on the strip tool, got %q", strip.Implicits.Strings())
+	}
+
+	strip = ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared").Rule("strip")
+	if strings.Contains(strip.Args["args"], "-t ") {
+		t.Errorf("expected the default strip tool, got args %q", strip.Args["args"])
+	}
+
+	testCcError(t, `strip_tool: must be a source path, not a module reference ":my_strip"`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			strip_tool: ":my_strip",
+		}`)
+}
+
+func TestRequiredClangFeatures(t *testing.T) {
+	testCc(t, `
+		cc_library {
+			name: "libfoo",
+			required_clang_features: ["-ftrivial-auto-var-init"],
+		}`)
+
+	testCcError(t, `unknown clang feature "__builtin_does_not_exist"`, `
+		cc_library {
+			name: "libfoo",
+			required_clang_features: ["__builtin_does_not_exist"],
+		}`)
+
+	// The default clang-r353983c predates __builtin_is_constant_evaluated, although its release
+	// version is 9.0.3.
+	testCcError(t, `"__builtin_is_constant_evaluated" requires clang r359067 or newer, but clang-r353983c is used`, `
+		cc_library {
+			name: "libfoo",
+			required_clang_features: ["__builtin_is_constant_evaluated"],
+		}`)
+
+	bp := `
+		cc_library {
+			name: "libfoo",
+			required_clang_features: ["asm_goto", "__builtin_is_constant_evaluated"],
+		}`
+	config := android.TestArchConfig(buildDir, map[string]string{"LLVM_PREBUILTS_VERSION": "clang-r365631"})
+	testCcWithConfig(t, bp, config)
+
+	config = android.TestArchConfig(buildDir, map[string]string{"LLVM_PREBUILTS_VERSION": "clang-stable"})
+	testCcErrorWithConfig(t, `the svn revision of clang-stable is unknown`, bp, config)
+}
+
+func TestDisallowDeprecatedStl(t *testing.T) {
+	bp := `
+		ndk_library {
+			name: "libstdc++",
+			symbol_file: "foo.map.txt",
+			first_version: "9",
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sdk_version: "current",
+			stl: "system",
+			system_shared_libs: [],
+			nocrt: true,
+		}`
+
+	// deprecated STLs are only rejected when asked for
+	testCc(t, bp)
+
+	config := android.TestArchConfig(buildDir, map[string]string{envVariableDisallowDeprecatedStl: "true"})
+	testCcErrorWithConfig(t, `stl: the "ndk_system" STL is deprecated, use one of \["c\+\+_shared" "c\+\+_static"\] instead`, bp, config)
+}
+
+func TestInstallPartition(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libvendor",
+			vendor: true,
+			install_partition: "product_services",
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library_shared {
+			name: "libvendor2",
+			vendor: true,
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}`)
+
+	for _, tc := range []struct {
+		name string
+		dir  string
+	}{
+		{"libvendor", "target/product/test_device/product_services/lib64"},
+		{"libvendor2", "target/product/test_device/vendor/lib64"},
+	} {
+		library := ctx.ModuleForTests(tc.name, vendorVariant).Module().(*Module).installer.(*libraryDecorator)
+		if g := filepath.Dir(library.baseInstaller.path.Rel()); g != tc.dir {
+			t.Errorf("expected %s to be installed to %q, got %q", tc.name, tc.dir, g)
+		}
+	}
+
+	testCcError(t, `install_partition: "data" is not a partition`, `
+		cc_library_shared {
+			name: "libvendor",
+			vendor: true,
+			install_partition: "data",
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}`)
+}
+
+func TestLargeWholeStaticLibs(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libbig",
+			srcs: ["foo.c", "bar.c"],
+			whole_static_libs: ["libsmall"],
+		}
+
+		cc_library_static {
+			name: "libsmall",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			whole_static_libs: ["libbig", "libsmall"],
+		}`
+
+	config := android.TestArchConfig(buildDir, map[string]string{"SOONG_WHOLE_STATIC_LIBS_OBJECT_LIMIT": "2"})
+	testCcWithConfig(t, bp, config)
+
+	// libbig contributes its own two objects and the one of libsmall
+	var got []string
+	getNamedMapForConfig(config, modulesWithLargeWholeStaticLibsKey).Range(func(key, value interface{}) bool {
+		got = append(got, key.(string))
+		return true
+	})
+	if w := []string{"./Android.bp:libfoo:libbig"}; !reflect.DeepEqual(got, w) {
+		t.Errorf("expected %q to be listed, got %q", w, got)
+	}
+}
+
+func TestGeneratedSourceFlags(t *testing.T) {
+	bp := `
+		genrule {
+			name: "gen_source",
+			cmd: "touch $(out)",
+			out: ["gen.c"],
+			generated_source_cflags: ["-DGEN_NAME=\"gen source\"", "-Wno-unused-parameter"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generated_sources: ["gen_source"],
+		}`
+	ctx := testCcWithConfig(t, bp, android.TestArchConfig(buildDir, nil))
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	var genObj, fooObj android.TestingBuildParams
+	for _, o := range libfoo.Rule("ld").Inputs {
+		switch o.Base() {
+		case "gen.o":
+			genObj = libfoo.Output(o.String())
+		case "foo.o":
+			fooObj = libfoo.Output(o.String())
+		}
+	}
+
+	// only the generated source is compiled with the escaped flags of its generator
+	if w := ` '-DGEN_NAME="gen source"' -Wno-unused-parameter`; !strings.HasSuffix(genObj.Args["cFlags"], w) {
+		t.Errorf("expected gen.c to be compiled with %q, got %q", w, genObj.Args["cFlags"])
+	}
+	if strings.Contains(fooObj.Args["cFlags"], "-Wno-unused-parameter") {
+		t.Errorf("expected foo.c not to be compiled with -Wno-unused-parameter, got %q", fooObj.Args["cFlags"])
+	}
+
+	testCcError(t, "generated_sources: Bad flag `-Igen`, use local_include_dirs or include_dirs instead", `
+		genrule {
+			name: "gen_source",
+			cmd: "touch $(out)",
+			out: ["gen.c"],
+			generated_source_cflags: ["-Igen"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generated_sources: ["gen_source"],
+		}`)
+}
+
+func TestSysroot(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cc_binary_host tests fail on mac when trying to exec xcrun")
+	}
+	bp := `
+		cc_binary_host {
+			name: "mytool",
+			srcs: ["foo.c"],
+			stl: "none",
+			sysroot: "my_sysroot",
+		}`
+	ctx := testCcWithFs(t, bp, map[string][]byte{
+		"my_sysroot/usr/include/stdio.h": nil,
+	})
+
+	mytool := ctx.ModuleForTests("mytool", android.BuildOs.String()+"_x86_64")
+	cflags := mytool.Rule("cc").Args["cFlags"]
+	if !strings.Contains(cflags, "--sysroot=my_sysroot") {
+		t.Errorf("expected %q in cflags, got %q", "--sysroot=my_sysroot", cflags)
+	}
+	if !strings.Contains(cflags, "-isystem my_sysroot/usr/include") {
+		t.Errorf("expected %q in cflags, got %q", "-isystem my_sysroot/usr/include", cflags)
+	}
+	ldflags := mytool.Rule("ld").Args["ldFlags"]
+	if !strings.Contains(ldflags, "--sysroot=my_sysroot") {
+		t.Errorf("expected %q in ldflags, got %q", "--sysroot=my_sysroot", ldflags)
+	}
+
+	testCcError(t, `"missing_sysroot" is not a directory in `, `
+		cc_binary_host {
+			name: "mytool",
+			srcs: ["foo.c"],
+			stl: "none",
+			sysroot: "missing_sysroot",
+		}`)
+
+	// A file is not a sysroot.
+	config := android.TestArchConfig(buildDir, nil)
+	ctx = createTestContext(t, config, `
+		cc_binary_host {
+			name: "mytool",
+			srcs: ["foo.c"],
+			stl: "none",
+			sysroot: "sysroot.tar",
+		}`, map[string][]byte{
+		"sysroot.tar": nil,
+	}, android.Android)
+	ctx.Register()
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfNoMatchingErrors(t, `"sysroot.tar" is not a directory in `, errs)
+
+	// Device modules get their headers and libraries from system_shared_libs instead.
+	testCcError(t, `sysroot: is only supported for host modules`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sysroot: "my_sysroot",
+		}`)
+}
+
+func TestTestOptions(t *testing.T) {
+	bp := `
+		cc_test {
+			name: "mytest",
+			srcs: ["foo.c"],
+			gtest: false,
+			test_options: {
+				timeout: "5m",
+				min_api_level: 29,
+			},
+		}`
+	ctx := testCcWithConfig(t, bp, android.TestArchConfig(buildDir, nil))
+
+	extraConfigs := ctx.ModuleForTests("mytest", "android_arm64_armv8-a_core").Output("mytest.config").Args["extraConfigs"]
+	for _, config := range []string{
+		`<option name="native-test-timeout" value="5m" />`,
+		`<object type="module_controller" class="com.android.tradefed.testtype.suite.module.MinApiLevelModuleController">` +
+			`<option name="min-api-level" value="29" /></object>`,
+	} {
+		if !strings.Contains(extraConfigs, config) {
+			t.Errorf("expected %q in the test config, got %q", config, extraConfigs)
+		}
+	}
+
+	testCcError(t, `module "mytest".*: test_options.timeout: cannot be set together with test_config`, `
+		cc_test {
+			name: "mytest",
+			srcs: ["foo.c"],
+			gtest: false,
+			test_config: "mytest.xml",
+			test_options: {
+				timeout: "5m",
+			},
+		}`)
+}
+
+func TestCcCompileMetrics(t *testing.T) {
+	ctx := testCcWithFs(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.c", "baz.cpp", "qux.S"],
+			lto: {
+				thin: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["baz.cpp", "quux.mm"],
+		}`, map[string][]byte{
+		"baz.cpp": nil,
+		"qux.S":   nil,
+		"quux.mm": nil,
+	})
+
+	getMetrics := func(name string) CcCompileMetrics {
+		return ctx.ModuleForTests(name, "android_arm64_armv8-a_core_static").Module().(*Module).CcCompileMetrics()
+	}
+
+	if g, w := getMetrics("libfoo"), (CcCompileMetrics{CSrcs: 2, CppSrcs: 1, AsmSrcs: 1, Lto: true}); g != w {
+		t.Errorf("expected libfoo metrics %+v, got %+v", w, g)
+	}
+	if g, w := getMetrics("libbar"), (CcCompileMetrics{CppSrcs: 1, ObjcSrcs: 1}); g != w {
+		t.Errorf("expected libbar metrics %+v, got %+v", w, g)
+	}
+}
@@ -16,9 +16,11 @@ package cc
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"android/soong/android"
@@ -30,9 +32,17 @@ import (
 // at out/development/ide/compdb/compile_commands.json. It will also symlink it
 // to ${SOONG_LINK_COMPDB_TO} if set. In general this should be created by running
 // make SOONG_GEN_COMPDB=1 nothing to get all targets.
+//
+// The compdb_fragments singleton instead writes a compile_commands.json fragment for each variant
+// of each cc module, with one entry per source, to
+// out/development/ide/compdb/fragments/<module dir>/<module name>/<variant>/compile_commands.json
+// so that tools can load the commands of the modules they need, and merges all the fragments into
+// out/development/ide/compdb/fragments/compile_commands.json. It is enabled with
+// make SOONG_GEN_COMPDB_FRAGMENTS=1 nothing.
 
 func init() {
 	android.RegisterSingletonType("compdb_generator", compDBGeneratorSingleton)
+	android.RegisterSingletonType("compdb_fragments", compdbFragmentsSingleton)
 }
 
 func compDBGeneratorSingleton() android.Singleton {
@@ -44,11 +54,13 @@ type compdbGeneratorSingleton struct{}
 const (
 	compdbFilename                = "compile_commands.json"
 	compdbOutputProjectsDirectory = "out/development/ide/compdb"
+	compdbFragmentsDirectory      = "fragments"
 
 	// Environment variables used to modify behavior of this singleton.
 	envVariableGenerateCompdb          = "SOONG_GEN_COMPDB"
 	envVariableGenerateCompdbDebugInfo = "SOONG_GEN_COMPDB_DEBUG"
 	envVariableCompdbLink              = "SOONG_LINK_COMPDB_TO"
+	envVariableGenerateCompdbFragments = "SOONG_GEN_COMPDB_FRAGMENTS"
 )
 
 // A compdb entry. The compile_commands.json file is a list of these.
@@ -112,6 +124,100 @@ func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonCon
 	}
 }
 
+func compdbFragmentsSingleton() android.Singleton {
+	return &compdbFragmentsSingletonType{}
+}
+
+type compdbFragmentsSingletonType struct{}
+
+func (c *compdbFragmentsSingletonType) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue(envVariableGenerateCompdbFragments) {
+		return
+	}
+
+	outputCompdbDebugInfo := ctx.Config().IsEnvTrue(envVariableGenerateCompdbDebugInfo)
+
+	dir := filepath.Join(getCompdbAndroidSrcRootDirectory(ctx), compdbOutputProjectsDirectory,
+		compdbFragmentsDirectory)
+	writeCompdbFragments(dir, compdbFragments(ctx), outputCompdbDebugInfo)
+}
+
+// compdbFragments returns the compile_commands.json entries of each variant of each cc module,
+// keyed by the path of their fragment relative to the fragments directory. Unlike in
+// compile_commands.json, each variant of a module keeps its own entries.
+func compdbFragments(ctx android.SingletonContext) map[string][]compDbEntry {
+	rootDir := getCompdbAndroidSrcRootDirectory(ctx)
+	ccPath, cxxPath := getCompdbCompilerPaths(ctx, rootDir)
+
+	fragments := make(map[string][]compDbEntry)
+	ctx.VisitAllModules(func(module android.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() {
+			return
+		}
+		compiledModule, ok := ccModule.compiler.(CompiledInterface)
+		if !ok {
+			return
+		}
+
+		var entries []compDbEntry
+		for _, src := range compiledModule.Srcs() {
+			entries = append(entries, compDbEntry{
+				Directory: rootDir,
+				Arguments: getArguments(src, ctx, ccModule, ccPath, cxxPath),
+				File:      src.String(),
+			})
+		}
+		if len(entries) == 0 {
+			return
+		}
+
+		fragment := filepath.Join(ctx.ModuleDir(module), ctx.ModuleName(module),
+			ctx.ModuleSubDir(module), compdbFilename)
+		fragments[fragment] = entries
+	})
+	return fragments
+}
+
+// writeCompdbFragments writes each of the fragments to its path under dir, and the entries of all
+// of them merged into dir/compile_commands.json.
+func writeCompdbFragments(dir string, fragments map[string][]compDbEntry, indent bool) {
+	var paths []string
+	for path := range fragments {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var merged []compDbEntry
+	for _, path := range paths {
+		writeCompdbFile(filepath.Join(dir, path), fragments[path], indent)
+		merged = append(merged, fragments[path]...)
+	}
+	writeCompdbFile(filepath.Join(dir, compdbFilename), merged, indent)
+}
+
+// writeCompdbFile writes the given entries to a compile_commands.json file at path, creating its
+// directory if needed.
+func writeCompdbFile(path string, entries []compDbEntry, indent bool) {
+	if entries == nil {
+		entries = []compDbEntry{}
+	}
+	var dat []byte
+	var err error
+	if indent {
+		dat, err = json.MarshalIndent(entries, "", " ")
+	} else {
+		dat, err = json.Marshal(entries)
+	}
+	if err != nil {
+		log.Fatalf("Failed to marshal: %s", err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0777)
+	if err := ioutil.WriteFile(path, dat, 0666); err != nil {
+		log.Fatalf("Could not write file %s: %s", path, err)
+	}
+}
+
 func expandAllVars(ctx android.SingletonContext, args []string) []string {
 	var out []string
 	for _, arg := range args {
@@ -130,6 +236,7 @@ func getArguments(src android.Path, ctx android.SingletonContext, ccModule *Modu
 	var args []string
 	isCpp := false
 	isAsm := false
+	isObjc := false
 	// TODO It would be better to ask soong for the types here.
 	var clangPath string
 	switch src.Ext() {
@@ -137,13 +244,15 @@ func getArguments(src android.Path, ctx android.SingletonContext, ccModule *Modu
 		isAsm = true
 		isCpp = false
 		clangPath = ccPath
-	case ".c":
+	case ".c", ".m":
 		isAsm = false
 		isCpp = false
+		isObjc = src.Ext() == ".m"
 		clangPath = ccPath
 	case ".cpp", ".cc", ".mm":
 		isAsm = false
 		isCpp = true
+		isObjc = src.Ext() == ".mm"
 		clangPath = cxxPath
 	default:
 		log.Print("Unknown file extension " + src.Ext() + " on file " + src.String())
@@ -156,8 +265,14 @@ func getArguments(src android.Path, ctx android.SingletonContext, ccModule *Modu
 	args = append(args, expandAllVars(ctx, ccModule.flags.CFlags)...)
 	if isCpp {
 		args = append(args, expandAllVars(ctx, ccModule.flags.CppFlags)...)
+		if isObjc {
+			args = append(args, expandAllVars(ctx, ccModule.flags.ObjcppFlags)...)
+		}
 	} else if !isAsm {
 		args = append(args, expandAllVars(ctx, ccModule.flags.ConlyFlags)...)
+		if isObjc {
+			args = append(args, expandAllVars(ctx, ccModule.flags.ObjcFlags)...)
+		}
 	}
 	args = append(args, expandAllVars(ctx, ccModule.flags.SystemIncludeFlags)...)
 	args = append(args, src.String())
@@ -171,13 +286,7 @@ func generateCompdbProject(compiledModule CompiledInterface, ctx android.Singlet
 	}
 
 	rootDir := getCompdbAndroidSrcRootDirectory(ctx)
-	pathToCC, err := ctx.Eval(pctx, rootDir+"/${config.ClangBin}/")
-	ccPath := "/bin/false"
-	cxxPath := "/bin/false"
-	if err == nil {
-		ccPath = pathToCC + "clang"
-		cxxPath = pathToCC + "clang++"
-	}
+	ccPath, cxxPath := getCompdbCompilerPaths(ctx, rootDir)
 	for _, src := range srcs {
 		if _, ok := builds[src.String()]; !ok {
 			builds[src.String()] = compDbEntry{
@@ -189,6 +298,16 @@ func generateCompdbProject(compiledModule CompiledInterface, ctx android.Singlet
 	}
 }
 
+// getCompdbCompilerPaths returns the absolute paths to clang and clang++, or /bin/false if they
+// can't be found.
+func getCompdbCompilerPaths(ctx android.SingletonContext, rootDir string) (ccPath, cxxPath string) {
+	pathToCC, err := ctx.Eval(pctx, rootDir+"/${config.ClangBin}/")
+	if err != nil {
+		return "/bin/false", "/bin/false"
+	}
+	return pathToCC + "clang", pathToCC + "clang++"
+}
+
 func evalAndSplitVariable(ctx android.SingletonContext, str string) ([]string, error) {
 	evaluated, err := ctx.Eval(pctx, str)
 	if err == nil {
@@ -0,0 +1,104 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"android/soong/android"
+)
+
+// testCompdbFragmentsSingleton writes the compdb fragments to dir instead of the source tree.
+type testCompdbFragmentsSingleton struct {
+	dir string
+}
+
+func (s *testCompdbFragmentsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	writeCompdbFragments(s.dir, compdbFragments(ctx), false)
+}
+
+func readCompdbFile(t *testing.T, path string) []compDbEntry {
+	t.Helper()
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	var entries []compDbEntry
+	if err := json.Unmarshal(dat, &entries); err != nil {
+		t.Fatalf("failed to unmarshal %s: %s", path, err)
+	}
+	return entries
+}
+
+func TestCompdbFragments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compdb_fragments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.c"],
+			cflags: ["-DFOO"],
+		}`
+	config := android.TestArchConfig(buildDir, nil)
+	ctx := createTestContext(t, config, bp, nil, android.Android)
+	ctx.RegisterSingletonType("compdb_fragments_test", android.SingletonFactoryAdaptor(func() android.Singleton {
+		return &testCompdbFragmentsSingleton{dir: dir}
+	}))
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	variant := "android_arm64_armv8-a_core_static"
+	entries := readCompdbFile(t, filepath.Join(dir, "libfoo", variant, compdbFilename))
+	if len(entries) != 2 {
+		t.Fatalf("expected an entry for each source of libfoo, got %q", entries)
+	}
+	for i, src := range []string{"foo.c", "bar.c"} {
+		if entries[i].File != src {
+			t.Errorf("expected entry %d to be for %q, got %q", i, src, entries[i].File)
+		}
+		args := entries[i].Arguments
+		if len(args) == 0 || args[len(args)-1] != src {
+			t.Errorf("expected the arguments of %q to end with the source, got %q", src, args)
+		}
+		if !inList("-DFOO", args) {
+			t.Errorf("expected the cflags of libfoo in the arguments of %q, got %q", src, args)
+		}
+	}
+
+	// Each variant keeps its own entries in the merged file.
+	shared := readCompdbFile(t, filepath.Join(dir, "libfoo", "android_arm64_armv8-a_core_shared", compdbFilename))
+	merged := readCompdbFile(t, filepath.Join(dir, compdbFilename))
+	count := 0
+	for _, entry := range merged {
+		if entry.File == "foo.c" {
+			count++
+		}
+	}
+	if len(shared) != 2 || count < 2 {
+		t.Errorf("expected the entries of both the static and shared variants of libfoo, got %d entries for foo.c in %q", count, merged)
+	}
+}
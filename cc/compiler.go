@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/google/blueprint/pathtools"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -46,6 +47,12 @@ type BaseCompilerProperties struct {
 	// list of module-specific flags that will be used for C compiles
 	Conlyflags []string `android:"arch_variant"`
 
+	// list of module-specific flags that will be used for Objective-C compiles
+	Objcflags []string `android:"arch_variant"`
+
+	// list of module-specific flags that will be used for Objective-C++ compiles
+	Objcppflags []string `android:"arch_variant"`
+
 	// list of module-specific flags that will be used for .S compiles
 	Asflags []string `android:"arch_variant"`
 
@@ -60,6 +67,17 @@ type BaseCompilerProperties struct {
 	// list of module-specific flags that will be used for .y and .yy compiles
 	Yaccflags []string
 
+	// directory to compile and link against instead of the sysroot of the toolchain, e.g. a
+	// pinned copy of a host sysroot. Either a directory relative to the module directory, or
+	// ":module" for a genrule whose single exported include directory is the sysroot. Only
+	// supported for host modules: device modules don't use the sysroot of the toolchain, they get
+	// the bionic headers and libraries from their system_shared_libs.
+	Sysroot *string
+
+	// list of compiler features or builtins, e.g. "__builtin_is_constant_evaluated", that the
+	// module needs. The build fails with an error if the clang in use is too old to provide them.
+	Required_clang_features []string
+
 	// the instruction set architecture to use to compile the C/C++
 	// module.
 	Instruction_set *string `android:"arch_variant"`
@@ -79,6 +97,12 @@ type BaseCompilerProperties struct {
 	// directories. Defaults to true.
 	Include_build_directory *bool
 
+	// Compile the module with only the include directories it declares, without the global
+	// include directories or (unless include_build_directory is explicitly set) the directory
+	// containing the Android.bp file.  Used to catch modules that depend on headers they don't
+	// declare.  Defaults to false.
+	Quarantine_build *bool
+
 	// list of generated sources to compile. These are the names of gensrcs or
 	// genrule modules.
 	Generated_sources []string `android:"arch_variant"`
@@ -90,6 +114,10 @@ type BaseCompilerProperties struct {
 	// pass -frtti instead of -fno-rtti
 	Rtti *bool
 
+	// pass -fno-rtti on all targets and fail the build if the objects of this module use RTTI,
+	// e.g. through dynamic_cast or typeid. Can't be used with rtti.
+	No_rtti *bool
+
 	// C standard version to use. Can be a specific version (such as "gnu11"),
 	// "experimental" (which will use draft versions like C1x when available),
 	// or the empty string (which will use the default).
@@ -103,6 +131,23 @@ type BaseCompilerProperties struct {
 	// if set to false, use -std=c++* instead of -std=gnu++*
 	Gnu_extensions *bool
 
+	// Floating point policy to compile C and C++ sources with. Can be "precise" (no contraction
+	// and no fast-math), "fast" (-ffast-math) or "contract-on" (-ffp-contract=on), or the empty
+	// string (which will use the toolchain default).  Modules that choose "fast" while being
+	// compiled with the integer_overflow or cfi sanitizers are reported to make, which warns
+	// about them.
+	Fp_policy *string
+
+	// When compiling against the NDK, make references to APIs that are newer than sdk_version
+	// weak instead of compile errors, so that the module can still be loaded on older devices.
+	// Uses of such APIs must be guarded by a runtime availability check.
+	Weak_ndk_apis *bool
+
+	// File listing the compiler warnings that are allowed in this module, one per line in the form
+	// "<file>: warning: <message> [<flag>]". Warnings are not treated as errors, but the build
+	// fails if a source file has a warning that is not listed.
+	Warning_baseline *string `android:"path,arch_variant"`
+
 	Aidl struct {
 		// list of directories that will be added to the aidl include paths.
 		Include_dirs []string
@@ -191,6 +236,13 @@ type baseCompiler struct {
 	// other modules and filegroups. May include source files that have not yet been translated to
 	// C/C++ (.aidl, .proto, etc.)
 	srcsBeforeGen android.Paths
+
+	// Extensions of the sources listed in srcs without the ones removed by exclude_srcs, for
+	// hasSrcExt before srcsBeforeGen is set
+	srcExts map[string]bool
+
+	// Directories added to the aidl include paths
+	aidlIncludeDirs android.Paths
 }
 
 var _ compiler = (*baseCompiler)(nil)
@@ -221,12 +273,16 @@ func (compiler *baseCompiler) compilerDeps(ctx DepsContext, deps Deps) Deps {
 	deps.GeneratedSources = append(deps.GeneratedSources, compiler.Properties.Generated_sources...)
 	deps.GeneratedHeaders = append(deps.GeneratedHeaders, compiler.Properties.Generated_headers...)
 
+	if m := android.SrcIsModule(String(compiler.Properties.Sysroot)); m != "" {
+		deps.Sysroot = m
+	}
+
 	android.ProtoDeps(ctx, &compiler.Proto)
-	if compiler.hasSrcExt(".proto") {
+	if compiler.hasSrcExt(ctx, ".proto") {
 		deps = protoDeps(ctx, deps, &compiler.Proto, Bool(compiler.Properties.Proto.Static))
 	}
 
-	if compiler.hasSrcExt(".sysprop") {
+	if compiler.hasSrcExt(ctx, ".sysprop") {
 		deps.HeaderLibs = append(deps.HeaderLibs, "libbase_headers")
 		deps.SharedLibs = append(deps.SharedLibs, "liblog")
 	}
@@ -253,6 +309,83 @@ func addToModuleList(ctx ModuleContext, key android.OnceKey, module string) {
 	getNamedMapForConfig(ctx.Config(), key).Store(module, true)
 }
 
+var fpPolicyCflags = map[string][]string{
+	"precise":     {"-fno-fast-math", "-ffp-contract=off"},
+	"fast":        {"-ffast-math"},
+	"contract-on": {"-ffp-contract=on"},
+}
+
+// Returns the cflags that implement the fp_policy property.
+func (compiler *baseCompiler) fpPolicyFlags(ctx ModuleContext) []string {
+	policy := String(compiler.Properties.Fp_policy)
+	if policy == "" {
+		return nil
+	}
+
+	cflags, ok := fpPolicyCflags[policy]
+	if !ok {
+		ctx.PropertyErrorf("fp_policy", "%q is not one of \"precise\", \"fast\" or \"contract-on\"", policy)
+		return nil
+	}
+
+	return cflags
+}
+
+func (compiler *baseCompiler) aidlResolvedIncludeDirs() android.Paths {
+	return compiler.aidlIncludeDirs
+}
+
+func (compiler *baseCompiler) fpPolicy() string {
+	return String(compiler.Properties.Fp_policy)
+}
+
+// Reports an error for each of the required_clang_features that the clang in use does not provide.
+func (compiler *baseCompiler) checkRequiredClangFeatures(ctx ModuleContext) {
+	if len(compiler.Properties.Required_clang_features) == 0 {
+		return
+	}
+	clangVersion := config.ClangPrebuiltsVersion(ctx.Config())
+	revision, ok := config.ClangSvnRevision(ctx.Config())
+	if !ok {
+		ctx.PropertyErrorf("required_clang_features", "the svn revision of %s is unknown", clangVersion)
+		return
+	}
+	for _, feature := range compiler.Properties.Required_clang_features {
+		minRevision, ok := config.ClangFeatureRevisions[feature]
+		if !ok {
+			ctx.PropertyErrorf("required_clang_features", "unknown clang feature %q", feature)
+		} else if revision < minRevision {
+			ctx.PropertyErrorf("required_clang_features", "%q requires clang r%d or newer, but %s is used",
+				feature, minRevision, clangVersion)
+		}
+	}
+}
+
+// Returns the directory set by the sysroot property, or nil if it is not set or not valid.
+func (compiler *baseCompiler) sysroot(ctx ModuleContext, deps PathDeps) android.Path {
+	sysroot := String(compiler.Properties.Sysroot)
+	if sysroot == "" {
+		return nil
+	}
+	if ctx.Device() {
+		ctx.PropertyErrorf("sysroot", "is only supported for host modules")
+		return nil
+	}
+	if android.SrcIsModule(sysroot) != "" {
+		if deps.Sysroot.Valid() {
+			return deps.Sysroot.Path()
+		}
+		return nil
+	}
+	if dir := android.ExistentPathForSource(ctx, ctx.ModuleDir(), sysroot); dir.Valid() {
+		if _, isDir, err := ctx.Fs().Exists(dir.String()); err == nil && isDir {
+			return dir.Path()
+		}
+	}
+	ctx.PropertyErrorf("sysroot", "%q is not a directory in %s", sysroot, ctx.ModuleDir())
+	return nil
+}
+
 // Create a Flags struct that collects the compile flags from global values,
 // per-target values, module type values, and per-module Blueprints properties
 func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps PathDeps) Flags {
@@ -260,23 +393,47 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 
 	compiler.srcsBeforeGen = android.PathsForModuleSrcExcludes(ctx, compiler.Properties.Srcs, compiler.Properties.Exclude_srcs)
 	compiler.srcsBeforeGen = append(compiler.srcsBeforeGen, deps.GeneratedSources...)
+	if len(compiler.Properties.OriginalSrcs) == 0 {
+		// srcsBeforeGen has all the expanded srcs, hasSrcExt doesn't need to expand them again.
+		compiler.srcExts = make(map[string]bool)
+	}
+	for src, srcFlags := range deps.GeneratedSourceFlags {
+		CheckBadCompilerFlags(ctx, "generated_sources", srcFlags)
+		if flags.SrcFlags == nil {
+			flags.SrcFlags = make(map[string]string)
+		}
+		flags.SrcFlags[src] = strings.Join(proptools.NinjaAndShellEscapeList(srcFlags), " ")
+	}
 
 	CheckBadCompilerFlags(ctx, "cflags", compiler.Properties.Cflags)
 	CheckBadCompilerFlags(ctx, "cppflags", compiler.Properties.Cppflags)
 	CheckBadCompilerFlags(ctx, "conlyflags", compiler.Properties.Conlyflags)
+	CheckBadCompilerFlags(ctx, "objcflags", compiler.Properties.Objcflags)
+	CheckBadCompilerFlags(ctx, "objcppflags", compiler.Properties.Objcppflags)
 	CheckBadCompilerFlags(ctx, "asflags", compiler.Properties.Asflags)
 	CheckBadCompilerFlags(ctx, "vendor.cflags", compiler.Properties.Target.Vendor.Cflags)
 	CheckBadCompilerFlags(ctx, "recovery.cflags", compiler.Properties.Target.Recovery.Cflags)
+	compiler.checkRequiredClangFeatures(ctx)
 
 	esc := proptools.NinjaAndShellEscapeList
 
+	flags.CFlags = append(flags.CFlags, compiler.fpPolicyFlags(ctx)...)
 	flags.CFlags = append(flags.CFlags, esc(compiler.Properties.Cflags)...)
 	flags.CppFlags = append(flags.CppFlags, esc(compiler.Properties.Cppflags)...)
 	flags.ConlyFlags = append(flags.ConlyFlags, esc(compiler.Properties.Conlyflags)...)
+	flags.ObjcFlags = append(flags.ObjcFlags, esc(compiler.Properties.Objcflags)...)
+	flags.ObjcppFlags = append(flags.ObjcppFlags, esc(compiler.Properties.Objcppflags)...)
 	flags.AsFlags = append(flags.AsFlags, esc(compiler.Properties.Asflags)...)
 	flags.YasmFlags = append(flags.YasmFlags, esc(compiler.Properties.Asflags)...)
 	flags.YaccFlags = append(flags.YaccFlags, esc(compiler.Properties.Yaccflags)...)
 
+	if compiler.Properties.Warning_baseline != nil {
+		flags.WarningBaseline = android.OptionalPathForPath(
+			android.PathForModuleSrc(ctx, *compiler.Properties.Warning_baseline))
+		// Known warnings are allowed, new ones are caught by the check against the baseline.
+		flags.CFlags = append(flags.CFlags, "-Wno-error")
+	}
+
 	// Include dir cflags
 	localIncludeDirs := android.PathsForModuleSrc(ctx, compiler.Properties.Local_include_dirs)
 	if len(localIncludeDirs) > 0 {
@@ -291,17 +448,30 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 		flags.YasmFlags = append(flags.YasmFlags, f)
 	}
 
-	if compiler.Properties.Include_build_directory == nil ||
-		*compiler.Properties.Include_build_directory {
+	quarantine := Bool(compiler.Properties.Quarantine_build)
+
+	if (compiler.Properties.Include_build_directory == nil && !quarantine) ||
+		Bool(compiler.Properties.Include_build_directory) {
 		flags.GlobalFlags = append(flags.GlobalFlags, "-I"+android.PathForModuleSrc(ctx).String())
 		flags.YasmFlags = append(flags.YasmFlags, "-I"+android.PathForModuleSrc(ctx).String())
 	}
 
-	if !(ctx.useSdk() || ctx.useVndk()) || ctx.Host() {
+	if sysroot := compiler.sysroot(ctx, deps); sysroot != nil {
+		// The headers of the sysroot replace the platform ones.
+		flags.GlobalFlags = append(flags.GlobalFlags, "--sysroot="+sysroot.String())
+		flags.LdFlags = append(flags.LdFlags, "--sysroot="+sysroot.String())
 		flags.SystemIncludeFlags = append(flags.SystemIncludeFlags,
-			"${config.CommonGlobalIncludes}",
-			tc.IncludeFlags(),
-			"${config.CommonNativehelperInclude}")
+			"-isystem "+filepath.Join(sysroot.String(), "usr", "include"))
+	} else if !(ctx.useSdk() || ctx.useVndk()) || ctx.Host() {
+		if quarantine {
+			// Only keep the toolchain includes, which aren't specific to any dependency.
+			flags.SystemIncludeFlags = append(flags.SystemIncludeFlags, tc.IncludeFlags())
+		} else {
+			flags.SystemIncludeFlags = append(flags.SystemIncludeFlags,
+				"${config.CommonGlobalIncludes}",
+				tc.IncludeFlags(),
+				"${config.CommonNativehelperInclude}")
+		}
 	}
 
 	if ctx.useSdk() {
@@ -324,6 +494,12 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 		}
 		flags.GlobalFlags = append(flags.GlobalFlags,
 			"-D__ANDROID_API__="+version)
+
+		if Bool(compiler.Properties.Weak_ndk_apis) {
+			flags.CFlags = append(flags.CFlags,
+				"-D__ANDROID_UNAVAILABLE_SYMBOLS_ARE_WEAK__",
+				"-Werror=unguarded-availability")
+		}
 	}
 
 	if ctx.useVndk() {
@@ -342,6 +518,9 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 
 	if ctx.apexName() != "" {
 		flags.GlobalFlags = append(flags.GlobalFlags, "-D__ANDROID_APEX__="+ctx.apexName())
+		for _, define := range android.ApexDefines(ctx.apexName()) {
+			flags.GlobalFlags = append(flags.GlobalFlags, proptools.NinjaAndShellEscape("-D"+define))
+		}
 	}
 
 	instructionSet := String(compiler.Properties.Instruction_set)
@@ -366,6 +545,8 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 	flags.AsFlags = append(flags.AsFlags, esc(compiler.Properties.Clang_asflags)...)
 	flags.CppFlags = config.ClangFilterUnknownCflags(flags.CppFlags)
 	flags.ConlyFlags = config.ClangFilterUnknownCflags(flags.ConlyFlags)
+	flags.ObjcFlags = config.ClangFilterUnknownCflags(flags.ObjcFlags)
+	flags.ObjcppFlags = config.ClangFilterUnknownCflags(flags.ObjcppFlags)
 	flags.LdFlags = config.ClangFilterUnknownCflags(flags.LdFlags)
 
 	target := "-target " + tc.ClangTriple()
@@ -395,7 +576,12 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 		flags.GlobalFlags = append([]string{"${config.ClangExternalCflags}"}, flags.GlobalFlags...)
 	}
 
-	if tc.Bionic() {
+	if Bool(compiler.Properties.No_rtti) {
+		if Bool(compiler.Properties.Rtti) {
+			ctx.PropertyErrorf("no_rtti", "can't be set with rtti: true")
+		}
+		flags.CppFlags = append(flags.CppFlags, "-fno-rtti")
+	} else if tc.Bionic() {
 		if Bool(compiler.Properties.Rtti) {
 			flags.CppFlags = append(flags.CppFlags, "-frtti")
 		} else {
@@ -458,28 +644,30 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 		flags.CFlags = append(flags.CFlags, "-DANDROID_STRICT")
 	}
 
-	if compiler.hasSrcExt(".proto") {
+	if compiler.hasSrcExt(ctx, ".proto") {
 		flags = protoFlags(ctx, flags, &compiler.Proto)
 	}
 
-	if compiler.hasSrcExt(".y") || compiler.hasSrcExt(".yy") {
+	if compiler.hasSrcExt(ctx, ".y") || compiler.hasSrcExt(ctx, ".yy") {
 		flags.GlobalFlags = append(flags.GlobalFlags,
 			"-I"+android.PathForModuleGen(ctx, "yacc", ctx.ModuleDir()).String())
 	}
 
-	if compiler.hasSrcExt(".mc") {
+	if compiler.hasSrcExt(ctx, ".mc") {
 		flags.GlobalFlags = append(flags.GlobalFlags,
 			"-I"+android.PathForModuleGen(ctx, "windmc", ctx.ModuleDir()).String())
 	}
 
-	if compiler.hasSrcExt(".aidl") {
+	if compiler.hasSrcExt(ctx, ".aidl") {
 		if len(compiler.Properties.Aidl.Local_include_dirs) > 0 {
 			localAidlIncludeDirs := android.PathsForModuleSrc(ctx, compiler.Properties.Aidl.Local_include_dirs)
 			flags.aidlFlags = append(flags.aidlFlags, includeDirsToFlags(localAidlIncludeDirs))
+			compiler.aidlIncludeDirs = append(compiler.aidlIncludeDirs, localAidlIncludeDirs...)
 		}
 		if len(compiler.Properties.Aidl.Include_dirs) > 0 {
 			rootAidlIncludeDirs := android.PathsForSource(ctx, compiler.Properties.Aidl.Include_dirs)
 			flags.aidlFlags = append(flags.aidlFlags, includeDirsToFlags(rootAidlIncludeDirs))
+			compiler.aidlIncludeDirs = append(compiler.aidlIncludeDirs, rootAidlIncludeDirs...)
 		}
 
 		if Bool(compiler.Properties.Aidl.Generate_traces) {
@@ -490,11 +678,11 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 			"-I"+android.PathForModuleGen(ctx, "aidl").String())
 	}
 
-	if compiler.hasSrcExt(".rs") || compiler.hasSrcExt(".fs") {
+	if compiler.hasSrcExt(ctx, ".rs") || compiler.hasSrcExt(ctx, ".fs") {
 		flags = rsFlags(ctx, flags, &compiler.Properties)
 	}
 
-	if compiler.hasSrcExt(".sysprop") {
+	if compiler.hasSrcExt(ctx, ".sysprop") {
 		flags.GlobalFlags = append(flags.GlobalFlags,
 			"-I"+android.PathForModuleGen(ctx, "sysprop", "include").String())
 	}
@@ -520,24 +708,24 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 	return flags
 }
 
-func (compiler *baseCompiler) hasSrcExt(ext string) bool {
+func (compiler *baseCompiler) hasSrcExt(ctx android.BaseModuleContext, ext string) bool {
 	for _, src := range compiler.srcsBeforeGen {
 		if src.Ext() == ext {
 			return true
 		}
 	}
-	for _, src := range compiler.Properties.Srcs {
-		if filepath.Ext(src) == ext {
-			return true
-		}
-	}
-	for _, src := range compiler.Properties.OriginalSrcs {
-		if filepath.Ext(src) == ext {
-			return true
+	// srcsBeforeGen is not set before compilerFlags, so expand srcs the same way it will be, once
+	// for all the extensions.
+	if compiler.srcExts == nil {
+		compiler.srcExts = make(map[string]bool)
+		for _, srcs := range [][]string{compiler.Properties.Srcs, compiler.Properties.OriginalSrcs} {
+			for _, src := range android.ModuleSrcFilesExcludes(ctx, srcs, compiler.Properties.Exclude_srcs) {
+				compiler.srcExts[filepath.Ext(src)] = true
+			}
 		}
 	}
 
-	return false
+	return compiler.srcExts[ext]
 }
 
 var gnuToCReplacer = strings.NewReplacer("gnu", "c")
@@ -575,6 +763,18 @@ func (compiler *baseCompiler) compile(ctx ModuleContext, flags Flags, deps PathD
 		return Objects{}
 	}
 
+	if Bool(compiler.Properties.No_rtti) && len(objs.objFiles) > 0 {
+		noRttiCheck := android.PathForModuleOut(ctx, "no_rtti.check")
+		TransformObjsToNoRttiCheck(ctx, objs.objFiles, buildFlags, noRttiCheck)
+		objs.checkFiles = append(objs.checkFiles, noRttiCheck)
+	}
+
+	if flags.WarningBaseline.Valid() && len(objs.warningFiles) > 0 {
+		warningsCheck := android.PathForModuleOut(ctx, "warnings.check")
+		TransformWarningsToBaselineCheck(ctx, objs.warningFiles, flags.WarningBaseline.Path(), warningsCheck)
+		objs.checkFiles = append(objs.checkFiles, warningsCheck)
+	}
+
 	return objs
 }
 
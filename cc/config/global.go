@@ -15,6 +15,8 @@
 package config
 
 import (
+	"regexp"
+	"strconv"
 	"strings"
 
 	"android/soong/android"
@@ -195,10 +197,7 @@ func init() {
 		return "${ClangDefaultBase}"
 	})
 	pctx.VariableFunc("ClangVersion", func(ctx android.PackageVarContext) string {
-		if override := ctx.Config().Getenv("LLVM_PREBUILTS_VERSION"); override != "" {
-			return override
-		}
-		return ClangDefaultVersion
+		return ClangPrebuiltsVersion(ctx.Config())
 	})
 	pctx.StaticVariable("ClangPath", "${ClangBase}/${HostPrebuiltTag}/${ClangVersion}")
 	pctx.StaticVariable("ClangBin", "${ClangPath}/bin")
@@ -234,6 +233,38 @@ func init() {
 	})
 }
 
+// The first clang svn revision that provides each compiler feature or builtin that a module can
+// list in required_clang_features. The release versions aren't precise enough, as the prebuilt
+// clang snapshots are taken between releases.
+var ClangFeatureRevisions = map[string]int{
+	"-ftrivial-auto-var-init":         349442,
+	"__builtin_is_constant_evaluated": 359067,
+	"asm_goto":                        362045,
+	"__builtin_bit_cast":              364954,
+	"__builtin_preserve_access_index": 365438,
+}
+
+// Returns the version of the prebuilt clang that is used to build, e.g. "clang-r353983c".
+func ClangPrebuiltsVersion(config android.Config) string {
+	if override := config.Getenv("LLVM_PREBUILTS_VERSION"); override != "" {
+		return override
+	}
+	return ClangDefaultVersion
+}
+
+var clangRevisionRegexp = regexp.MustCompile(`^clang-r([0-9]+)`)
+
+// Returns the svn revision that the prebuilt clang was built from, e.g. 353983 for
+// "clang-r353983c", or false if the prebuilts version doesn't name one.
+func ClangSvnRevision(config android.Config) (int, bool) {
+	match := clangRevisionRegexp.FindStringSubmatch(ClangPrebuiltsVersion(config))
+	if match == nil {
+		return 0, false
+	}
+	revision, err := strconv.Atoi(match[1])
+	return revision, err == nil
+}
+
 var HostPrebuiltTag = pctx.VariableConfigMethod("HostPrebuiltTag", android.Config.PrebuiltOS)
 
 func bionicHeaders(kernelArch string) string {
@@ -0,0 +1,222 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+// This singleton generates a Graphviz DOT file of the shared, static and header library
+// dependencies between all cc modules, which helps to find out why a library is pulled into many
+// binaries. It is only generated when SOONG_GEN_CC_DEP_GRAPH is set, e.g.
+// make SOONG_GEN_CC_DEP_GRAPH=1 nothing, and written to out/soong/cc_dep_graph.dot.
+//
+// The cc_whydep singleton answers the narrower question of why a module depends on a library by
+// writing the shortest dependency path between them to out/soong/cc_whydep.txt, e.g. with
+// make SOONG_CC_WHYDEP=libfoo:libbar nothing.
+
+func init() {
+	android.RegisterSingletonType("cc_dep_graph", ccDepGraphSingleton)
+	android.RegisterSingletonType("cc_whydep", ccWhyDepSingleton)
+}
+
+var (
+	// Writes each of the given shell escaped lines to the output.
+	writeLines = pctx.AndroidStaticRule("writeLines",
+		blueprint.RuleParams{
+			Command: "printf '%s\\n' $lines > $out",
+		},
+		"lines")
+)
+
+func ccDepGraphSingleton() android.Singleton {
+	return &ccDepGraphSingletonType{}
+}
+
+type ccDepGraphSingletonType struct{}
+
+const (
+	ccDepGraphFilename = "cc_dep_graph.dot"
+
+	// Environment variable used to enable this singleton.
+	envVariableGenerateCcDepGraph = "SOONG_GEN_CC_DEP_GRAPH"
+
+	ccWhyDepFilename = "cc_whydep.txt"
+
+	// Environment variable holding the "<module>:<dependency>" pair to find the path between.
+	envVariableCcWhyDep = "SOONG_CC_WHYDEP"
+)
+
+// The DOT edge style of each kind of library dependency returned by ccDepKind.
+var ccDepGraphEdgeStyles = map[string]string{
+	"shared":       "solid",
+	"static":       "dashed",
+	"whole_static": "bold",
+	"header":       "dotted",
+}
+
+func (s *ccDepGraphSingletonType) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue(envVariableGenerateCcDepGraph) {
+		return
+	}
+
+	// The variants of a module are merged into a single node, so that an edge is listed once
+	// however many variants have it. The edges go to the dependencies resolved by depsToPaths, so
+	// that every node is the name of a module.
+	edges := make(map[string]bool)
+	ctx.VisitAllModules(func(module android.Module) {
+		if ccModule, ok := module.(*Module); ok && ccModule.Enabled() {
+			from := ctx.ModuleName(ccModule)
+			for to, kind := range ccModule.resolvedDepKinds {
+				edges[fmt.Sprintf("%q -> %q [style=%s];", from, to, ccDepGraphEdgeStyles[kind])] = true
+			}
+		}
+	})
+
+	// iterating over map does not give consistent ordering in golang
+	var lines []string
+	for edge := range edges {
+		lines = append(lines, edge)
+	}
+	sort.Strings(lines)
+
+	var content strings.Builder
+	content.WriteString("digraph cc_deps {\n")
+	for _, line := range lines {
+		content.WriteString("  " + line + "\n")
+	}
+	content.WriteString("}\n")
+
+	// The graph of a whole tree is too large for a command line, so it is written directly
+	// instead of by a build rule.
+	path := android.PathForOutput(ctx, ccDepGraphFilename).String()
+	os.MkdirAll(filepath.Dir(path), 0777)
+	if err := ioutil.WriteFile(path, []byte(content.String()), 0666); err != nil {
+		ctx.Errorf("Could not write file %s: %s", path, err)
+	}
+}
+
+func ccWhyDepSingleton() android.Singleton {
+	return &ccWhyDepSingletonType{}
+}
+
+type ccWhyDepSingletonType struct{}
+
+func (s *ccWhyDepSingletonType) GenerateBuildActions(ctx android.SingletonContext) {
+	query := ctx.Config().Getenv(envVariableCcWhyDep)
+	if query == "" {
+		return
+	}
+	split := strings.Split(query, ":")
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		ctx.Errorf("%s must be <module>:<dependency>, got %q", envVariableCcWhyDep, query)
+		return
+	}
+	from, to := split[0], split[1]
+
+	// The kind of the edges from each module to the dependencies resolved by depsToPaths, with the
+	// variants of a module merged like in the dependency graph.
+	edges := make(map[string]map[string]string)
+	ctx.VisitAllModules(func(module android.Module) {
+		if ccModule, ok := module.(*Module); ok && ccModule.Enabled() {
+			name := ctx.ModuleName(ccModule)
+			if edges[name] == nil {
+				edges[name] = make(map[string]string)
+			}
+			for dep, kind := range ccModule.resolvedDepKinds {
+				if _, exists := edges[name][dep]; !exists {
+					edges[name][dep] = kind
+				}
+			}
+		}
+	})
+
+	var lines []string
+	if path := ccWhyDepPath(edges, from, to); path != nil {
+		lines = append(lines, from)
+		for i := 1; i < len(path); i++ {
+			lines = append(lines, fmt.Sprintf("    -> [%s] %s", edges[path[i-1]][path[i]], path[i]))
+		}
+	} else {
+		lines = append(lines, fmt.Sprintf("%s does not depend on %s", from, to))
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        writeLines,
+		Description: ccWhyDepFilename,
+		Output:      android.PathForOutput(ctx, ccWhyDepFilename),
+		Args: map[string]string{
+			"lines": strings.Join(proptools.NinjaAndShellEscapeList(lines), " "),
+		},
+	})
+}
+
+// ccDepKind returns the kind of library dependency of a dependency tag, or an empty string if the
+// tag is not a library dependency.
+func ccDepKind(tag blueprint.DependencyTag) string {
+	switch tag {
+	case sharedDepTag, sharedExportDepTag, earlySharedDepTag, lateSharedDepTag, ndkStubDepTag, ndkLateStubDepTag:
+		return "shared"
+	case staticDepTag, staticExportDepTag, lateStaticDepTag:
+		return "static"
+	case wholeStaticDepTag:
+		return "whole_static"
+	case headerDepTag, headerExportDepTag:
+		return "header"
+	}
+	return ""
+}
+
+// ccWhyDepPath returns the names of the modules on the shortest path from one module to another in
+// the given dependency edges, including both ends, or nil if there is no path.
+func ccWhyDepPath(edges map[string]map[string]string, from, to string) []string {
+	parents := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == to {
+			var path []string
+			for ; name != from; name = parents[name] {
+				path = append([]string{name}, path...)
+			}
+			return append([]string{from}, path...)
+		}
+
+		// iterating over map does not give consistent ordering in golang
+		var deps []string
+		for dep := range edges[name] {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, visited := parents[dep]; !visited {
+				parents[dep] = name
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
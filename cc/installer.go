@@ -25,6 +25,11 @@ import (
 type InstallerProperties struct {
 	// install to a subdirectory of the default install path for the module
 	Relative_install_path *string `android:"arch_variant"`
+
+	// install to this partition instead of the one that follows from vendor, device_specific,
+	// product_specific or product_services_specific. Can be "system", "vendor", "odm",
+	// "product" or "product_services".
+	Install_partition *string
 }
 
 type installLocation int
@@ -62,6 +67,9 @@ func (installer *baseInstaller) installerProps() []interface{} {
 }
 
 func (installer *baseInstaller) installDir(ctx ModuleContext) android.OutputPath {
+	if p := installer.installPartition(); p != "" && !inList(p, android.InstallPartitions) {
+		ctx.PropertyErrorf("install_partition", "%q is not a partition, must be one of %q", p, android.InstallPartitions)
+	}
 	dir := installer.dir
 	if ctx.toolchain().Is64Bit() && installer.dir64 != "" {
 		dir = installer.dir64
@@ -80,6 +88,14 @@ func (installer *baseInstaller) install(ctx ModuleContext, file android.Path) {
 	installer.path = ctx.InstallFile(installer.installDir(ctx), file.Base(), file)
 }
 
+// installedFile returns the installed output file of the module, if it was installed.
+func (installer *baseInstaller) installedFile() android.OptionalPath {
+	if installer.path == (android.OutputPath{}) {
+		return android.OptionalPath{}
+	}
+	return android.OptionalPathForPath(installer.path)
+}
+
 func (installer *baseInstaller) inData() bool {
 	return installer.location == InstallInData
 }
@@ -95,3 +111,7 @@ func (installer *baseInstaller) hostToolPath() android.OptionalPath {
 func (installer *baseInstaller) relativeInstallPath() string {
 	return String(installer.Properties.Relative_install_path)
 }
+
+func (installer *baseInstaller) installPartition() string {
+	return String(installer.Properties.Install_partition)
+}
@@ -17,7 +17,6 @@ package cc
 import (
 	"path/filepath"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -57,6 +56,19 @@ type LibraryProperties struct {
 	// rename host libraries to prevent overlap with system installed libraries
 	Unique_host_soname *bool
 
+	// the DT_SONAME of the shared library, when it must differ from the name of the output
+	// file, e.g. to replace a library whose consumers expect another name. Must end in ".so".
+	Soname *string
+
+	// if set, emit a list of the weak undefined symbols in the dynamic symbol table of the
+	// shared library. These are the symbols that the library optionally resolves at runtime.
+	Weak_undefined_symbols_list *bool
+
+	// if set, the static library is additionally built without -fPIC into a lib<name>_nopic.a
+	// archive, for consumers that cannot use position independent code. Objects from
+	// whole_static_libs are not included in it.
+	Build_nonpic_variant *bool
+
 	Aidl struct {
 		// export headers generated from .aidl sources
 		Export_aidl_headers *bool
@@ -105,6 +117,25 @@ type LibraryProperties struct {
 		// Symbol tags that should be ignored from the symbol file
 		Exclude_symbol_tags []string
 	}
+
+	// Whether the mangled names of the C++ ABI of this library must not change, e.g. because of a
+	// change of inline namespace or of template arguments. This is checked against the reference
+	// ABI dump in addition to the regular ABI diff.
+	Stable_cxx_abi *bool
+
+	// Only valid on cc_library_headers. Export the include directories of this library with
+	// -isystem instead of -I, so that consumers must include its headers with angle brackets and
+	// do not get warnings from them. Intended for third-party headers.
+	System_header_lib *bool
+
+	// Check that each header in the exported include directories compiles on its own both as C
+	// and as C++, for headers meant to be included from both languages.
+	Dual_language_headers *bool
+
+	// Other names, e.g. the old name of a renamed library, that shared_libs, static_libs and
+	// header_libs can refer to this library by while their users move to its new name. Using
+	// an alias is deprecated.
+	Aliases []string
 }
 
 type LibraryMutatedProperties struct {
@@ -132,6 +163,11 @@ type FlagExporterProperties struct {
 	// listed in local_include_dirs.
 	Export_include_dirs []string `android:"arch_variant"`
 
+	// list of directories relative to the Blueprints file that export_include_dirs may
+	// contain. Exporting any other directory is an error, which keeps internal headers from
+	// being exposed by accident.
+	Export_include_dirs_allowlist []string `android:"arch_variant"`
+
 	Target struct {
 		Vendor struct {
 			// list of exported include directories, like
@@ -205,6 +241,9 @@ type flagExporter struct {
 
 	flags     []string
 	flagsDeps android.Paths
+
+	// macros defined by the -D and -U flags in flags
+	defines map[string]string
 }
 
 func (f *flagExporter) exportedIncludes(ctx ModuleContext) android.Paths {
@@ -215,7 +254,32 @@ func (f *flagExporter) exportedIncludes(ctx ModuleContext) android.Paths {
 	}
 }
 
+// checkExportedIncludesAllowlist reports the exported include directories that are not listed in
+// export_include_dirs_allowlist, if it is set.
+func (f *flagExporter) checkExportedIncludesAllowlist(ctx ModuleContext) {
+	if f.Properties.Export_include_dirs_allowlist == nil {
+		return
+	}
+	allowlist := make(map[string]bool)
+	for _, dir := range f.Properties.Export_include_dirs_allowlist {
+		allowlist[filepath.Clean(dir)] = true
+	}
+	dirs, property := f.Properties.Export_include_dirs, "export_include_dirs"
+	if ctx.useVndk() && f.Properties.Target.Vendor.Override_export_include_dirs != nil {
+		dirs, property = f.Properties.Target.Vendor.Override_export_include_dirs, "target.vendor.override_export_include_dirs"
+	}
+	reported := make(map[string]bool)
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if !allowlist[dir] && !reported[dir] {
+			ctx.PropertyErrorf(property, "%q is not listed in export_include_dirs_allowlist", dir)
+			reported[dir] = true
+		}
+	}
+}
+
 func (f *flagExporter) exportIncludes(ctx ModuleContext, inc string) {
+	f.checkExportedIncludesAllowlist(ctx)
 	includeDirs := f.exportedIncludes(ctx)
 	for _, dir := range includeDirs.Strings() {
 		f.flags = append(f.flags, inc+dir)
@@ -224,6 +288,39 @@ func (f *flagExporter) exportIncludes(ctx ModuleContext, inc string) {
 
 func (f *flagExporter) reexportFlags(flags []string) {
 	f.flags = append(f.flags, flags...)
+	f.reexportDefines(flags)
+}
+
+// reexportDefines records the macros defined by the -D flags in flags, which may each contain
+// several space separated flags. A macro defined without a value is defined to "1", like the
+// compiler does, and a macro named in a later -U flag is removed.
+func (f *flagExporter) reexportDefines(flags []string) {
+	var tokens []string
+	for _, flag := range flags {
+		tokens = append(tokens, strings.Fields(flag)...)
+	}
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token == "-D" || token == "-U" {
+			if i+1 == len(tokens) {
+				break
+			}
+			i++
+			token += tokens[i]
+		}
+		if strings.HasPrefix(token, "-D") {
+			name, value := token[2:], "1"
+			if eq := strings.Index(name, "="); eq >= 0 {
+				name, value = name[:eq], name[eq+1:]
+			}
+			if f.defines == nil {
+				f.defines = make(map[string]string)
+			}
+			f.defines[name] = value
+		} else if strings.HasPrefix(token, "-U") {
+			delete(f.defines, token[2:])
+		}
+	}
 }
 
 func (f *flagExporter) reexportDeps(deps android.Paths) {
@@ -238,9 +335,14 @@ func (f *flagExporter) exportedFlagsDeps() android.Paths {
 	return f.flagsDeps
 }
 
+func (f *flagExporter) exportedDefines() map[string]string {
+	return f.defines
+}
+
 type exportedFlagsProducer interface {
 	exportedFlags() []string
 	exportedFlagsDeps() android.Paths
+	exportedDefines() map[string]string
 }
 
 var _ exportedFlagsProducer = (*flagExporter)(nil)
@@ -278,12 +380,19 @@ type libraryDecorator struct {
 	// Output archive of gcno coverage information files
 	coverageOutputFile android.OptionalPath
 
+	// Objects compiled without -fPIC, and the archive of them, for build_nonpic_variant
+	nonPicObjects    Objects
+	nonPicOutputFile android.OptionalPath
+
 	// linked Source Abi Dump
 	sAbiOutputFile android.OptionalPath
 
 	// Source Abi Diff
 	sAbiDiff android.OptionalPath
 
+	// Output of the check that the mangled names of the ABI didn't change
+	sAbiMangledNamesCheck android.OptionalPath
+
 	// Location of the static library in the sysroot. Empty if the library is
 	// not included in the NDK.
 	ndkSysrootPath android.Path
@@ -294,6 +403,9 @@ type libraryDecorator struct {
 	// Location of the file that should be copied to dist dir when requested
 	distFile android.OptionalPath
 
+	// List of the weak undefined dynamic symbols of the shared library
+	weakUndefinedSymbolsFile android.OptionalPath
+
 	versionScriptPath android.ModuleGenPath
 
 	post_install_cmds []string
@@ -335,7 +447,13 @@ func (library *libraryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Fla
 	}
 
 	if library.shared() {
-		libName := library.getLibName(ctx)
+		soname := library.getLibName(ctx) + flags.Toolchain.ShlibSuffix()
+		if library.Properties.Soname != nil {
+			soname = String(library.Properties.Soname)
+			if !strings.HasSuffix(soname, ".so") {
+				ctx.PropertyErrorf("soname", "%q must end in .so", soname)
+			}
+		}
 		var f []string
 		if ctx.toolchain().Bionic() {
 			f = append(f,
@@ -348,7 +466,7 @@ func (library *libraryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Fla
 			f = append(f,
 				"-dynamiclib",
 				"-single_module",
-				"-install_name @rpath/"+libName+flags.Toolchain.ShlibSuffix(),
+				"-install_name @rpath/"+soname,
 			)
 			if ctx.Arch().ArchType == android.X86 {
 				f = append(f,
@@ -358,7 +476,7 @@ func (library *libraryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Fla
 		} else {
 			f = append(f,
 				"-shared",
-				"-Wl,-soname,"+libName+flags.Toolchain.ShlibSuffix())
+				"-Wl,-soname,"+soname)
 		}
 
 		flags.LdFlags = append(f, flags.LdFlags...)
@@ -462,6 +580,11 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		srcs := android.PathsForModuleSrc(ctx, library.Properties.Static.Srcs)
 		objs = objs.Append(compileObjs(ctx, buildFlags, android.DeviceStaticLibrary,
 			srcs, library.baseCompiler.pathDeps, library.baseCompiler.cFlagsDeps))
+
+		if library.buildsNonPicVariant() {
+			nonPicSrcs := append(android.Paths(nil), library.baseCompiler.srcs...)
+			library.nonPicObjects = library.compileNonPic(ctx, flags, append(nonPicSrcs, srcs...))
+		}
 	} else if library.shared() {
 		srcs := android.PathsForModuleSrc(ctx, library.Properties.Shared.Srcs)
 		objs = objs.Append(compileObjs(ctx, buildFlags, android.DeviceSharedLibrary,
@@ -471,6 +594,34 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 	return objs
 }
 
+// Returns true if the static library is also built without -fPIC, see build_nonpic_variant.
+func (library *libraryDecorator) buildsNonPicVariant() bool {
+	return library.static() && Bool(library.Properties.Build_nonpic_variant)
+}
+
+// Compiles the sources of the static library again without -fPIC, for build_nonpic_variant.
+func (library *libraryDecorator) compileNonPic(ctx ModuleContext, flags Flags, srcs android.Paths) Objects {
+	// The cflags of the module, which include -fPIC, are in the $cflags variable, so define
+	// another one without it for the non-PIC objects.
+	nonPicCFlags, _ := filterList(ctx.Module().(*Module).flags.CFlags, []string{"-fPIC"})
+	ctx.Variable(pctx, "nopiccflags", strings.Join(nonPicCFlags, " "))
+	cflags := []string{}
+	for _, flag := range flags.CFlags {
+		if flag == "$cflags" {
+			cflags = append(cflags, "$nopiccflags")
+		} else if flag != "-fPIC" {
+			cflags = append(cflags, flag)
+		}
+	}
+	flags.CFlags = append(cflags, "-fno-pic")
+	buildFlags := flagsToBuilderFlags(flags)
+	// The objects are the same code as the PIC ones, so they don't need to be checked again.
+	buildFlags.tidy = false
+	buildFlags.sAbiDump = false
+	buildFlags.coverage = false
+	return compileObjs(ctx, buildFlags, "nopic", srcs, library.baseCompiler.pathDeps, library.baseCompiler.cFlagsDeps)
+}
+
 type libraryInterface interface {
 	getWholeStaticMissingDeps() []string
 	static() bool
@@ -627,7 +778,8 @@ func (library *libraryDecorator) linkStatic(ctx ModuleContext,
 		}
 	}
 
-	TransformObjToStaticLib(ctx, library.objects.objFiles, builderFlags, outputFile, objs.tidyFiles)
+	TransformObjToStaticLib(ctx, library.objects.objFiles, builderFlags, outputFile,
+		append(objs.tidyFiles, objs.checkFiles...))
 
 	library.coverageOutputFile = TransformCoverageFilesToLib(ctx, library.objects, builderFlags,
 		ctx.ModuleName()+library.MutatedProperties.VariantName)
@@ -636,6 +788,13 @@ func (library *libraryDecorator) linkStatic(ctx ModuleContext,
 
 	ctx.CheckbuildFile(outputFile)
 
+	if Bool(library.Properties.Build_nonpic_variant) {
+		nonPicOutputFile := android.PathForModuleOut(ctx, ctx.ModuleName()+library.MutatedProperties.VariantName+"_nopic"+staticLibraryExtension)
+		TransformObjToStaticLib(ctx, library.nonPicObjects.objFiles, builderFlags, nonPicOutputFile, nil)
+		library.nonPicOutputFile = android.OptionalPathForPath(nonPicOutputFile)
+		ctx.CheckbuildFile(nonPicOutputFile)
+	}
+
 	return outputFile
 }
 
@@ -703,6 +862,25 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 
 	library.unstrippedOutputFile = outputFile
 
+	// lld supports --no-undefined-version, so the version script is expected to only name
+	// exported symbols.
+	if versionScript := library.baseLinker.versionScript; versionScript.Valid() && library.baseLinker.useClangLld(ctx) {
+		versionScriptCheck := android.PathForModuleOut(ctx, fileName+".version_script.check")
+		TransformTocToVersionScriptCheck(ctx, tocFile, versionScript.Path(), versionScriptCheck)
+		ctx.CheckbuildFile(versionScriptCheck)
+	}
+
+	if Bool(library.Properties.Weak_undefined_symbols_list) {
+		if ctx.Darwin() || ctx.Windows() {
+			ctx.PropertyErrorf("weak_undefined_symbols_list", "Only supported for ELF targets")
+		} else {
+			weakUndefinedSymbolsFile := android.PathForModuleOut(ctx, fileName+".weak_undefined.txt")
+			TransformSharedObjectToWeakUndefinedSymbols(ctx, outputFile, weakUndefinedSymbolsFile, builderFlags)
+			library.weakUndefinedSymbolsFile = android.OptionalPathForPath(weakUndefinedSymbolsFile)
+			ctx.CheckbuildFile(weakUndefinedSymbolsFile)
+		}
+	}
+
 	if Bool(library.baseLinker.Properties.Use_version_lib) {
 		if ctx.Host() {
 			versionedOutputFile := outputFile
@@ -730,10 +908,13 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, deps.SharedLibsDeps...)
 	linkerDeps = append(linkerDeps, deps.LateSharedLibsDeps...)
 	linkerDeps = append(linkerDeps, objs.tidyFiles...)
+	linkerDeps = append(linkerDeps, objs.checkFiles...)
+
+	linkMapOutputs := library.linkMapOutputs(ctx, fileName, &builderFlags)
 
 	TransformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs,
 		deps.StaticLibs, deps.LateStaticLibs, deps.WholeStaticLibs,
-		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile)
+		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, linkMapOutputs)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
@@ -804,14 +985,36 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objec
 		if refAbiDumpFile != nil {
 			library.sAbiDiff = SourceAbiDiff(ctx, library.sAbiOutputFile.Path(),
 				refAbiDumpFile, fileName, exportedHeaderFlags, ctx.isLlndk(), ctx.isVndkExt())
+			if Bool(library.Properties.Stable_cxx_abi) {
+				library.sAbiMangledNamesCheck = android.OptionalPathForPath(SourceAbiMangledNamesCheck(ctx,
+					library.sAbiOutputFile.Path(), refAbiDumpFile, fileName))
+			}
 		}
 	}
 }
 
+// checkDualLanguageHeaders generates rules that compile each header in the exported include
+// directories of the library as C and as C++, and returns the outputs of the checks.
+func (library *libraryDecorator) checkDualLanguageHeaders(ctx ModuleContext, flags Flags) android.Paths {
+	var headers android.Paths
+	var includeFlags []string
+	for _, dir := range library.flagExporter.exportedIncludes(ctx) {
+		headers = append(headers, ctx.GlobFiles(filepath.Join(dir.String(), "**/*.h"), nil)...)
+		includeFlags = append(includeFlags, "-I"+dir.String())
+	}
+	return TransformHeadersToDualLanguageChecks(ctx, headers, flagsToBuilderFlags(flags),
+		strings.Join(includeFlags, " "))
+}
+
 func (library *libraryDecorator) link(ctx ModuleContext,
 	flags Flags, deps PathDeps, objs Objects) android.Path {
 
 	objs = deps.Objs.Copy().Append(objs)
+	if Bool(library.Properties.Dual_language_headers) {
+		// The library is only built once its exported headers compile both as C and as C++.
+		objs.checkFiles = append(objs.checkFiles, library.checkDualLanguageHeaders(ctx, flags)...)
+	}
+
 	var out android.Path
 	if library.static() || library.header() {
 		out = library.linkStatic(ctx, flags, deps, objs)
@@ -819,12 +1022,19 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 		out = library.linkShared(ctx, flags, deps, objs)
 	}
 
-	library.exportIncludes(ctx, "-I")
+	if Bool(library.Properties.System_header_lib) {
+		if !library.header() {
+			ctx.PropertyErrorf("system_header_lib", "is only supported on cc_library_headers")
+		}
+		library.exportIncludes(ctx, "-isystem ")
+	} else {
+		library.exportIncludes(ctx, "-I")
+	}
 	library.reexportFlags(deps.ReexportedFlags)
 	library.reexportDeps(deps.ReexportedFlagsDeps)
 
 	if Bool(library.Properties.Aidl.Export_aidl_headers) {
-		if library.baseCompiler.hasSrcExt(".aidl") {
+		if library.baseCompiler.hasSrcExt(ctx, ".aidl") {
 			flags := []string{
 				"-I" + android.PathForModuleGen(ctx, "aidl").String(),
 			}
@@ -836,7 +1046,7 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 	}
 
 	if Bool(library.Properties.Proto.Export_proto_headers) {
-		if library.baseCompiler.hasSrcExt(".proto") {
+		if library.baseCompiler.hasSrcExt(ctx, ".proto") {
 			includes := []string{}
 			if flags.proto.CanonicalPathFromRoot {
 				includes = append(includes, "-I"+flags.proto.SubDir.String())
@@ -849,7 +1059,7 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 		}
 	}
 
-	if library.baseCompiler.hasSrcExt(".sysprop") {
+	if library.baseCompiler.hasSrcExt(ctx, ".sysprop") {
 		internalFlags := []string{
 			"-I" + android.PathForModuleGen(ctx, "sysprop", "include").String(),
 		}
@@ -1082,6 +1292,10 @@ func reuseStaticLibrary(mctx android.BottomUpMutatorContext, static, shared *Mod
 
 func LinkageMutator(mctx android.BottomUpMutatorContext) {
 	if m, ok := mctx.Module().(*Module); ok && m.linker != nil {
+		if library, ok := m.linker.(*libraryDecorator); ok {
+			addLibraryAliases(mctx, library.Properties.Aliases)
+		}
+
 		switch library := m.linker.(type) {
 		case prebuiltLibraryInterface:
 			// Always create both the static and shared variants for prebuilt libraries, and then disable the one
@@ -1115,6 +1329,7 @@ func LinkageMutator(mctx android.BottomUpMutatorContext) {
 			} else if library.buildStatic() {
 				modules := mctx.CreateLocalVariations("static")
 				modules[0].(*Module).linker.(libraryInterface).setStatic()
+				addStaticOnlyLibrary(mctx, m)
 			} else if library.buildShared() {
 				modules := mctx.CreateLocalVariations("shared")
 				modules[0].(*Module).linker.(libraryInterface).setShared()
@@ -1123,6 +1338,44 @@ func LinkageMutator(mctx android.BottomUpMutatorContext) {
 	}
 }
 
+var staticOnlyLibsKey = android.NewOnceKey("staticOnlyLibs")
+
+// maps the variant key of a source library that is only built static to true, so that listing it
+// in shared_libs can be reported before the missing shared variant is
+func staticOnlyLibs(config android.Config) map[string]bool {
+	return config.Once(staticOnlyLibsKey, func() interface{} {
+		return make(map[string]bool)
+	}).(map[string]bool)
+}
+
+var staticOnlyLibsLock sync.Mutex
+
+// staticOnlyLibKey returns the key of the library name for the os, arch and image of m, which a
+// shared_libs dependency of m resolves to.
+func staticOnlyLibKey(name string, m *Module) string {
+	image := coreMode
+	if m.useVndk() {
+		image = vendorMode
+	} else if m.inRecovery() {
+		image = recoveryMode
+	}
+	return name + "/" + m.Target().String() + "/" + image
+}
+
+func addStaticOnlyLibrary(mctx android.BottomUpMutatorContext, m *Module) {
+	staticOnlyLibsLock.Lock()
+	defer staticOnlyLibsLock.Unlock()
+
+	staticOnlyLibs(mctx.Config())[staticOnlyLibKey(mctx.ModuleName(), m)] = true
+}
+
+func isStaticOnlyLibrary(config android.Config, name string, m *Module) bool {
+	staticOnlyLibsLock.Lock()
+	defer staticOnlyLibsLock.Unlock()
+
+	return staticOnlyLibs(config)[staticOnlyLibKey(name, m)]
+}
+
 var stubVersionsKey = android.NewOnceKey("stubVersions")
 
 // maps a module name to the list of stubs versions available for the module
@@ -1143,24 +1396,68 @@ func latestStubsVersionFor(config android.Config, name string) string {
 	return ""
 }
 
+var libraryAliasesKey = android.NewOnceKey("libraryAliases")
+
+// maps an alias of a library to the name of the library, to resolve the aliases listed in the
+// dependencies of other modules
+func libraryAliases(config android.Config) map[string]string {
+	return config.Once(libraryAliasesKey, func() interface{} {
+		return make(map[string]string)
+	}).(map[string]string)
+}
+
+var libraryAliasesLock sync.Mutex
+
+func addLibraryAliases(mctx android.BottomUpMutatorContext, aliases []string) {
+	libraryAliasesLock.Lock()
+	defer libraryAliasesLock.Unlock()
+
+	name := mctx.ModuleName()
+	for _, alias := range aliases {
+		if mctx.OtherModuleExists(alias) {
+			mctx.PropertyErrorf("aliases", "%q is the name of an existing module", alias)
+		} else if lib, ok := libraryAliases(mctx.Config())[alias]; ok && lib != name {
+			mctx.PropertyErrorf("aliases", "%q is already an alias of %q", alias, lib)
+		} else {
+			libraryAliases(mctx.Config())[alias] = name
+		}
+	}
+}
+
 // Version mutator splits a module into the mandatory non-stubs variant
 // (which is unnamed) and zero or more stubs variants.
 func VersionMutator(mctx android.BottomUpMutatorContext) {
 	if m, ok := mctx.Module().(*Module); ok && !m.inRecovery() && m.linker != nil {
 		if library, ok := m.linker.(*libraryDecorator); ok && library.buildShared() &&
 			len(library.Properties.Stubs.Versions) > 0 {
+			// The versions must be listed in strictly increasing order, which the latest
+			// stubs version and the variant selection rely on.
 			versions := []string{}
+			valid := true
+			prev := ""
 			for _, v := range library.Properties.Stubs.Versions {
 				if _, err := strconv.Atoi(v); err != nil {
 					mctx.PropertyErrorf("versions", "%q is not a number", v)
+					valid = false
+					continue
 				}
+				if prev != "" {
+					left, _ := strconv.Atoi(prev)
+					right, _ := strconv.Atoi(v)
+					if right == left {
+						mctx.PropertyErrorf("versions", "%q is listed more than once", v)
+						valid = false
+					} else if right < left {
+						mctx.PropertyErrorf("versions", "%q is listed after %q, versions must be in increasing order", v, prev)
+						valid = false
+					}
+				}
+				prev = v
 				versions = append(versions, v)
 			}
-			sort.Slice(versions, func(i, j int) bool {
-				left, _ := strconv.Atoi(versions[i])
-				right, _ := strconv.Atoi(versions[j])
-				return left < right
-			})
+			if !valid {
+				return
+			}
 
 			// save the list of versions for later use
 			copiedVersions := make([]string, len(versions))
@@ -15,8 +15,15 @@
 package cc
 
 import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"android/soong/android"
 )
 
 func TestLibraryReuse(t *testing.T) {
@@ -183,3 +190,262 @@ func TestLibraryReuse(t *testing.T) {
 		}
 	})
 }
+
+func TestLibraryWeakUndefinedSymbolsList(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			weak_undefined_symbols_list: true,
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared")
+	weakUndefined := libfoo.Rule("weakUndefinedSymbols")
+	if weakUndefined.Output.Base() != "libfoo.so.weak_undefined.txt" {
+		t.Errorf("unexpected output %q", weakUndefined.Output.String())
+	}
+	if !strings.Contains(weakUndefined.RuleParams.Command, `$$5 == "WEAK" && $$7 == "UND"`) {
+		t.Errorf("weak undefined symbols are not selected by %q", weakUndefined.RuleParams.Command)
+	}
+
+	unstripped := libfoo.Module().(*Module).UnstrippedOutputFile()
+	if weakUndefined.Input.String() != unstripped.String() {
+		t.Errorf("expected input %q, got %q", unstripped.String(), weakUndefined.Input.String())
+	}
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared")
+	if libbar.MaybeRule("weakUndefinedSymbols").Rule != nil {
+		t.Errorf("weak undefined symbols list should not be generated for libbar")
+	}
+}
+
+func TestLibraryStableCxxAbi(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libvndk",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			vndk: {
+				enabled: true,
+			},
+			stable_cxx_abi: true,
+			nocrt: true,
+		}
+
+		cc_library {
+			name: "libvndk2",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			vndk: {
+				enabled: true,
+			},
+			nocrt: true,
+		}`
+
+	config := android.TestArchConfig(buildDir, nil)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("VER")
+
+	refDumpDir := "prebuilts/abi-dumps/vndk/VER/64/arm64_armv8-a/source-based/"
+	ctx := testCcWithConfigAndFs(t, bp, config, map[string][]byte{
+		refDumpDir + "libvndk.so.lsdump":  nil,
+		refDumpDir + "libvndk2.so.lsdump": nil,
+	})
+
+	// An inline namespace change shows up as mangled names of the reference dump that are
+	// missing from the new dump, which is what the check compares.
+	libvndk := ctx.ModuleForTests("libvndk", "android_arm64_armv8-a_vendor_shared")
+	check := libvndk.Rule("sAbiMangledNamesCheck")
+	if !strings.HasSuffix(check.Args["referenceDump"], refDumpDir+"libvndk.so.lsdump") {
+		t.Errorf("expected the mangled names to be checked against the reference dump, got %q",
+			check.Args["referenceDump"])
+	}
+	for _, suffix := range []string{".old", ".new", ".removed"} {
+		if !android.InList(check.Output.String()+suffix, check.ImplicitOutputs.Strings()) {
+			t.Errorf("expected %q to be an output of the check, got %q", check.Output.String()+suffix,
+				check.ImplicitOutputs.Strings())
+		}
+	}
+
+	// Run the check on dumps where a name only moved to another inline namespace, and on dumps
+	// where a name was only added.
+	runCheck := func(reference, current []string) error {
+		dir, err := ioutil.TempDir("", "mangled_names_check")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeDump := func(name string, mangledNames []string) string {
+			var content string
+			for _, mangledName := range mangledNames {
+				content += "  linker_set_key: \"" + mangledName + "\"\n"
+			}
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}
+
+		out := filepath.Join(dir, "check")
+		command := strings.NewReplacer(
+			"${referenceDump}", writeDump("ref.lsdump", reference),
+			"${libName}", "libvndk",
+			"${out}", out,
+			"$out", out,
+			"$in", writeDump("new.lsdump", current),
+		).Replace(check.RuleParams.Command)
+		return exec.Command("/bin/bash", "-c", command).Run()
+	}
+
+	if err := runCheck([]string{"_ZNSt3__14moveEv", "_Z3foov"}, []string{"_ZNSt3__24moveEv", "_Z3foov"}); err == nil {
+		t.Errorf("expected the check to fail when a mangled name changed")
+	}
+	if err := runCheck([]string{"_Z3foov"}, []string{"_Z3barv", "_Z3foov"}); err != nil {
+		t.Errorf("expected the check to pass when a mangled name was added, got %s", err)
+	}
+
+	libvndk2 := ctx.ModuleForTests("libvndk2", "android_arm64_armv8-a_vendor_shared")
+	if libvndk2.MaybeRule("sAbiMangledNamesCheck").Rule != nil {
+		t.Errorf("unexpected mangled names check for libvndk2")
+	}
+}
+
+func TestLibraryExportedDefines(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "foo.map.txt",
+				versions: ["1", "2"],
+			},
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared_2").Module().(*Module)
+	info := libfoo.FlagExporterInfo()
+	if g, w := info.ExportedDefines, map[string]string{"__LIBFOO_API__": "2"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected exported defines %q, got %q", w, g)
+	}
+	if !inList("-D__LIBFOO_API__=2", info.ReexportedFlags) {
+		t.Errorf("expected the define to still be in the exported flags, got %q", info.ReexportedFlags)
+	}
+}
+
+func TestReexportDefines(t *testing.T) {
+	f := &flagExporter{}
+	f.reexportFlags([]string{
+		"-Iinclude -DFOO=foo",
+		"-DBAR",
+		"-D", "BAZ=baz",
+		"-DQUX=qux",
+		"-UQUX",
+	})
+
+	expected := map[string]string{
+		"FOO": "foo",
+		"BAR": "1",
+		"BAZ": "baz",
+	}
+	if !reflect.DeepEqual(f.exportedDefines(), expected) {
+		t.Errorf("expected defines %q, got %q", expected, f.exportedDefines())
+	}
+}
+
+func TestStubsVersionsOrder(t *testing.T) {
+	testCcError(t, `versions: "2" is listed after "3", versions must be in increasing order`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "foo.map.txt",
+				versions: ["1", "3", "2"],
+			},
+		}`)
+
+	testCcError(t, `versions: "2" is listed more than once`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "foo.map.txt",
+				versions: ["1", "2", "2"],
+			},
+		}`)
+
+	testCcError(t, `versions: "Q" is not a number`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "foo.map.txt",
+				versions: ["1", "Q"],
+			},
+		}`)
+}
+
+func TestLibraryBuildNonPicVariant(t *testing.T) {
+	ctx := testCc(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			static: {
+				srcs: ["bar.c"],
+			},
+			build_nonpic_variant: true,
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}`)
+
+	libfooStatic := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_static")
+	nonPic := libfooStatic.Output("libfoo_nopic.a")
+	if g, w := nonPic.Inputs.Strings(), []string{
+		libfooStatic.Output("obj/nopic/foo.o").Output.String(),
+		libfooStatic.Output("obj/nopic/bar.o").Output.String(),
+	}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected the non-PIC archive to contain %q, got %q", w, g)
+	}
+	if g, w := libfooStatic.Module().(*Module).NonPicStaticLibFile().String(), nonPic.Output.String(); g != w {
+		t.Errorf("expected NonPicStaticLibFile() %q, got %q", w, g)
+	}
+
+	// The cflags of the module are the ones of its PIC objects, the non-PIC objects are compiled
+	// with a copy of them without -fPIC.
+	if moduleCFlags := libfooStatic.Module().(*Module).flags.CFlags; !inList("-fPIC", moduleCFlags) {
+		t.Errorf("expected -fPIC in the cflags of the module, got %q", moduleCFlags)
+	}
+	if info := libfooStatic.Module().(*Module).EffectiveCflagsInfo(); !inList("-fPIC", info.CFlags) {
+		t.Errorf("expected -fPIC in the effective cflags of the module, got %q", info.CFlags)
+	}
+	cFlags := strings.Fields(libfooStatic.Output("obj/nopic/foo.o").Args["cFlags"])
+	if !inList("$nopiccflags", cFlags) || inList("$cflags", cFlags) || !inList("-fno-pic", cFlags) {
+		t.Errorf("expected the non-PIC objects to be compiled with the cflags without -fPIC, got %q", cFlags)
+	}
+	for _, obj := range []string{"obj/foo.o", "obj/bar.o"} {
+		if cFlags := strings.Fields(libfooStatic.Output(obj).Args["cFlags"]); !inList("$cflags", cFlags) {
+			t.Errorf("expected %q to be compiled with the cflags of the module, got %q", obj, cFlags)
+		}
+	}
+	if moduleCFlags := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_static").Module().(*Module).flags.CFlags; !inList("-fPIC", moduleCFlags) {
+		t.Errorf("expected -fPIC in the cflags of libbar, got %q", moduleCFlags)
+	}
+
+	// the shared variant and the libraries that don't ask for it don't build the non-PIC archive
+	shared := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if shared.NonPicStaticLibFile().Valid() {
+		t.Errorf("expected no non-PIC archive for the shared variant")
+	}
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_static").Module().(*Module)
+	if libbar.NonPicStaticLibFile().Valid() {
+		t.Errorf("expected no non-PIC archive for libbar")
+	}
+}
@@ -40,9 +40,19 @@ type BaseLinkerProperties struct {
 	// list of modules that should be dynamically linked into this module.
 	Shared_libs []string `android:"arch_variant"`
 
+	// list of shared libs that should not be used to build this module, e.g. in an arch: { arm64: {} }
+	// block to drop a lib that is only needed on some architectures from the common shared_libs.
+	Exclude_shared_libs []string `android:"arch_variant"`
+
 	// list of modules that should only provide headers for this module.
 	Header_libs []string `android:"arch_variant,variant_prepend"`
 
+	// list of modules that only provide headers needed to compile this module. They are used
+	// exactly like header_libs, which are not reexported unless listed in
+	// export_header_lib_headers either. This is only a guard that marks the headers as an
+	// implementation detail: listing them in export_header_lib_headers is an error.
+	Private_header_libs []string `android:"arch_variant,variant_prepend"`
+
 	// list of module-specific flags that will be used for all link steps
 	Ldflags []string `android:"arch_variant"`
 
@@ -148,11 +158,20 @@ type BaseLinkerProperties struct {
 	// Generate compact dynamic relocation table, default true.
 	Pack_relocations *bool `android:"arch_variant"`
 
+	// Identical code folding mode passed to lld, one of "none", "safe" or "all". "safe" also
+	// compiles the sources with -faddrsig so that lld only folds sections whose address is not
+	// taken. Requires use_clang_lld.
+	Icf *string `android:"arch_variant"`
+
 	// local file name to pass to the linker as --version_script
 	Version_script *string `android:"path,arch_variant"`
 
 	// Local file name to pass to the linker as --symbol-ordering-file
 	Symbol_ordering_file *string `android:"arch_variant"`
+
+	// Write a linker map file next to the shared library or binary, listing where the linker
+	// placed each section and symbol, for post-mortem analysis.
+	Emit_link_map *bool
 }
 
 func NewBaseLinker(sanitize *sanitize) *baseLinker {
@@ -168,6 +187,32 @@ type baseLinker struct {
 	}
 
 	sanitize *sanitize
+
+	// the version script passed to the linker, if any
+	versionScript android.OptionalPath
+
+	// the map file written by the linker when emit_link_map is set
+	linkMapFile android.OptionalPath
+}
+
+// linkMapOutputs returns the map file for the linker to write alongside an output file named
+// fileName when emit_link_map is set, and adds the flag that makes the linker write it.
+func (linker *baseLinker) linkMapOutputs(ctx ModuleContext, fileName string, flags *builderFlags) android.WritablePaths {
+	if !Bool(linker.Properties.Emit_link_map) {
+		return nil
+	}
+	mapFile := android.PathForModuleOut(ctx, fileName+".map")
+	if ctx.Darwin() {
+		flags.ldFlags += " -Wl,-map," + mapFile.String()
+	} else {
+		flags.ldFlags += " -Wl,-Map=" + mapFile.String()
+	}
+	linker.linkMapFile = android.OptionalPathForPath(mapFile)
+	return android.WritablePaths{mapFile}
+}
+
+func (linker *baseLinker) linkMap() android.OptionalPath {
+	return linker.linkMapFile
 }
 
 func (linker *baseLinker) appendLdflags(flags []string) {
@@ -189,6 +234,7 @@ func (linker *baseLinker) linkerProps() []interface{} {
 func (linker *baseLinker) linkerDeps(ctx DepsContext, deps Deps) Deps {
 	deps.WholeStaticLibs = append(deps.WholeStaticLibs, linker.Properties.Whole_static_libs...)
 	deps.HeaderLibs = append(deps.HeaderLibs, linker.Properties.Header_libs...)
+	deps.PrivateHeaderLibs = append(deps.PrivateHeaderLibs, linker.Properties.Private_header_libs...)
 	deps.StaticLibs = append(deps.StaticLibs, linker.Properties.Static_libs...)
 	deps.SharedLibs = append(deps.SharedLibs, linker.Properties.Shared_libs...)
 	deps.RuntimeLibs = append(deps.RuntimeLibs, linker.Properties.Runtime_libs...)
@@ -208,6 +254,7 @@ func (linker *baseLinker) linkerDeps(ctx DepsContext, deps Deps) Deps {
 		deps.ReexportSharedLibHeaders = removeListFromList(deps.ReexportSharedLibHeaders, linker.Properties.Target.Vendor.Exclude_shared_libs)
 		deps.StaticLibs = removeListFromList(deps.StaticLibs, linker.Properties.Target.Vendor.Exclude_static_libs)
 		deps.HeaderLibs = removeListFromList(deps.HeaderLibs, linker.Properties.Target.Vendor.Exclude_header_libs)
+		deps.PrivateHeaderLibs = removeListFromList(deps.PrivateHeaderLibs, linker.Properties.Target.Vendor.Exclude_header_libs)
 		deps.ReexportStaticLibHeaders = removeListFromList(deps.ReexportStaticLibHeaders, linker.Properties.Target.Vendor.Exclude_static_libs)
 		deps.WholeStaticLibs = removeListFromList(deps.WholeStaticLibs, linker.Properties.Target.Vendor.Exclude_static_libs)
 		deps.RuntimeLibs = removeListFromList(deps.RuntimeLibs, linker.Properties.Target.Vendor.Exclude_runtime_libs)
@@ -219,11 +266,15 @@ func (linker *baseLinker) linkerDeps(ctx DepsContext, deps Deps) Deps {
 		deps.ReexportSharedLibHeaders = removeListFromList(deps.ReexportSharedLibHeaders, linker.Properties.Target.Recovery.Exclude_shared_libs)
 		deps.StaticLibs = removeListFromList(deps.StaticLibs, linker.Properties.Target.Recovery.Exclude_static_libs)
 		deps.HeaderLibs = removeListFromList(deps.HeaderLibs, linker.Properties.Target.Recovery.Exclude_header_libs)
+		deps.PrivateHeaderLibs = removeListFromList(deps.PrivateHeaderLibs, linker.Properties.Target.Recovery.Exclude_header_libs)
 		deps.ReexportHeaderLibHeaders = removeListFromList(deps.ReexportHeaderLibHeaders, linker.Properties.Target.Recovery.Exclude_header_libs)
 		deps.ReexportStaticLibHeaders = removeListFromList(deps.ReexportStaticLibHeaders, linker.Properties.Target.Recovery.Exclude_static_libs)
 		deps.WholeStaticLibs = removeListFromList(deps.WholeStaticLibs, linker.Properties.Target.Recovery.Exclude_static_libs)
 	}
 
+	deps.SharedLibs = removeListFromList(deps.SharedLibs, linker.Properties.Exclude_shared_libs)
+	deps.ReexportSharedLibHeaders = removeListFromList(deps.ReexportSharedLibHeaders, linker.Properties.Exclude_shared_libs)
+
 	if ctx.toolchain().Bionic() {
 		// libclang_rt.builtins, libgcc and libatomic have to be last on the command line
 		if !Bool(linker.Properties.No_libcrt) {
@@ -311,6 +362,10 @@ func (linker *baseLinker) useClangLld(ctx ModuleContext) bool {
 	return true
 }
 
+func (linker *baseLinker) icf() string {
+	return String(linker.Properties.Icf)
+}
+
 // Check whether the SDK version is not older than the specific one
 func CheckSdkVersionAtLeast(ctx ModuleContext, SdkVersion int) bool {
 	if ctx.sdkVersion() == "current" {
@@ -368,6 +423,22 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 		flags.LdFlags = append(flags.LdFlags, toolchain.ClangLdflags())
 	}
 
+	if icf := linker.icf(); icf != "" {
+		switch icf {
+		case "none", "safe", "all":
+		default:
+			ctx.PropertyErrorf("icf", "must be one of \"none\", \"safe\" or \"all\", got %q", icf)
+		}
+		if !linker.useClangLld(ctx) {
+			ctx.PropertyErrorf("icf", "identical code folding requires use_clang_lld")
+		}
+		// Placed after the toolchain flags so that it overrides their default --icf mode.
+		flags.LdFlags = append(flags.LdFlags, "-Wl,--icf="+icf)
+		if icf == "safe" {
+			flags.CFlags = append(flags.CFlags, "-faddrsig")
+		}
+	}
+
 	if !ctx.toolchain().Bionic() && !ctx.Fuchsia() {
 		CheckBadHostLdlibs(ctx, "host_ldlibs", linker.Properties.Host_ldlibs)
 
@@ -441,6 +512,7 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 				flags.LdFlags = append(flags.LdFlags,
 					"-Wl,--version-script,"+versionScript.String())
 				flags.LdFlagsDeps = append(flags.LdFlagsDeps, versionScript.Path())
+				linker.versionScript = versionScript
 
 				if linker.sanitize.isSanitizerEnabled(cfi) {
 					cfiExportsMap := android.PathForSource(ctx, cfiExportsMapPath)
@@ -125,6 +125,17 @@ func (lto *lto) LTO() bool {
 	return full || thin
 }
 
+// Mode returns the kind of LTO the module is built with, "none", "thin" or "full".
+// Can be called with a null receiver
+func (lto *lto) Mode() string {
+	if !lto.LTO() {
+		return "none"
+	} else if Bool(lto.Properties.Lto.Thin) {
+		return "thin"
+	}
+	return "full"
+}
+
 // Is lto.never explicitly set to true?
 func (lto *lto) Disabled() bool {
 	return lto.Properties.Lto.Never != nil && *lto.Properties.Lto.Never
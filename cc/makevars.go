@@ -28,6 +28,13 @@ var (
 	modulesAddedWallKey          = android.NewOnceKey("ModulesAddedWall")
 	modulesUsingWnoErrorKey      = android.NewOnceKey("ModulesUsingWnoError")
 	modulesMissingProfileFileKey = android.NewOnceKey("ModulesMissingProfileFile")
+
+	modulesAllowingIllegalCflagsKey = android.NewOnceKey("ModulesAllowingIllegalCflags")
+	modulesUsingLibraryAliasesKey   = android.NewOnceKey("ModulesUsingLibraryAliases")
+
+	modulesWithLargeWholeStaticLibsKey = android.NewOnceKey("ModulesWithLargeWholeStaticLibs")
+
+	modulesUsingFastFpPolicyOnUntrustedInputKey = android.NewOnceKey("ModulesUsingFastFpPolicyOnUntrustedInput")
 )
 
 func init() {
@@ -134,6 +141,11 @@ func makeVarsProvider(ctx android.MakeVarsContext) {
 	ctx.Strict("SOONG_MODULES_ADDED_WALL", makeStringOfKeys(ctx, modulesAddedWallKey))
 	ctx.Strict("SOONG_MODULES_USING_WNO_ERROR", makeStringOfKeys(ctx, modulesUsingWnoErrorKey))
 	ctx.Strict("SOONG_MODULES_MISSING_PGO_PROFILE_FILE", makeStringOfKeys(ctx, modulesMissingProfileFileKey))
+	ctx.Strict("SOONG_MODULES_ALLOWING_ILLEGAL_CFLAGS", makeStringOfKeys(ctx, modulesAllowingIllegalCflagsKey))
+	ctx.Strict("SOONG_MODULES_USING_LIBRARY_ALIASES", makeStringOfKeys(ctx, modulesUsingLibraryAliasesKey))
+	ctx.Strict("SOONG_MODULES_WITH_LARGE_WHOLE_STATIC_LIBS", makeStringOfKeys(ctx, modulesWithLargeWholeStaticLibsKey))
+	ctx.Strict("SOONG_MODULES_USING_FAST_FP_POLICY_ON_UNTRUSTED_INPUT",
+		makeStringOfKeys(ctx, modulesUsingFastFpPolicyOnUntrustedInputKey))
 
 	ctx.Strict("ADDRESS_SANITIZER_CONFIG_EXTRA_CFLAGS", strings.Join(asanCflags, " "))
 	ctx.Strict("ADDRESS_SANITIZER_CONFIG_EXTRA_LDFLAGS", strings.Join(asanLdflags, " "))
@@ -0,0 +1,69 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+// CcCompileMetrics records how a cc module was compiled, so that build time can be attributed to
+// source languages and to the optimizations and sanitizers that were active.
+type CcCompileMetrics struct {
+	// Number of C, C++, Objective-C/C++ and assembly sources compiled by the module
+	CSrcs    int
+	CppSrcs  int
+	ObjcSrcs int
+	AsmSrcs  int
+
+	// Whether the module was compiled with or for profile guided optimization
+	Pgo bool
+	// Whether the module was compiled with LTO
+	Lto bool
+	// Whether the module was compiled with CFI
+	Cfi bool
+}
+
+// CcCompileMetricsProvider is implemented by modules that record CcCompileMetrics.
+type CcCompileMetricsProvider interface {
+	CcCompileMetrics() CcCompileMetrics
+}
+
+var _ CcCompileMetricsProvider = (*Module)(nil)
+
+// CcCompileMetrics returns the metrics recorded while generating the build actions of this module.
+func (c *Module) CcCompileMetrics() CcCompileMetrics {
+	return c.compileMetrics
+}
+
+func (c *Module) computeCompileMetrics() CcCompileMetrics {
+	metrics := CcCompileMetrics{
+		Pgo: c.pgo != nil && (c.pgo.Properties.PgoCompile || c.pgo.Properties.ShouldProfileModule),
+		Lto: c.lto.LTO(),
+		Cfi: c.sanitize.isSanitizerEnabled(cfi),
+	}
+
+	if compiled, ok := c.compiler.(CompiledInterface); ok {
+		for _, src := range compiled.Srcs() {
+			switch src.Ext() {
+			case ".c":
+				metrics.CSrcs++
+			case ".cpp", ".cc":
+				metrics.CppSrcs++
+			case ".m", ".mm":
+				metrics.ObjcSrcs++
+			case ".s", ".S", ".asm":
+				metrics.AsmSrcs++
+			}
+		}
+	}
+
+	return metrics
+}
@@ -155,6 +155,20 @@ func normalizeNdkApiLevel(ctx android.BaseContext, apiLevel string,
 	return strconv.Itoa(intMax(version, firstArchVersion)), nil
 }
 
+// ndkApiLevelNewerThan returns true if the normalized API level a is newer than the normalized
+// API level b.
+func ndkApiLevelNewerThan(a, b string) bool {
+	if a == b || b == "current" {
+		return false
+	}
+	if a == "current" {
+		return true
+	}
+	aInt, _ := strconv.Atoi(a)
+	bInt, _ := strconv.Atoi(b)
+	return aInt > bInt
+}
+
 func getFirstGeneratedVersion(firstSupportedVersion string, platformVersion int) (int, error) {
 	if firstSupportedVersion == "current" {
 		return platformVersion + 1, nil
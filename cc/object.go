@@ -107,6 +107,15 @@ func (object *objectLinker) link(ctx ModuleContext,
 		TransformObjsToObj(ctx, objs.objFiles, builderFlags, output)
 	}
 
+	if alignment := object.Properties.Section_alignment; alignment != nil {
+		if *alignment <= 0 || *alignment&(*alignment-1) != 0 {
+			ctx.PropertyErrorf("section_alignment", "must be a power of two, got %d", *alignment)
+		}
+		output := android.PathForModuleOut(ctx, "aligned", ctx.ModuleName()+objectExtension)
+		TransformObjToSectionAlignment(ctx, *alignment, outputFile, builderFlags, output)
+		outputFile = output
+	}
+
 	ctx.CheckbuildFile(outputFile)
 	return outputFile
 }
@@ -15,6 +15,8 @@
 package cc
 
 import (
+	"regexp"
+
 	"android/soong/android"
 )
 
@@ -37,6 +39,10 @@ type prebuiltLinkerProperties struct {
 	// Check the prebuilt ELF files (e.g. DT_SONAME, DT_NEEDED, resolution of undefined
 	// symbols, etc), default true.
 	Check_elf_files *bool
+
+	// The expected SHA-256 checksum of the prebuilt file, as 64 hexadecimal digits. If set, the
+	// checksum of the prebuilt is verified as part of checkbuild.
+	Sha256 *string `android:"arch_variant"`
 }
 
 type prebuiltLinker struct {
@@ -53,6 +59,25 @@ func (p *prebuiltLinker) PrebuiltSrcs() []string {
 	return p.properties.Srcs
 }
 
+var sha256Regexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// verifyChecksum adds a rule that fails if the checksum of the prebuilt file doesn't match the
+// sha256 property, and adds it to checkbuild.
+func (p *prebuiltLinker) verifyChecksum(ctx ModuleContext, in android.Path) {
+	if p.properties.Sha256 == nil {
+		return
+	}
+	sha256 := *p.properties.Sha256
+	if !sha256Regexp.MatchString(sha256) {
+		ctx.PropertyErrorf("sha256", "%q is not a SHA-256 checksum of 64 lowercase hexadecimal digits", sha256)
+		return
+	}
+
+	checksumCheck := android.PathForModuleOut(ctx, in.Base()+".sha256_check")
+	TransformPrebuiltToChecksumCheck(ctx, in, sha256, checksumCheck)
+	ctx.CheckbuildFile(checksumCheck)
+}
+
 type prebuiltLibraryInterface interface {
 	libraryInterface
 	prebuiltLinkerInterface
@@ -92,6 +117,7 @@ func (p *prebuiltLibraryLinker) link(ctx ModuleContext,
 		builderFlags := flagsToBuilderFlags(flags)
 
 		in := p.Prebuilt.SingleSourcePath(ctx)
+		p.verifyChecksum(ctx, in)
 
 		if p.shared() {
 			p.unstrippedOutputFile = in
@@ -192,6 +218,7 @@ func (p *prebuiltBinaryLinker) link(ctx ModuleContext,
 
 		fileName := p.getStem(ctx) + flags.Toolchain.ExecutableSuffix()
 		in := p.Prebuilt.SingleSourcePath(ctx)
+		p.verifyChecksum(ctx, in)
 
 		p.unstrippedOutputFile = in
 
@@ -15,6 +15,7 @@
 package cc
 
 import (
+	"reflect"
 	"testing"
 
 	"android/soong/android"
@@ -124,3 +125,131 @@ func TestPrebuilt(t *testing.T) {
 		t.Errorf("libe missing dependency on prebuilt_libe")
 	}
 }
+
+func testPrebuilt(t *testing.T, bp string, fs map[string][]byte) *android.TestContext {
+	t.Helper()
+	config := android.TestArchConfig(buildDir, nil)
+	ctx := createTestContext(t, config, bp, fs, android.Android)
+
+	ctx.RegisterModuleType("cc_prebuilt_library_shared", android.ModuleFactoryAdaptor(prebuiltSharedLibraryFactory))
+	ctx.RegisterModuleType("cc_prebuilt_library_static", android.ModuleFactoryAdaptor(prebuiltStaticLibraryFactory))
+	ctx.RegisterModuleType("cc_prebuilt_binary", android.ModuleFactoryAdaptor(prebuiltBinaryFactory))
+
+	ctx.PreArchMutators(android.RegisterPrebuiltsPreArchMutators)
+	ctx.PostDepsMutators(android.RegisterPrebuiltsPostDepsMutators)
+
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfErrored(t, errs)
+
+	return ctx
+}
+
+func TestPrebuiltChecksum(t *testing.T) {
+	const sha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	ctx := testPrebuilt(t, `
+		cc_prebuilt_library_static {
+			name: "liba",
+			srcs: ["liba.a"],
+			sha256: "`+sha256+`",
+		}
+
+		cc_prebuilt_library_static {
+			name: "libb",
+			srcs: ["libb.a"],
+		}
+	`, map[string][]byte{
+		"liba.a": nil,
+		"libb.a": nil,
+	})
+
+	liba := ctx.ModuleForTests("liba", "android_arm64_armv8-a_core_static")
+	check := liba.Rule("prebuiltChecksum")
+	if g, w := check.Input.String(), "liba.a"; g != w {
+		t.Errorf("expected the checksum of %q to be verified, got %q", w, g)
+	}
+	if g := check.Args["sha256"]; g != sha256 {
+		t.Errorf("expected sha256 %q, got %q", sha256, g)
+	}
+
+	libb := ctx.ModuleForTests("libb", "android_arm64_armv8-a_core_static")
+	if libb.MaybeRule("prebuiltChecksum").Rule != nil {
+		t.Errorf("unexpected checksum verification for libb")
+	}
+}
+
+func TestPrebuiltStaticLibsInfo(t *testing.T) {
+	ctx := testPrebuilt(t, `
+		cc_prebuilt_library_static {
+			name: "libprebuilt",
+			srcs: ["libprebuilt.a"],
+		}
+
+		cc_library_static {
+			name: "libsource",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			whole_static_libs: ["libprebuilt"],
+			static_libs: ["libsource"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			static_libs: ["libsource"],
+		}
+	`, map[string][]byte{
+		"libprebuilt.a": nil,
+	})
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	info := libfoo.PrebuiltStaticLibsInfo()
+	if !info.LinksPrebuiltStaticLibs {
+		t.Errorf("expected libfoo to be flagged as linking against prebuilt static libs")
+	}
+	if g, w := info.Archives.Strings(), []string{"libprebuilt.a"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected prebuilt archives %q, got %q", w, g)
+	}
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_core_shared").Module().(*Module)
+	if libbar.PrebuiltStaticLibsInfo().LinksPrebuiltStaticLibs {
+		t.Errorf("expected libbar not to be flagged, got archives %q",
+			libbar.PrebuiltStaticLibsInfo().Archives)
+	}
+}
+
+func TestPrebuiltStaticLibInSharedLibs(t *testing.T) {
+	bp := `
+		cc_prebuilt_library_static {
+			name: "libbar",
+			srcs: ["libbar.a"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			shared_libs: ["libbar"],
+		}
+	`
+	config := android.TestArchConfig(buildDir, nil)
+	ctx := createTestContext(t, config, bp, map[string][]byte{"libbar.a": nil}, android.Android)
+
+	ctx.RegisterModuleType("cc_prebuilt_library_static", android.ModuleFactoryAdaptor(prebuiltStaticLibraryFactory))
+
+	ctx.PreArchMutators(android.RegisterPrebuiltsPreArchMutators)
+	ctx.PostDepsMutators(android.RegisterPrebuiltsPostDepsMutators)
+
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	android.FailIfNoMatchingErrors(t, `shared_libs: "libbar" is a static library, move it to static_libs`, errs)
+}
@@ -61,6 +61,18 @@ var (
 		"export_memory_stats=0", "max_malloc_fill_size=0"}
 )
 
+// Pairs of sanitizers that can't be enabled together in a module, by property name.
+var incompatibleSanitizers = [][2]string{
+	{"address", "hwaddress"},
+	{"address", "thread"},
+	{"hwaddress", "thread"},
+	{"address", "safestack"},
+	// CFI is otherwise silently dropped when ASan or HWASan is enabled, e.g. globally, but a
+	// module asking for both itself gets an error instead of a variant without CFI.
+	{"cfi", "address"},
+	{"cfi", "hwaddress"},
+}
+
 type sanitizerType int
 
 func boolPtr(v bool) *bool {
@@ -166,6 +178,9 @@ type SanitizeProperties struct {
 	InSanitizerDir    bool     `blueprint:"mutated"`
 	Sanitizers        []string `blueprint:"mutated"`
 	DiagSanitizers    []string `blueprint:"mutated"`
+
+	// The sanitizer variations, e.g. "cfi" or "hwasan", that were created for this module.
+	SanitizerVariations []string `blueprint:"mutated"`
 }
 
 type sanitize struct {
@@ -199,6 +214,13 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		return
 	}
 
+	// Only the sanitizers requested by the module itself are checked, the global ones are
+	// reconciled with them below.
+	if conflicts := sanitize.incompatibleSanitizers(); len(conflicts) > 0 {
+		ctx.PropertyErrorf("sanitize", "incompatible sanitizers enabled: %s",
+			strings.Join(conflicts, ", "))
+	}
+
 	var globalSanitizers []string
 	var globalSanitizersDiag []string
 
@@ -385,6 +407,25 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 	}
 }
 
+// incompatibleSanitizers returns the pairs of enabled sanitizers that can't be used together.
+func (sanitize *sanitize) incompatibleSanitizers() []string {
+	s := &sanitize.Properties.Sanitize
+	enabled := map[string]bool{
+		"address":   Bool(s.Address),
+		"hwaddress": Bool(s.Hwaddress),
+		"thread":    Bool(s.Thread),
+		"safestack": Bool(s.Safestack),
+		"cfi":       Bool(s.Cfi),
+	}
+	var conflicts []string
+	for _, pair := range incompatibleSanitizers {
+		if enabled[pair[0]] && enabled[pair[1]] {
+			conflicts = append(conflicts, pair[0]+" and "+pair[1])
+		}
+	}
+	return conflicts
+}
+
 func (sanitize *sanitize) deps(ctx BaseModuleContext, deps Deps) Deps {
 	if !sanitize.Properties.SanitizerEnabled { // || c.static() {
 		return deps
@@ -856,6 +897,15 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 	}
 }
 
+// addSanitizerVariation records in each of the variants created by the sanitizer mutator of the
+// given type that the sanitized variation of their module exists.
+func addSanitizerVariation(modules []blueprint.Module, t sanitizerType) {
+	for _, m := range modules {
+		s := &m.(*Module).sanitize.Properties
+		s.SanitizerVariations = append(s.SanitizerVariations, t.variationName())
+	}
+}
+
 type Sanitizeable interface {
 	android.Module
 	IsSanitizerEnabled(ctx android.BaseModuleContext, sanitizerName string) bool
@@ -869,6 +919,7 @@ func sanitizerMutator(t sanitizerType) func(android.BottomUpMutatorContext) {
 			if c.isDependencyRoot() && c.sanitize.isSanitizerEnabled(t) {
 				modules := mctx.CreateVariations(t.variationName())
 				modules[0].(*Module).sanitize.SetSanitizer(t, true)
+				addSanitizerVariation(modules, t)
 			} else if c.sanitize.isSanitizerEnabled(t) || c.sanitize.Properties.SanitizeDep {
 				// Save original sanitizer status before we assign values to variant
 				// 0 as that overwrites the original.
@@ -877,6 +928,7 @@ func sanitizerMutator(t sanitizerType) func(android.BottomUpMutatorContext) {
 				modules := mctx.CreateVariations("", t.variationName())
 				modules[0].(*Module).sanitize.SetSanitizer(t, false)
 				modules[1].(*Module).sanitize.SetSanitizer(t, true)
+				addSanitizerVariation(modules, t)
 
 				modules[0].(*Module).sanitize.Properties.SanitizeDep = false
 				modules[1].(*Module).sanitize.Properties.SanitizeDep = false
@@ -41,6 +41,19 @@ func getNdkStlFamilyAndLinkType(m *Module) (string, string) {
 	}
 }
 
+// Environment variable used to fail the build when a module selects a deprecated STL.
+const envVariableDisallowDeprecatedStl = "SOONG_DISALLOW_DEPRECATED_STL"
+
+// The selected STLs that modules are being migrated away from, with the values of the stl
+// property to use instead.
+var deprecatedStls = map[string][]string{
+	"ndk_system": {"c++_shared", "c++_static"},
+}
+
+// Modules that may keep using a deprecated STL when SOONG_DISALLOW_DEPRECATED_STL is set,
+// until they are migrated.
+var deprecatedStlAllowedModules = []string{}
+
 type StlProperties struct {
 	// Select the STL library to use.  Possible values are "libc++",
 	// "libc++_static", "libstdc++", or "none". Leave blank to select the
@@ -129,6 +142,13 @@ func (stl *stl) begin(ctx BaseModuleContext) {
 			}
 		}
 	}()
+
+	if alternatives, ok := deprecatedStls[stl.Properties.SelectedStl]; ok &&
+		ctx.Config().IsEnvTrue(envVariableDisallowDeprecatedStl) &&
+		!inList(ctx.baseModuleName(), deprecatedStlAllowedModules) {
+		ctx.PropertyErrorf("stl", "the %q STL is deprecated, use one of %q instead",
+			stl.Properties.SelectedStl, alternatives)
+	}
 }
 
 func needsLibAndroidSupport(ctx BaseModuleContext) bool {
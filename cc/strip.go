@@ -28,6 +28,11 @@ type StripProperties struct {
 		Keep_symbols_list []string `android:"arch_variant"`
 		Use_gnu_strip     *bool    `android:"arch_variant"`
 	} `android:"arch_variant"`
+
+	// path to a strip tool in the source tree to use instead of the toolchain's strip for ELF
+	// files. It is called like GNU strip, e.g. with --strip-all and -o. Modules can't be referenced
+	// with ":module", since a device module can't depend on the host variant of a tool module.
+	Strip_tool *string `android:"arch_variant"`
 }
 
 type stripper struct {
@@ -54,6 +59,13 @@ func (stripper *stripper) strip(ctx ModuleContext, in android.Path, out android.
 		if Bool(stripper.StripProperties.Strip.Use_gnu_strip) {
 			flags.stripUseGnuStrip = true
 		}
+		if tool := stripper.StripProperties.Strip_tool; tool != nil {
+			if android.SrcIsModule(*tool) != "" {
+				ctx.PropertyErrorf("strip_tool", "must be a source path, not a module reference %q", *tool)
+			} else {
+				flags.stripTool = android.OptionalPathForPath(android.PathForModuleSrc(ctx, *tool))
+			}
+		}
 		if ctx.Config().Debuggable() && !flags.stripKeepMiniDebugInfo {
 			flags.stripAddGnuDebuglink = true
 		}
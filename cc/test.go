@@ -16,7 +16,9 @@ package cc
 
 import (
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"android/soong/android"
 	"android/soong/tradefed"
@@ -34,6 +36,15 @@ type TestProperties struct {
 type TestOptions struct {
 	// The UID that you want to run the test as on a device.
 	Run_test_as *string
+
+	// The time after which the test runner stops the test, e.g. "5m" or "90s". Written into the
+	// autogenerated test config, so it can't be used with test_config.
+	Timeout *string
+
+	// The minimum API level of the devices that the test runner runs the test on. The test is
+	// skipped on older devices. Written into the autogenerated test config, so it can't be used
+	// with test_config.
+	Min_api_level *int64
 }
 
 type TestBinaryProperties struct {
@@ -50,6 +61,10 @@ type TestBinaryProperties struct {
 	// the test
 	Data []string `android:"path"`
 
+	// list of apex modules whose .apex file should be installed alongside the test, e.g. for
+	// tests that install the apex on the device before running
+	Data_apexes []string
+
 	// list of compatibility suites (for example "cts", "vts") that the module should be
 	// installed into.
 	Test_suites []string `android:"arch_variant"`
@@ -266,6 +281,7 @@ func (test *testBinary) linkerInit(ctx BaseModuleContext) {
 func (test *testBinary) linkerDeps(ctx DepsContext, deps Deps) Deps {
 	deps = test.testDecorator.linkerDeps(ctx, deps)
 	deps = test.binaryDecorator.linkerDeps(ctx, deps)
+	deps.DataApexes = append(deps.DataApexes, test.Properties.Data_apexes...)
 	return deps
 }
 
@@ -275,8 +291,59 @@ func (test *testBinary) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 	return flags
 }
 
+// checkDataOs reports an error for each module listed in data that is built for another kind of OS
+// than the test, e.g. device data of a host test.
+func checkDataOs(ctx ModuleContext, data []string) {
+	var dataModules []string
+	for _, s := range data {
+		if m := android.SrcIsModule(s); m != "" {
+			dataModules = append(dataModules, m)
+		}
+	}
+
+	ctx.VisitDirectDepsWithTag(android.SourceDepTag, func(dep android.Module) {
+		depName := ctx.OtherModuleName(dep)
+		if !inList(depName, dataModules) {
+			return
+		}
+		if depOs := dep.Target().Os; dataOsMismatch(ctx.Os(), depOs) {
+			ctx.PropertyErrorf("data", "%q is built for the %s but the test is built for the %s",
+				depName, depOs.Class, ctx.Os().Class)
+		}
+	})
+}
+
+// dataOsMismatch returns true if data built for dataOs can't be used by a test built for testOs.
+// Data that isn't built for any particular OS, e.g. from a filegroup, can be used by any test.
+func dataOsMismatch(testOs, dataOs android.OsType) bool {
+	return dataOs.Class != android.Generic && dataOs.Class != testOs.Class
+}
+
+// dataApexes returns the .apex files of the modules listed in data_apexes.
+func dataApexes(ctx ModuleContext) android.Paths {
+	var ret android.Paths
+	ctx.VisitDirectDepsWithTag(dataApexDepTag, func(dep android.Module) {
+		depName := ctx.OtherModuleName(dep)
+		// apex modules provide their .apex file as their only source
+		producer, ok := dep.(android.SourceFileProducer)
+		if !ok {
+			ctx.PropertyErrorf("data_apexes", "%q is not an apex module", depName)
+			return
+		}
+		srcs := producer.Srcs()
+		if len(srcs) != 1 || srcs[0].Ext() != ".apex" {
+			ctx.PropertyErrorf("data_apexes", "%q does not produce an .apex file", depName)
+			return
+		}
+		ret = append(ret, srcs[0])
+	})
+	return ret
+}
+
 func (test *testBinary) install(ctx ModuleContext, file android.Path) {
+	checkDataOs(ctx, test.Properties.Data)
 	test.data = android.PathsForModuleSrc(ctx, test.Properties.Data)
+	test.data = append(test.data, dataApexes(ctx)...)
 	var configs []tradefed.Config
 	if Bool(test.Properties.Require_root) {
 		configs = append(configs, tradefed.Preparer{"com.android.tradefed.targetprep.RootTargetPreparer"})
@@ -287,6 +354,26 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 	if test.Properties.Test_options.Run_test_as != nil {
 		configs = append(configs, tradefed.Option{"run-test-as", String(test.Properties.Test_options.Run_test_as)})
 	}
+	if test.Properties.Test_config != nil {
+		if test.Properties.Test_options.Timeout != nil {
+			ctx.PropertyErrorf("test_options.timeout", "cannot be set together with test_config, set it in the test config instead")
+		}
+		if test.Properties.Test_options.Min_api_level != nil {
+			ctx.PropertyErrorf("test_options.min_api_level", "cannot be set together with test_config, set it in the test config instead")
+		}
+	}
+	if timeout := test.Properties.Test_options.Timeout; timeout != nil {
+		if _, err := time.ParseDuration(*timeout); err != nil {
+			ctx.PropertyErrorf("test_options.timeout", "%q is not a duration, e.g. \"5m\"", *timeout)
+		} else {
+			configs = append(configs, tradefed.Option{"native-test-timeout", *timeout})
+		}
+	}
+	if level := test.Properties.Test_options.Min_api_level; level != nil {
+		configs = append(configs, tradefed.Object{"module_controller",
+			"com.android.tradefed.testtype.suite.module.MinApiLevelModuleController",
+			[]tradefed.Option{{"min-api-level", strconv.FormatInt(*level, 10)}}})
+	}
 
 	test.testConfig = tradefed.AutoGenNativeTestConfig(ctx, test.Properties.Test_config,
 		test.Properties.Test_config_template, test.Properties.Test_suites, configs)
@@ -411,6 +498,7 @@ func (benchmark *benchmarkDecorator) linkerDeps(ctx DepsContext, deps Deps) Deps
 }
 
 func (benchmark *benchmarkDecorator) install(ctx ModuleContext, file android.Path) {
+	checkDataOs(ctx, benchmark.Properties.Data)
 	benchmark.data = android.PathsForModuleSrc(ctx, benchmark.Properties.Data)
 	var configs []tradefed.Config
 	if Bool(benchmark.Properties.Require_root) {
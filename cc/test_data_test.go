@@ -18,6 +18,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"testing"
 
 	"android/soong/android"
@@ -180,3 +182,83 @@ func newTest() android.Module {
 func (test *testDataTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	test.data = android.PathsForModuleSrc(ctx, test.Properties.Data)
 }
+
+func TestDataOsMismatch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		testOs, dataOs android.OsType
+		mismatch       bool
+	}{
+		{"device data of a host test", android.Linux, android.Android, true},
+		{"host data of a device test", android.Android, android.Linux, true},
+		{"device data of a device test", android.Android, android.Android, false},
+		{"host data of a host test", android.Linux, android.Linux, false},
+		{"filegroup data of a host test", android.Linux, android.NoOsType, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if g := dataOsMismatch(tc.testOs, tc.dataOs); g != tc.mismatch {
+				t.Errorf("expected dataOsMismatch(%q, %q) to be %t, got %t",
+					tc.testOs, tc.dataOs, tc.mismatch, g)
+			}
+		})
+	}
+}
+
+func TestTestDataOs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cc_binary_host tests fail on mac when trying to exec xcrun")
+	}
+
+	ctx := testCc(t, `
+		cc_binary {
+			name: "device_tool",
+			srcs: ["foo.c"],
+		}
+
+		cc_test {
+			name: "mytest",
+			srcs: ["foo.c"],
+			gtest: false,
+			data: [":device_tool"],
+		}`)
+
+	deviceTool := ctx.ModuleForTests("device_tool", "android_arm64_armv8-a_core").Module().(*Module)
+	mytest := ctx.ModuleForTests("mytest", "android_arm64_armv8-a_core").Module().(*Module)
+	data := mytest.installer.(*testBinary).data
+	if g, w := data.Strings(), deviceTool.Srcs().Strings(); !reflect.DeepEqual(g, w) {
+		t.Errorf("expected test data %q, got %q", w, g)
+	}
+
+	testCcError(t, `data: "host_tool" is built for the host but the test is built for the device`, `
+		cc_binary_host {
+			name: "host_tool",
+			srcs: ["foo.c"],
+			stl: "none",
+		}
+
+		cc_test {
+			name: "mytest",
+			srcs: ["foo.c"],
+			gtest: false,
+			data: [":host_tool"],
+		}`)
+
+	// Device data of a host test is the mistake the check is mostly meant to catch.
+	testCcError(t, `data: "device_tool" is built for the device but the test is built for the host`, `
+		cc_binary {
+			name: "device_tool",
+			srcs: ["foo.c"],
+			stl: "none",
+		}
+
+		cc_test {
+			name: "mytest",
+			srcs: ["foo.c"],
+			gtest: false,
+			host_supported: true,
+			stl: "none",
+			data: [":device_tool"],
+		}`)
+}
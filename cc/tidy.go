@@ -19,6 +19,7 @@ import (
 
 	"github.com/google/blueprint/proptools"
 
+	"android/soong/android"
 	"android/soong/cc/config"
 )
 
@@ -34,6 +35,12 @@ type TidyProperties struct {
 
 	// Checks that should be treated as errors.
 	Tidy_checks_as_errors []string
+
+	// list of source files that should not be checked by clang-tidy, even when tidy is
+	// enabled for the module.  May contain globs, and ":module" references to skip the sources
+	// listed in generated_sources.  Sources generated from srcs, e.g. from .y or .aidl files,
+	// can't be listed and are always checked.
+	Tidy_disabled_srcs []string `android:"path,arch_variant"`
 }
 
 type tidyFeature struct {
@@ -66,6 +73,7 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	}
 
 	flags.Tidy = true
+	flags.TidyDisabledSrcs = android.PathsForModuleSrc(ctx, tidy.Properties.Tidy_disabled_srcs)
 
 	// Add global WITH_TIDY_FLAGS and local tidy_flags.
 	withTidyFlags := ctx.Config().Getenv("WITH_TIDY_FLAGS")
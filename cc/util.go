@@ -67,6 +67,8 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		toolingCppFlags: strings.Join(in.ToolingCppFlags, " "),
 		conlyFlags:      strings.Join(in.ConlyFlags, " "),
 		cppFlags:        strings.Join(in.CppFlags, " "),
+		objcFlags:       strings.Join(in.ObjcFlags, " "),
+		objcppFlags:     strings.Join(in.ObjcppFlags, " "),
 		yaccFlags:       strings.Join(in.YaccFlags, " "),
 		aidlFlags:       strings.Join(in.aidlFlags, " "),
 		rsFlags:         strings.Join(in.rsFlags, " "),
@@ -79,6 +81,12 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		coverage:        in.Coverage,
 		tidy:            in.Tidy,
 		sAbiDump:        in.SAbiDump,
+		warnings:        in.WarningBaseline.Valid(),
+		inputDeps:       in.InputDeps,
+
+		tidyDisabledSrcs: in.TidyDisabledSrcs,
+
+		srcFlags: in.SrcFlags,
 
 		systemIncludeFlags: strings.Join(in.SystemIncludeFlags, " "),
 
@@ -90,6 +98,46 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 	}
 }
 
+// lastStdFlag returns the value of the last -std= flag in the given lists of flags, which are in
+// command line order.
+func lastStdFlag(flagLists ...[]string) string {
+	std := ""
+	for _, flags := range flagLists {
+		for _, flag := range flags {
+			for _, f := range strings.Fields(flag) {
+				if strings.HasPrefix(f, "-std=") {
+					std = strings.TrimPrefix(f, "-std=")
+				}
+			}
+		}
+	}
+	return std
+}
+
+// splitLinkerFlags splits link flags into the flags that are passed through to the linker, i.e.
+// -Wl,<flags> and -Xlinker <flag>, and the flags that are handled by the compiler driver. Flags
+// that reference ninja variables are not expanded and are returned with the driver flags.
+func splitLinkerFlags(flags []string) (linkerFlags, driverFlags []string) {
+	xlinker := false
+	for _, flag := range flags {
+		for _, f := range strings.Fields(flag) {
+			switch {
+			case xlinker:
+				linkerFlags = append(linkerFlags, f)
+				xlinker = false
+			case f == "-Xlinker":
+				linkerFlags = append(linkerFlags, f)
+				xlinker = true
+			case strings.HasPrefix(f, "-Wl,"):
+				linkerFlags = append(linkerFlags, f)
+			default:
+				driverFlags = append(driverFlags, f)
+			}
+		}
+	}
+	return linkerFlags, driverFlags
+}
+
 func addPrefix(list []string, prefix string) []string {
 	for i := range list {
 		list[i] = prefix + list[i]
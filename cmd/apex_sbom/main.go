@@ -0,0 +1,242 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool generates the dependency SBOM of an APEX: a JSON list with an entry for each file in
+// the payload, naming the module the file comes from and, optionally, the build-id of the file if
+// it is an ELF file. With --spdx it writes the same information as an SPDX 2.2 JSON document
+// instead, with a package for the APEX that contains a package for each file.
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type sbomEntry struct {
+	Path    string `json:"path"`
+	Module  string `json:"module"`
+	BuildId string `json:"build_id,omitempty"`
+}
+
+// spdxDocument is the subset of an SPDX 2.2 JSON document written with --spdx.
+type spdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SpdxId            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SpdxId           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	PackageFileName  string `json:"packageFileName,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	Comment          string `json:"comment,omitempty"`
+}
+
+type spdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: apex_sbom -o <output> [--build_ids] [--spdx --name <apex> --version <version>] <path in apex>:<module>:[<file>]...\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	var outputPath string
+	var buildIds bool
+	var spdx bool
+	var apexName string
+	var version string
+
+	flag.StringVar(&outputPath, "o", "", "Path to save the SBOM")
+	flag.BoolVar(&buildIds, "build_ids", false, "Include the build-id of ELF files")
+	flag.BoolVar(&spdx, "spdx", false, "Write an SPDX 2.2 JSON document")
+	flag.StringVar(&apexName, "name", "", "Name of the APEX, for --spdx")
+	flag.StringVar(&version, "version", "", "Version of the APEX and of the files in it, for --spdx")
+	flag.Usage = usage
+	flag.Parse()
+
+	if outputPath == "" || (spdx && apexName == "") {
+		usage()
+	}
+
+	entries := []sbomEntry{}
+	for _, arg := range flag.Args() {
+		split := strings.SplitN(arg, ":", 3)
+		if len(split) != 3 {
+			log.Fatalf("%q is not in the form of <path in apex>:<module>:<file>", arg)
+		}
+
+		// Symlinks that aren't tied to a module have no file.
+		entry := sbomEntry{Path: split[0], Module: split[1]}
+		if buildIds && split[2] != "" {
+			buildId, err := readBuildId(split[2])
+			if err != nil {
+				log.Fatalf("Unable to read build-id of %q: %v", split[2], err)
+			}
+			entry.BuildId = buildId
+		}
+		entries = append(entries, entry)
+	}
+
+	var sbom interface{} = entries
+	if spdx {
+		sbom = spdxDocumentFor(apexName, version, entries)
+	}
+	data, err := json.MarshalIndent(sbom, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(outputPath, append(data, '\n'), 0666); err != nil {
+		log.Fatalf("Unable to write %q: %v", outputPath, err)
+	}
+}
+
+var spdxIdInvalidChars = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+// spdxId returns an SPDX identifier for the given name, which may only contain letters, numbers,
+// '.' and '-'.
+func spdxId(name string) string {
+	return "SPDXRef-" + spdxIdInvalidChars.ReplaceAllString(name, "-")
+}
+
+// spdxDocumentFor returns an SPDX document with a package for the APEX that contains a package for
+// each of the entries. Only the first entry installed to a path is kept, as only one of them ends
+// up in the APEX.
+func spdxDocumentFor(apexName, version string, entries []sbomEntry) spdxDocument {
+	apexId := spdxId("Package-" + apexName)
+	doc := spdxDocument{
+		SpdxVersion:       "SPDX-2.2",
+		DataLicense:       "CC0-1.0",
+		SpdxId:            "SPDXRef-DOCUMENT",
+		Name:              apexName,
+		DocumentNamespace: "https://android.googlesource.com/spdx/apex/" + apexName,
+		Packages: []spdxPackage{{
+			Name:             apexName,
+			SpdxId:           apexId,
+			VersionInfo:      version,
+			DownloadLocation: "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", apexId}},
+	}
+
+	encountered := make(map[string]bool)
+	for _, entry := range entries {
+		if encountered[entry.Path] {
+			continue
+		}
+		encountered[entry.Path] = true
+
+		pkg := spdxPackage{
+			Name:             entry.Module,
+			SpdxId:           spdxId("File-" + apexName + "-" + entry.Path),
+			VersionInfo:      version,
+			PackageFileName:  entry.Path,
+			DownloadLocation: "NOASSERTION",
+		}
+		if entry.BuildId != "" {
+			pkg.Comment = "build-id: " + entry.BuildId
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{apexId, "CONTAINS", pkg.SpdxId})
+	}
+	return doc
+}
+
+// readBuildId returns the GNU build-id of the ELF file at path as a hex string. It returns an
+// empty string if the file is not an ELF file or doesn't have a build-id.
+func readBuildId(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		if _, ok := err.(*elf.FormatError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	defer ef.Close()
+
+	for _, section := range ef.Sections {
+		if section.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return "", err
+		}
+		if buildId := findBuildIdNote(data, ef.ByteOrder); buildId != "" {
+			return buildId, nil
+		}
+	}
+	return "", nil
+}
+
+const ntGnuBuildId = 3
+
+// findBuildIdNote returns the GNU build-id in the contents of a note section, or an empty string if
+// there is none.
+func findBuildIdNote(data []byte, byteOrder binary.ByteOrder) string {
+	// The sizes are widened to 64 bits so that aligning or adding corrupt sizes can't overflow
+	// before they are checked against the remaining data.
+	align4 := func(n uint64) uint64 {
+		return (n + 3) &^ 3
+	}
+
+	for len(data) >= 12 {
+		nameSize := uint64(byteOrder.Uint32(data[0:4]))
+		descSize := uint64(byteOrder.Uint32(data[4:8]))
+		noteType := byteOrder.Uint32(data[8:12])
+		data = data[12:]
+
+		descStart := align4(nameSize)
+		descEnd := descStart + descSize
+		if descEnd > uint64(len(data)) {
+			return ""
+		}
+
+		if noteType == ntGnuBuildId && string(data[:nameSize]) == "GNU\x00" {
+			return hex.EncodeToString(data[descStart:descEnd])
+		}
+
+		next := descStart + align4(descSize)
+		if next > uint64(len(data)) {
+			return ""
+		}
+		data = data[next:]
+	}
+	return ""
+}
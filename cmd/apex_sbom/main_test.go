@@ -0,0 +1,149 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func note(name string, noteType uint32, desc []byte) []byte {
+	pad := func(b []byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(name)))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(desc)))
+	binary.LittleEndian.PutUint32(header[8:12], noteType)
+
+	ret := append(header, pad([]byte(name))...)
+	return append(ret, pad(append([]byte(nil), desc...))...)
+}
+
+// noteHeader returns a note header with the given sizes, which don't have to match any data.
+func noteHeader(nameSize, descSize uint32) []byte {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:4], nameSize)
+	binary.LittleEndian.PutUint32(header[4:8], descSize)
+	binary.LittleEndian.PutUint32(header[8:12], ntGnuBuildId)
+	return append(header, "GNU\x00"...)
+}
+
+func TestFindBuildIdNote(t *testing.T) {
+	buildId := []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89}
+
+	testCases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "build-id only",
+			data: note("GNU\x00", ntGnuBuildId, buildId),
+			want: "deadbeef0123456789",
+		},
+		{
+			name: "build-id after another note",
+			data: append(note("Android\x00", 1, []byte{1, 2, 3, 4}), note("GNU\x00", ntGnuBuildId, buildId)...),
+			want: "deadbeef0123456789",
+		},
+		{
+			name: "no build-id",
+			data: note("GNU\x00", 1, []byte{1, 2, 3, 4}),
+			want: "",
+		},
+		{
+			name: "truncated",
+			data: note("GNU\x00", ntGnuBuildId, buildId)[:20],
+			want: "",
+		},
+		{
+			name: "name size past the end",
+			data: noteHeader(64, 0),
+			want: "",
+		},
+		{
+			name: "name size overflows when aligned",
+			data: noteHeader(0xffffffff, 0),
+			want: "",
+		},
+		{
+			name: "desc size past the end",
+			data: noteHeader(4, 0xfffffffe),
+			want: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := findBuildIdNote(testCase.data, binary.LittleEndian); got != testCase.want {
+				t.Errorf("expected %q, got %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestSpdxDocumentFor(t *testing.T) {
+	doc := spdxDocumentFor("com.android.foo", "BUILD1", []sbomEntry{
+		{Path: "lib64/libfoo.so", Module: "libfoo", BuildId: "deadbeef"},
+		{Path: "lib64/libfoo.so", Module: "libfoo", BuildId: "01234567"},
+		{Path: "etc/foo.conf", Module: "foo.conf"},
+	})
+
+	if doc.SpdxVersion != "SPDX-2.2" || doc.Name != "com.android.foo" {
+		t.Errorf("unexpected document %#v", doc)
+	}
+
+	wantPackages := []spdxPackage{
+		{
+			Name:             "com.android.foo",
+			SpdxId:           "SPDXRef-Package-com.android.foo",
+			VersionInfo:      "BUILD1",
+			DownloadLocation: "NOASSERTION",
+		},
+		{
+			Name:             "libfoo",
+			SpdxId:           "SPDXRef-File-com.android.foo-lib64-libfoo.so",
+			VersionInfo:      "BUILD1",
+			PackageFileName:  "lib64/libfoo.so",
+			DownloadLocation: "NOASSERTION",
+			Comment:          "build-id: deadbeef",
+		},
+		{
+			Name:             "foo.conf",
+			SpdxId:           "SPDXRef-File-com.android.foo-etc-foo.conf",
+			VersionInfo:      "BUILD1",
+			PackageFileName:  "etc/foo.conf",
+			DownloadLocation: "NOASSERTION",
+		},
+	}
+	if !reflect.DeepEqual(doc.Packages, wantPackages) {
+		t.Errorf("expected packages %#v, got %#v", wantPackages, doc.Packages)
+	}
+
+	wantRelationships := []spdxRelationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-com.android.foo"},
+		{"SPDXRef-Package-com.android.foo", "CONTAINS", "SPDXRef-File-com.android.foo-lib64-libfoo.so"},
+		{"SPDXRef-Package-com.android.foo", "CONTAINS", "SPDXRef-File-com.android.foo-etc-foo.conf"},
+	}
+	if !reflect.DeepEqual(doc.Relationships, wantRelationships) {
+		t.Errorf("expected relationships %#v, got %#v", wantRelationships, doc.Relationships)
+	}
+}
@@ -15,28 +15,73 @@
 // This tool extracts ELF LOAD segments from our linker binary, and produces an
 // assembly file and linker flags which will embed those segments as sections
 // in another binary.
+//
+// Alternatively, with -bin, it produces a raw binary file with the segments
+// concatenated, for toolchains that embed it with objcopy, along with a file
+// listing the offset and the load address of each segment.
+//
+// Extra ELF notes, e.g. a version note that tools look for in the embedded linker, can be added
+// to the assembly file with -note name:type:hexdata, which may be repeated.
 package main
 
 import (
 	"bytes"
 	"debug/elf"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
+// An ELF note to add to the assembly file.
+type elfNote struct {
+	name     string
+	noteType uint32
+	desc     []byte
+}
+
+// noteFlags collects the values of the repeatable -note flag.
+type noteFlags []elfNote
+
+func (n *noteFlags) String() string {
+	return ""
+}
+
+func (n *noteFlags) Set(s string) error {
+	note, err := parseNote(s)
+	if err != nil {
+		return err
+	}
+	*n = append(*n, note)
+	return nil
+}
+
 func main() {
 	var asmPath string
 	var flagsPath string
+	var binPath string
+	var symsPath string
+	var notes noteFlags
 
 	flag.StringVar(&asmPath, "s", "", "Path to save the assembly file")
 	flag.StringVar(&flagsPath, "f", "", "Path to save the linker flags")
+	flag.StringVar(&binPath, "bin", "", "Path to save the raw binary file")
+	flag.StringVar(&symsPath, "syms", "", "Path to save the symbol offsets of the raw binary file")
+	flag.Var(&notes, "note", "ELF note to add to the assembly file, as name:type:hexdata (may be repeated)")
 	flag.Parse()
 
+	if (binPath != "" || symsPath != "") && (asmPath != "" || flagsPath != "") {
+		log.Fatalf("-bin and -syms cannot be used with -s or -f")
+	}
+	if len(notes) > 0 && asmPath == "" {
+		log.Fatalf("-note can only be used with -s")
+	}
+
 	f, err := os.Open(flag.Arg(0))
 	if err != nil {
 		log.Fatalf("Error opening %q: %v", flag.Arg(0), err)
@@ -49,9 +94,11 @@ func main() {
 	}
 
 	asm := &bytes.Buffer{}
+	bin := &bytes.Buffer{}
 	baseLoadAddr := uint64(0x1000)
 	load := 0
 	linkFlags := []string{}
+	syms := []string{fmt.Sprintf("__dlwrap_linker_offset 0x%x", baseLoadAddr)}
 
 	fmt.Fprintln(asm, ".globl __dlwrap_linker_offset")
 	fmt.Fprintf(asm, ".set __dlwrap_linker_offset, 0x%x\n", baseLoadAddr)
@@ -94,9 +141,17 @@ func main() {
 		}
 		fmt.Fprintln(asm)
 
+		offset := appendSegment(bin, buffer, prog.Memsz-prog.Filesz)
+		// Each line is the symbol, its offset in the binary file and its load address.
+		syms = append(syms, fmt.Sprintf("%s 0x%x 0x%x", symName, offset, baseLoadAddr+prog.Vaddr))
+
 		load += 1
 	}
 
+	for _, note := range notes {
+		noteToAsm(asm, note)
+	}
+
 	if asmPath != "" {
 		if err := ioutil.WriteFile(asmPath, asm.Bytes(), 0777); err != nil {
 			log.Fatalf("Unable to write %q: %v", asmPath, err)
@@ -109,6 +164,72 @@ func main() {
 			log.Fatalf("Unable to write %q: %v", flagsPath, err)
 		}
 	}
+
+	if binPath != "" {
+		if err := ioutil.WriteFile(binPath, bin.Bytes(), 0777); err != nil {
+			log.Fatalf("Unable to write %q: %v", binPath, err)
+		}
+	}
+
+	if symsPath != "" {
+		content := strings.Join(syms, "\n") + "\n"
+		if err := ioutil.WriteFile(symsPath, []byte(content), 0777); err != nil {
+			log.Fatalf("Unable to write %q: %v", symsPath, err)
+		}
+	}
+}
+
+// appendSegment appends the contents of a LOAD segment followed by the zeros of
+// its BSS to the binary file, and returns the offset of the segment in it.
+func appendSegment(bin *bytes.Buffer, buf []byte, bssSize uint64) int {
+	offset := bin.Len()
+	bin.Write(buf)
+	bin.Write(make([]byte, bssSize))
+	return offset
+}
+
+// parseNote parses the value of a -note flag, name:type:hexdata, where the type is a decimal or a
+// 0x prefixed hexadecimal number. The name is written as is into the section name and a string
+// of the assembly file, so it may only contain letters, digits, '.', '_' and '-'.
+func parseNote(s string) (elfNote, error) {
+	split := strings.SplitN(s, ":", 3)
+	if len(split) != 3 || split[0] == "" {
+		return elfNote{}, fmt.Errorf("%q is not name:type:hexdata", s)
+	}
+	if i := strings.IndexFunc(split[0], func(r rune) bool { return !isNoteNameChar(r) }); i != -1 {
+		return elfNote{}, fmt.Errorf("invalid character %q in the name of note %q", split[0][i], s)
+	}
+	noteType, err := strconv.ParseUint(split[1], 0, 32)
+	if err != nil {
+		return elfNote{}, fmt.Errorf("invalid type in note %q: %v", s, err)
+	}
+	desc, err := hex.DecodeString(split[2])
+	if err != nil {
+		return elfNote{}, fmt.Errorf("invalid data in note %q: %v", s, err)
+	}
+	return elfNote{name: split[0], noteType: uint32(noteType), desc: desc}, nil
+}
+
+func isNoteNameChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '.' || r == '_' || r == '-'
+}
+
+// noteToAsm writes a note section with the given note. The name and the data are padded to 4
+// bytes like in the notes of both 32-bit and 64-bit ELF files.
+func noteToAsm(asm io.Writer, note elfNote) {
+	fmt.Fprintf(asm, ".section .note.%s, \"a\", %%note\n", note.name)
+	fmt.Fprintln(asm, ".balign 4")
+	fmt.Fprintf(asm, ".long %d\n", len(note.name)+1)
+	fmt.Fprintf(asm, ".long %d\n", len(note.desc))
+	fmt.Fprintf(asm, ".long %d\n", note.noteType)
+	fmt.Fprintf(asm, ".asciz \"%s\"\n", note.name)
+	fmt.Fprintln(asm, ".balign 4")
+	if len(note.desc) > 0 {
+		bytesToAsm(asm, note.desc)
+		fmt.Fprintln(asm, ".balign 4")
+	}
+	fmt.Fprintln(asm)
 }
 
 func bytesToAsm(asm io.Writer, buf []byte) {
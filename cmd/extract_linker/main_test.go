@@ -61,3 +61,52 @@ func TestBytesToAsm(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendSegment(t *testing.T) {
+	bin := &bytes.Buffer{}
+	if offset := appendSegment(bin, []byte{1, 2, 3}, 2); offset != 0 {
+		t.Errorf("expected the first segment at offset 0, got %d", offset)
+	}
+	if offset := appendSegment(bin, []byte{4}, 0); offset != 5 {
+		t.Errorf("expected the second segment at offset 5, got %d", offset)
+	}
+	want := []byte{1, 2, 3, 0, 0, 4}
+	if !bytes.Equal(bin.Bytes(), want) {
+		t.Errorf("want: %#v\n got: %#v", want, bin.Bytes())
+	}
+}
+
+func TestParseNote(t *testing.T) {
+	note, err := parseNote("Android:0x3:0102ff")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if note.name != "Android" || note.noteType != 3 || !bytes.Equal(note.desc, []byte{1, 2, 0xff}) {
+		t.Errorf("unexpected note %#v", note)
+	}
+
+	for _, s := range []string{"Android", ":1:00", "Android:x:00", "Android:1:0",
+		"And\"roid:1:00", "And roid:1:00", "And\nroid:1:00"} {
+		if _, err := parseNote(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestNoteToAsm(t *testing.T) {
+	buf := bytes.Buffer{}
+	noteToAsm(&buf, elfNote{name: "Android", noteType: 3, desc: []byte{1, 2}})
+	want := ".section .note.Android, \"a\", %note\n" +
+		".balign 4\n" +
+		".long 8\n" +
+		".long 2\n" +
+		".long 3\n" +
+		".asciz \"Android\"\n" +
+		".balign 4\n" +
+		".byte 1,2\n" +
+		".balign 4\n" +
+		"\n"
+	if buf.String() != want {
+		t.Errorf("want: %q\n got: %q", want, buf.String())
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
@@ -28,11 +29,12 @@ import (
 )
 
 var (
-	sandboxesRoot string
-	rawCommand    string
-	outputRoot    string
-	keepOutDir    bool
-	depfileOut    string
+	sandboxesRoot  string
+	rawCommand     string
+	outputRoot     string
+	keepOutDir     bool
+	depfileOut     string
+	writeIfChanged bool
 )
 
 func init() {
@@ -44,6 +46,8 @@ func init() {
 		"root of directory to copy outputs into")
 	flag.BoolVar(&keepOutDir, "keep-out-dir", false,
 		"whether to keep the sandbox directory when done")
+	flag.BoolVar(&writeIfChanged, "write-if-changed", false,
+		"whether to keep the existing output files whose contents didn't change, so that their timestamps are preserved for restat rules")
 
 	flag.StringVar(&depfileOut, "depfile-out", "",
 		"file path of the depfile to generate. This value will replace '__SBOX_DEPFILE__' in the command and will be treated as an output but won't be added to __SBOX_OUT_FILES__")
@@ -56,7 +60,7 @@ func usageViolation(violation string) {
 	}
 
 	fmt.Fprintf(os.Stderr,
-		"Usage: sbox -c <commandToRun> --sandbox-path <sandboxPath> --output-root <outputRoot> --overwrite [--depfile-out depFile] <outputFile> [<outputFile>...]\n"+
+		"Usage: sbox -c <commandToRun> --sandbox-path <sandboxPath> --output-root <outputRoot> --overwrite [--depfile-out depFile] [--write-if-changed] <outputFile> [<outputFile>...]\n"+
 			"\n"+
 			"Deletes <outputRoot>,"+
 			"runs <commandToRun>,"+
@@ -96,6 +100,22 @@ func findAllFilesUnder(root string) (paths []string) {
 	return paths
 }
 
+// sameContents returns whether the file at destPath exists and has the same contents as the file
+// at tempPath.
+func sameContents(tempPath, destPath string) (bool, error) {
+	dest, err := ioutil.ReadFile(destPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	temp, err := ioutil.ReadFile(tempPath)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(temp, dest), nil
+}
+
 func run() error {
 	if rawCommand == "" {
 		usageViolation("-c <commandToRun> is required and must be non-empty")
@@ -126,9 +146,13 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	err = os.RemoveAll(outputRoot)
-	if err != nil {
-		return err
+	if !writeIfChanged {
+		// The previous outputs are compared to the new ones before they are replaced when
+		// writeIfChanged is set.
+		err = os.RemoveAll(outputRoot)
+		if err != nil {
+			return err
+		}
 	}
 	err = os.MkdirAll(outputRoot, 0777)
 	if err != nil {
@@ -267,6 +291,16 @@ func run() error {
 			return err
 		}
 
+		if writeIfChanged {
+			unchanged, err := sameContents(tempPath, destPath)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				continue
+			}
+		}
+
 		// Update the timestamp of the output file in case the tool wrote an old timestamp (for example, tar can extract
 		// files with old timestamps).
 		now := time.Now()
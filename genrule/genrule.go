@@ -49,6 +49,13 @@ type SourceFileGenerator interface {
 	GeneratedDeps() android.Paths
 }
 
+// SourceFileFlagsGenerator is implemented by the generators whose generated sources need extra
+// flags to be compiled with, e.g. to disable a warning for machine-generated code.
+type SourceFileFlagsGenerator interface {
+	// Returns the extra cflags of each generated source that needs them, keyed by its path.
+	GeneratorSourceFlags() map[string][]string
+}
+
 // Alias for android.HostToolProvider
 // Deprecated: use android.HostToolProvider instead.
 type HostToolProvider interface {
@@ -81,6 +88,12 @@ type generatorProperties struct {
 	// Enable reading a file containing dependencies in gcc format after the command completes
 	Depfile *bool
 
+	// Only replace the outputs whose contents changed when the command reruns, so that the modules
+	// depending on them are not rebuilt, e.g. cc modules including generated headers, whose compiles
+	// depend on the headers through their depfiles. Outputs that are no longer declared are not
+	// removed from the output directory.
+	Restat *bool
+
 	// name of the modules (if any) that produces the host executable.   Leave empty for
 	// prebuilts or scripts that do not need a module to build them.
 	Tools []string
@@ -96,6 +109,10 @@ type generatorProperties struct {
 
 	// input files to exclude
 	Exclude_srcs []string `android:"path,arch_variant"`
+
+	// list of extra flags that cc modules compile the generated sources with, e.g.
+	// "-Wno-unused-parameter" for generated code that doesn't use all of its parameters
+	Generated_source_cflags []string
 }
 
 type Module struct {
@@ -147,6 +164,17 @@ func (g *Module) GeneratedDeps() android.Paths {
 	return g.outputDeps
 }
 
+func (g *Module) GeneratorSourceFlags() map[string][]string {
+	if len(g.properties.Generated_source_cflags) == 0 {
+		return nil
+	}
+	flags := make(map[string][]string)
+	for _, out := range g.outputFiles {
+		flags[out.String()] = g.properties.Generated_source_cflags
+	}
+	return flags
+}
+
 func (g *Module) DepsMutator(ctx android.BottomUpMutatorContext) {
 	if g, ok := ctx.Module().(*Module); ok {
 		for _, tool := range g.properties.Tools {
@@ -370,12 +398,17 @@ func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	// Escape the command for the shell
 	rawCommand = "'" + strings.Replace(rawCommand, "'", `'\''`, -1) + "'"
 	g.rawCommand = rawCommand
-	sandboxCommand := fmt.Sprintf("$sboxCmd --sandbox-path %s --output-root %s -c %s %s $allouts",
-		sandboxPath, genDir, rawCommand, depfilePlaceholder)
+	sandboxFlags := ""
+	if Bool(g.properties.Restat) {
+		sandboxFlags = "--write-if-changed "
+	}
+	sandboxCommand := fmt.Sprintf("$sboxCmd --sandbox-path %s --output-root %s %s-c %s %s $allouts",
+		sandboxPath, genDir, sandboxFlags, rawCommand, depfilePlaceholder)
 
 	ruleParams := blueprint.RuleParams{
 		Command:     sandboxCommand,
 		CommandDeps: []string{"$sboxCmd"},
+		Restat:      Bool(g.properties.Restat),
 	}
 	args := []string{"allouts"}
 	if Bool(g.properties.Depfile) {
@@ -559,3 +559,43 @@ func (t *testTool) HostToolPath() android.OptionalPath {
 }
 
 var _ android.HostToolProvider = (*testTool)(nil)
+
+func TestGenruleRestat(t *testing.T) {
+	config := android.TestArchConfig(buildDir, nil)
+	bp := `
+				genrule {
+					name: "gen",
+					out: ["out.h"],
+					cmd: "echo '#define FOO 1' > $(out)",
+					restat: true,
+				}
+
+				genrule {
+					name: "gen_no_restat",
+					out: ["out.h"],
+					cmd: "echo '#define FOO 1' > $(out)",
+				}
+			`
+	ctx := testContext(config, bp, nil)
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	if errs == nil {
+		_, errs = ctx.PrepareBuildActions(config)
+	}
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	gen := ctx.ModuleForTests("gen", "").Rule("generator")
+	if !gen.RuleParams.Restat {
+		t.Errorf("expected the generator rule to restat its outputs")
+	}
+	if !strings.Contains(gen.RuleParams.Command, "--write-if-changed") {
+		t.Errorf("expected sbox to keep unchanged outputs, got %q", gen.RuleParams.Command)
+	}
+
+	noRestat := ctx.ModuleForTests("gen_no_restat", "").Rule("generator")
+	if noRestat.RuleParams.Restat || strings.Contains(noRestat.RuleParams.Command, "--write-if-changed") {
+		t.Errorf("expected the generator rule not to restat its outputs by default, got %q",
+			noRestat.RuleParams.Command)
+	}
+}
@@ -0,0 +1,67 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for setting fields of a JSON object, e.g. of an APEX manifest."""
+
+from __future__ import print_function
+import argparse
+import collections
+import json
+import sys
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--set', nargs=2, action='append', default=[], dest='fields',
+                      metavar=('KEY', 'VALUE'),
+                      help='set the field KEY of the object to VALUE, which is JSON')
+  parser.add_argument('input', help='input JSON file')
+  parser.add_argument('output', help='output JSON file')
+  return parser.parse_args()
+
+
+def set_fields(obj, fields):
+  """Set each of the (key, JSON value) fields of obj, replacing existing ones."""
+
+  if not isinstance(obj, dict):
+    raise RuntimeError('expected a JSON object')
+  for key, value in fields:
+    obj[key] = json.loads(value, object_pairs_hook=collections.OrderedDict)
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    with open(args.input) as f:
+      obj = json.load(f, object_pairs_hook=collections.OrderedDict)
+
+    set_fields(obj, args.fields)
+
+    with open(args.output, 'w') as f:
+      json.dump(obj, f, indent=2, separators=(',', ': '))
+      f.write('\n')
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,56 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for jsonmodify.py."""
+
+import collections
+import json
+import sys
+import unittest
+
+import jsonmodify
+
+sys.dont_write_bytecode = True
+
+
+class SetFieldsTest(unittest.TestCase):
+  """Unit tests for set_fields function."""
+
+  def set_fields_test(self, input_json, fields):
+    obj = json.loads(input_json, object_pairs_hook=collections.OrderedDict)
+    jsonmodify.set_fields(obj, fields)
+    return json.dumps(obj)
+
+  def test_empty(self):
+    """Test setting fields of an empty object."""
+    output = self.set_fields_test('{}', [('postInstallHook', '"bin/hook.sh"')])
+    self.assertEqual(output, '{"postInstallHook": "bin/hook.sh"}')
+
+  def test_existing_fields(self):
+    """Test that existing fields are kept in order and replaced in place."""
+    output = self.set_fields_test('{"name": "com.android.foo", "version": 1}',
+                                  [('version', '2'),
+                                   ('providedSysprops', '{"ro.foo": "true"}')])
+    self.assertEqual(output, '{"name": "com.android.foo", "version": 2, '
+                     '"providedSysprops": {"ro.foo": "true"}}')
+
+  def test_not_an_object(self):
+    """Test that only the fields of an object can be set."""
+    self.assertRaises(RuntimeError, self.set_fields_test, '[]', [('version', '1')])
+
+
+if __name__ == '__main__':
+  unittest.main()
@@ -25,6 +25,7 @@
 #   -o ${file}: output file (required)
 #   -d ${file}: deps file (required)
 #   -k symbols: Symbols to keep (optional)
+#   -t ${file}: strip tool to use instead of llvm-strip or strip (optional)
 #   --add-gnu-debuglink
 #   --keep-mini-debug-info
 #   --keep-symbols
@@ -33,12 +34,13 @@
 
 set -o pipefail
 
-OPTSTRING=d:i:o:k:-:
+OPTSTRING=d:i:o:k:t:-:
 
 usage() {
     cat <<EOF
 Usage: strip.sh [options] -k symbols -i in-file -o out-file -d deps-file
 Options:
+        -t strip-tool           Use strip-tool instead of llvm-strip or strip
         --add-gnu-debuglink     Add a gnu-debuglink section to out-file
         --keep-mini-debug-info  Keep compressed debug info in out-file
         --keep-symbols          Keep symbols in out-file
@@ -56,7 +58,9 @@ EOF
 do_strip() {
     # ${CROSS_COMPILE}strip --strip-all does not strip .ARM.attributes,
     # so we tell llvm-strip to keep it too.
-    if [ -z "${use_gnu_strip}" ]; then
+    if [ ! -z "${strip_tool}" ]; then
+        "${strip_tool}" --strip-all "${infile}" -o "${outfile}.tmp"
+    elif [ -z "${use_gnu_strip}" ]; then
         "${CLANG_BIN}/llvm-strip" --strip-all -keep-section=.ARM.attributes "${infile}" -o "${outfile}.tmp"
     else
         "${CROSS_COMPILE}strip" --strip-all "${infile}" -o "${outfile}.tmp"
@@ -89,7 +93,9 @@ do_strip_keep_symbol_list() {
 do_strip_keep_mini_debug_info() {
     rm -f "${outfile}.dynsyms" "${outfile}.funcsyms" "${outfile}.keep_symbols" "${outfile}.debug" "${outfile}.mini_debuginfo" "${outfile}.mini_debuginfo.xz"
     local fail=
-    if [ -z "${use_gnu_strip}" ]; then
+    if [ ! -z "${strip_tool}" ]; then
+        "${strip_tool}" --strip-all -R .comment "${infile}" -o "${outfile}.tmp" || fail=true
+    elif [ -z "${use_gnu_strip}" ]; then
         "${CLANG_BIN}/llvm-strip" --strip-all -keep-section=.ARM.attributes -remove-section=.comment "${infile}" -o "${outfile}.tmp" || fail=true
     else
         "${CROSS_COMPILE}strip" --strip-all -R .comment "${infile}" -o "${outfile}.tmp" || fail=true
@@ -128,7 +134,9 @@ do_add_gnu_debuglink() {
 }
 
 do_remove_build_id() {
-    if [ -z "${use_gnu_strip}" ]; then
+    if [ ! -z "${strip_tool}" ]; then
+        "${strip_tool}" --remove-section=.note.gnu.build-id "${outfile}.tmp" -o "${outfile}.tmp.no-build-id"
+    elif [ -z "${use_gnu_strip}" ]; then
         "${CLANG_BIN}/llvm-strip" -remove-section=.note.gnu.build-id "${outfile}.tmp" -o "${outfile}.tmp.no-build-id"
     else
         "${CROSS_COMPILE}strip" --remove-section=.note.gnu.build-id "${outfile}.tmp" -o "${outfile}.tmp.no-build-id"
@@ -143,6 +151,7 @@ while getopts $OPTSTRING opt; do
         i) infile="${OPTARG}" ;;
         o) outfile="${OPTARG}" ;;
         k) symbols_to_keep="${OPTARG}" ;;
+        t) strip_tool="${OPTARG}" ;;
         -)
             case "${OPTARG}" in
                 add-gnu-debuglink) add_gnu_debuglink=true ;;
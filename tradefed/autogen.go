@@ -81,6 +81,22 @@ func (p Preparer) Config() string {
 	return fmt.Sprintf(`<target_preparer class="%s" />`, p.Class)
 }
 
+type Object struct {
+	Type    string
+	Class   string
+	Options []Option
+}
+
+var _ Config = Object{}
+
+func (ob Object) Config() string {
+	var options []string
+	for _, o := range ob.Options {
+		options = append(options, o.Config())
+	}
+	return fmt.Sprintf(`<object type="%s" class="%s">%s</object>`, ob.Type, ob.Class, strings.Join(options, ""))
+}
+
 func autogenTemplate(ctx android.ModuleContext, output android.WritablePath, template string, configs []Config) {
 	var configStrings []string
 	for _, config := range configs {